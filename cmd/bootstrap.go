@@ -0,0 +1,153 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+
+	. "github.com/mudler/luet/pkg/config"
+	installer "github.com/mudler/luet/pkg/installer"
+	. "github.com/mudler/luet/pkg/logger"
+	pkg "github.com/mudler/luet/pkg/package"
+
+	"github.com/spf13/cobra"
+)
+
+var bootstrapCmd = &cobra.Command{
+	Use:   "bootstrap --target <dir> <pkg1> <pkg2> ...",
+	Short: "Create a new rootfs with the given packages",
+	Long: `Initialize a new system in the target directory, with its database inside it, and install
+the packages with their finalizers.
+
+With --output, the rootfs is exported as a tarball (compressed if the file ends with .gz or .tgz)
+or, with --format oci, as an OCI image layout directory. The package caches are never exported,
+and the installed packages database is left out of the export with --strip-db.`,
+	Example: `
+$> luet bootstrap --target ./rootfs system/base
+$> luet bootstrap --target ./rootfs --output rootfs.tar.gz --strip-db system/base
+$> luet bootstrap --target ./rootfs --output ./image --format oci --image-ref 1.0 system/base
+`,
+	Args: cobra.MinimumNArgs(1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		LuetCfg.Viper.BindPFlag("solver.type", cmd.Flags().Lookup("solver-type"))
+		LuetCfg.Viper.BindPFlag("solver.discount", cmd.Flags().Lookup("solver-discount"))
+		LuetCfg.Viper.BindPFlag("solver.rate", cmd.Flags().Lookup("solver-rate"))
+		LuetCfg.Viper.BindPFlag("solver.max_attempts", cmd.Flags().Lookup("solver-attempts"))
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var toInstall []pkg.Package
+
+		target, _ := cmd.Flags().GetString("target")
+		output, _ := cmd.Flags().GetString("output")
+		format, _ := cmd.Flags().GetString("format")
+		ref, _ := cmd.Flags().GetString("image-ref")
+		stripDB, _ := cmd.Flags().GetBool("strip-db")
+
+		if target == "" {
+			Fatal("A target directory is required")
+		}
+		if format != installer.ExportTar && format != installer.ExportOCI {
+			Fatal("Invalid format ", format, ": must be tar or oci")
+		}
+		if stripDB && output == "" {
+			Fatal("--strip-db requires --output")
+		}
+
+		for _, a := range args {
+			pack := packageFromArg(a)
+			toInstall = append(toInstall, pack)
+		}
+
+		target, err := filepath.Abs(target)
+		if err != nil {
+			Fatal("Error: " + err.Error())
+		}
+		// The repositories and the packages are cached inside the new system, as with --system-target
+		LuetCfg.GetSystem().Rootfs = target
+		dbPath := LuetCfg.GetSystem().DatabasePath
+
+		repos := installer.Repositories{}
+		for _, repo := range LuetCfg.SystemRepositories {
+			if !repo.Enable {
+				continue
+			}
+			r := installer.NewSystemRepository(repo)
+			repos = append(repos, r)
+		}
+
+		stype := LuetCfg.Viper.GetString("solver.type")
+		discount := LuetCfg.Viper.GetFloat64("solver.discount")
+		rate := LuetCfg.Viper.GetFloat64("solver.rate")
+		attempts := LuetCfg.Viper.GetInt("solver.max_attempts")
+
+		LuetCfg.GetSolverOptions().Type = stype
+		LuetCfg.GetSolverOptions().LearnRate = float32(rate)
+		LuetCfg.GetSolverOptions().Discount = float32(discount)
+		LuetCfg.GetSolverOptions().MaxAttempts = attempts
+
+		Debug("Solver", LuetCfg.GetSolverOptions().CompactString())
+
+		inst := newInstaller()
+		inst.Repositories(repos)
+
+		system, err := installer.BootstrapSystem(target, dbPath)
+		if err != nil {
+			Fatal("Error: " + err.Error())
+		}
+		if err := inst.Install(toInstall, system); err != nil {
+			Fatal("Error: " + err.Error())
+		}
+
+		if output == "" {
+			return
+		}
+
+		opts := installer.ExportOptions{Reference: ref}
+		if cache := LuetCfg.GetSystem().GetSystemPkgsCacheDirPath(); strings.HasPrefix(cache, target+string(filepath.Separator)) {
+			opts.Exclude = append(opts.Exclude, strings.TrimPrefix(cache, target))
+		}
+		if stripDB {
+			opts.Exclude = append(opts.Exclude, dbPath)
+		} else {
+			opts.Exclude = append(opts.Exclude, filepath.Join(dbPath, "repos"))
+		}
+
+		if format == installer.ExportOCI {
+			err = installer.ExportOCILayout(target, output, opts)
+		} else {
+			err = installer.ExportTarball(target, output, opts)
+		}
+		if err != nil {
+			Fatal("Error: " + err.Error())
+		}
+		Info("Exported", target, "to", output)
+	},
+}
+
+func init() {
+	bootstrapCmd.Flags().String("target", "", "Directory of the new rootfs")
+	bootstrapCmd.Flags().String("output", "", "Export the rootfs to the given tarball or OCI image layout directory")
+	bootstrapCmd.Flags().String("format", installer.ExportTar, "Format of the export (tar, oci)")
+	bootstrapCmd.Flags().String("image-ref", "latest", "Reference name of the image in the OCI image layout")
+	bootstrapCmd.Flags().Bool("strip-db", false, "Leave the installed packages database out of the export")
+	bootstrapCmd.Flags().String("solver-type", "", "Solver strategy ( Defaults none, available: "+AvailableResolvers+" )")
+	bootstrapCmd.Flags().Float32("solver-rate", 0.7, "Solver learning rate")
+	bootstrapCmd.Flags().Float32("solver-discount", 1.0, "Solver discount rate")
+	bootstrapCmd.Flags().Int("solver-attempts", 9000, "Solver maximum attempts")
+
+	RootCmd.AddCommand(bootstrapCmd)
+}
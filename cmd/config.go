@@ -31,6 +31,7 @@ var configCmd = &cobra.Command{
 		fmt.Println(config.LuetCfg.GetLogging())
 		fmt.Println(config.LuetCfg.GetGeneral())
 		fmt.Println(config.LuetCfg.GetSystem())
+		fmt.Println(config.LuetCfg.GetDownload())
 		if len(config.LuetCfg.CacheRepositories) > 0 {
 			fmt.Println("repetitors:")
 			for _, r := range config.LuetCfg.CacheRepositories {
@@ -43,6 +43,7 @@ var createrepoCmd = &cobra.Command{
 		viper.BindPFlag("tree-path", cmd.Flags().Lookup("tree-path"))
 		viper.BindPFlag("reset-revision", cmd.Flags().Lookup("reset-revision"))
 		viper.BindPFlag("repo", cmd.Flags().Lookup("repo"))
+		viper.BindPFlag("push", cmd.Flags().Lookup("push"))
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		var err error
@@ -59,6 +60,7 @@ var createrepoCmd = &cobra.Command{
 		treetype := viper.GetString("tree-compression")
 		treepath := viper.GetString("tree-path")
 		source_repo := viper.GetString("repo")
+		push := viper.GetBool("push")
 
 		if source_repo != "" {
 			// Search for system repository
@@ -104,6 +106,20 @@ var createrepoCmd = &cobra.Command{
 		if err != nil {
 			Fatal("Error: " + err.Error())
 		}
+
+		if push {
+			if source_repo != "" {
+				lrepo, err := LuetCfg.GetSystemRepository(source_repo)
+				if err != nil {
+					Fatal("Error: " + err.Error())
+				}
+				repo.SetAuthentication(lrepo.Authentication)
+			}
+			err = repo.Push(packages, dst)
+			if err != nil {
+				Fatal("Error: " + err.Error())
+			}
+		}
 	},
 }
 
@@ -118,9 +134,10 @@ func init() {
 	createrepoCmd.Flags().String("name", "luet", "Repository name")
 	createrepoCmd.Flags().String("descr", "luet", "Repository description")
 	createrepoCmd.Flags().StringSlice("urls", []string{}, "Repository URLs")
-	createrepoCmd.Flags().String("type", "disk", "Repository type (disk)")
+	createrepoCmd.Flags().String("type", "disk", "Repository type (disk, http, docker)")
 	createrepoCmd.Flags().Bool("reset-revision", false, "Reset repository revision.")
 	createrepoCmd.Flags().String("repo", "", "Use repository defined in configuration.")
+	createrepoCmd.Flags().Bool("push", false, "Push the repository to its urls (docker repositories)")
 
 	createrepoCmd.Flags().String("tree-compression", "none", "Compression alg: none, gzip")
 	createrepoCmd.Flags().String("tree-path", installer.TREE_TARBALL, "Repository tree filename")
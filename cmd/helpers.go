@@ -0,0 +1,83 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	. "github.com/mudler/luet/pkg/config"
+	installer "github.com/mudler/luet/pkg/installer"
+	. "github.com/mudler/luet/pkg/logger"
+	pkg "github.com/mudler/luet/pkg/package"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+)
+
+// packageFromArg parses a package given on the command line (e.g. cat/foo, >=cat/foo-1.0, cat/foo:2).
+// Without a version, it matches any version of the package.
+func packageFromArg(arg string) *pkg.DefaultPackage {
+	gp, err := _gentoo.ParsePackageStr(arg)
+	if err != nil {
+		Fatal("Invalid package string ", arg, ": ", err.Error())
+	}
+
+	if gp.Version == "" {
+		gp.Version = "0"
+		gp.Condition = _gentoo.PkgCondGreaterEqual
+	}
+
+	pack := &pkg.DefaultPackage{
+		Name: gp.Name,
+		Version: fmt.Sprintf("%s%s%s",
+			pkg.PkgSelectorConditionFromInt(gp.Condition.Int()).String(),
+			gp.Version,
+			gp.VersionSuffix,
+		),
+		Category: gp.Category,
+		Uri:      make([]string, 0),
+	}
+	// "0" is the default slot of the parser, which maps to packages without a slot
+	if gp.Slot != "0" {
+		pack.SetSlot(gp.Slot)
+	}
+	return pack
+}
+
+// systemDatabase returns the database of the packages installed in the system
+func systemDatabase() pkg.PackageDatabase {
+	if LuetCfg.GetSystem().DatabaseEngine == "boltdb" {
+		return pkg.NewBoltDatabase(
+			filepath.Join(LuetCfg.GetSystem().GetSystemRepoDatabaseDirPath(), "luet.db"))
+	}
+	return pkg.NewInMemoryDatabase(true)
+}
+
+// installerOptions returns the installer options set in the luet configuration
+func installerOptions() installer.LuetInstallerOptions {
+	return installer.LuetInstallerOptions{
+		Concurrency:         LuetCfg.GetGeneral().Concurrency,
+		DownloadConcurrency: LuetCfg.GetDownload().Concurrency,
+		SolverOptions:       *LuetCfg.GetSolverOptions(),
+		HooksDir:            LuetCfg.GetSystem().HooksDir,
+		TriggersDir:         LuetCfg.GetSystem().TriggersDir,
+	}
+}
+
+// newInstaller returns an installer configured as in the luet configuration
+func newInstaller() installer.Installer {
+	return installer.NewLuetInstaller(installerOptions())
+}
@@ -0,0 +1,67 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd_history
+
+import (
+	"fmt"
+	"os"
+
+	. "github.com/mudler/luet/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+func NewHistoryCommand() *cobra.Command {
+	var ans = &cobra.Command{
+		Use:   "history",
+		Short: "Show the transactions history of the system",
+		Long: `List the transactions which changed the system: installations, removals and upgrades,
+with the number of packages added (+), removed (-) and replaced (~).`,
+		Example: `
+$> luet history
+$> luet history show 3
+$> luet history undo 3
+`,
+		Args: cobra.NoArgs,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			bindSystemFlags(cmd)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			history, err := systemDatabase().GetTransactions()
+			if err != nil {
+				Fatal("Error: " + err.Error())
+			}
+
+			for _, t := range history {
+				outcome := "ok"
+				if !t.Succeeded() {
+					outcome = "failed"
+				}
+				fmt.Printf("%4d  %s  +%d -%d ~%d  %-6s  %s\n", t.ID, t.Timestamp.Format("2006-01-02 15:04:05"),
+					len(t.Added), len(t.Removed), len(t.Replaced), outcome, t.Command)
+			}
+		},
+	}
+
+	path, err := os.Getwd()
+	if err != nil {
+		Fatal(err)
+	}
+	ans.PersistentFlags().String("system-dbpath", path, "System db path")
+	ans.PersistentFlags().String("system-target", path, "System rootpath")
+
+	return ans
+}
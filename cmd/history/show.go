@@ -0,0 +1,65 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd_history
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/mudler/luet/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+func NewHistoryShowCommand() *cobra.Command {
+	var ans = &cobra.Command{
+		Use:   "show <id>",
+		Short: "Show a transaction",
+		Args:  cobra.ExactArgs(1),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			bindSystemFlags(cmd)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			t, err := systemDatabase().GetTransaction(transactionID(args[0]))
+			if err != nil {
+				Fatal("Error: " + err.Error())
+			}
+
+			fmt.Println("Transaction:", t.ID)
+			fmt.Println("Date:       ", t.Timestamp.Format(time.RFC3339))
+			fmt.Println("Command:    ", t.Command)
+			if t.Operation != "" {
+				fmt.Println("Operation:  ", t.Operation)
+			}
+			if t.Succeeded() {
+				fmt.Println("Outcome:     ok")
+			} else {
+				fmt.Println("Outcome:     failed:", t.Error)
+			}
+			for _, p := range t.Added {
+				fmt.Println("  +", p.HumanReadableString())
+			}
+			for _, p := range t.Removed {
+				fmt.Println("  -", p.HumanReadableString())
+			}
+			for _, r := range t.Replaced {
+				fmt.Println("  ~", r.From.HumanReadableString(), "->", r.To.HumanReadableString())
+			}
+		},
+	}
+
+	return ans
+}
@@ -0,0 +1,60 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd_history
+
+import (
+	"path/filepath"
+	"strconv"
+
+	. "github.com/mudler/luet/pkg/config"
+	installer "github.com/mudler/luet/pkg/installer"
+	. "github.com/mudler/luet/pkg/logger"
+	pkg "github.com/mudler/luet/pkg/package"
+
+	"github.com/spf13/cobra"
+)
+
+func bindSystemFlags(cmd *cobra.Command) {
+	LuetCfg.Viper.BindPFlag("system.database_path", cmd.Flags().Lookup("system-dbpath"))
+	LuetCfg.Viper.BindPFlag("system.rootfs", cmd.Flags().Lookup("system-target"))
+}
+
+func systemDatabase() pkg.PackageDatabase {
+	if LuetCfg.GetSystem().DatabaseEngine == "boltdb" {
+		return pkg.NewBoltDatabase(
+			filepath.Join(LuetCfg.GetSystem().GetSystemRepoDatabaseDirPath(), "luet.db"))
+	}
+	return pkg.NewInMemoryDatabase(true)
+}
+
+// newInstaller returns an installer configured as in the luet configuration
+func newInstaller() installer.Installer {
+	return installer.NewLuetInstaller(installer.LuetInstallerOptions{
+		Concurrency:         LuetCfg.GetGeneral().Concurrency,
+		DownloadConcurrency: LuetCfg.GetDownload().Concurrency,
+		SolverOptions:       *LuetCfg.GetSolverOptions(),
+		HooksDir:            LuetCfg.GetSystem().HooksDir,
+		TriggersDir:         LuetCfg.GetSystem().TriggersDir,
+	})
+}
+
+func transactionID(arg string) int {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		Fatal("Invalid transaction id ", arg)
+	}
+	return id
+}
@@ -0,0 +1,62 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd_history
+
+import (
+	. "github.com/mudler/luet/pkg/config"
+	installer "github.com/mudler/luet/pkg/installer"
+	. "github.com/mudler/luet/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+func NewHistoryUndoCommand() *cobra.Command {
+	var ans = &cobra.Command{
+		Use:   "undo <id>",
+		Short: "Undo a transaction",
+		Long: `Apply the inverse of a transaction: the packages it added are removed, the ones it removed
+are installed again and the ones it replaced are swapped back.
+
+The old versions are taken from the repositories or, when they are not available anymore, from the package cache.`,
+		Args: cobra.ExactArgs(1),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			bindSystemFlags(cmd)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			id := transactionID(args[0])
+
+			repos := installer.Repositories{}
+			for _, repo := range LuetCfg.SystemRepositories {
+				if !repo.Enable {
+					continue
+				}
+				r := installer.NewSystemRepository(repo)
+				repos = append(repos, r)
+			}
+
+			inst := newInstaller()
+			inst.Repositories(repos)
+
+			system := &installer.System{Database: systemDatabase(), Target: LuetCfg.GetSystem().Rootfs}
+			if err := inst.Undo(id, system); err != nil {
+				Fatal("Error: " + err.Error())
+			}
+			Info("Transaction", id, "undone")
+		},
+	}
+
+	return ans
+}
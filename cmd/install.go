@@ -16,6 +16,7 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -25,12 +26,11 @@ import (
 	. "github.com/mudler/luet/pkg/logger"
 	pkg "github.com/mudler/luet/pkg/package"
 
-	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
 	"github.com/spf13/cobra"
 )
 
 var installCmd = &cobra.Command{
-	Use:   "install <pkg1> <pkg2> ...",
+	Use:   "install <pkg1|artifact1> <pkg2|artifact2> ...",
 	Short: "Install a package",
 	PreRun: func(cmd *cobra.Command, args []string) {
 		LuetCfg.Viper.BindPFlag("system.database_path", cmd.Flags().Lookup("system-dbpath"))
@@ -40,32 +40,42 @@ var installCmd = &cobra.Command{
 		LuetCfg.Viper.BindPFlag("solver.rate", cmd.Flags().Lookup("solver-rate"))
 		LuetCfg.Viper.BindPFlag("solver.max_attempts", cmd.Flags().Lookup("solver-attempts"))
 	},
-	Long: `Install packages in parallel`,
+	Long: `Install packages in parallel.
+
+Artifact files can be installed directly: their packages are read from the metadata files next
+to them, and their requirements are solved from the repositories.
+With --repo-dir, a local repository or the output of a build can be used for a single command.
+With --allow-downgrade, the installed versions of the requested packages are replaced, e.g. to go
+back to an older version still available in the repositories.
+With --lockfile, exactly the packages of a lockfile written by luet lock are installed, without
+solving: the installation fails if any of their artifacts doesn't match the locked checksums.`,
+	Example: `
+$> luet install foo/bar
+$> luet install ./bar-foo-1.0.package.tar.gz
+$> luet install --repo-dir ./build foo/bar
+$> luet install --allow-downgrade =foo/bar-1.2
+$> luet install --lockfile luet.lock
+`,
 	Run: func(cmd *cobra.Command, args []string) {
 		var toInstall []pkg.Package
 		var systemDB pkg.PackageDatabase
+		var artifacts []string
 
-		for _, a := range args {
-			gp, err := _gentoo.ParsePackageStr(a)
-			if err != nil {
-				Fatal("Invalid package string ", a, ": ", err.Error())
-			}
+		repoDirs, _ := cmd.Flags().GetStringSlice("repo-dir")
+		allowDowngrade, _ := cmd.Flags().GetBool("allow-downgrade")
+		lockfile, _ := cmd.Flags().GetString("lockfile")
 
-			if gp.Version == "" {
-				gp.Version = "0"
-				gp.Condition = _gentoo.PkgCondGreaterEqual
-			}
+		if lockfile != "" && len(args) > 0 {
+			Fatal("Packages can't be given along with a lockfile")
+		}
 
-			pack := &pkg.DefaultPackage{
-				Name: gp.Name,
-				Version: fmt.Sprintf("%s%s%s",
-					pkg.PkgSelectorConditionFromInt(gp.Condition.Int()).String(),
-					gp.Version,
-					gp.VersionSuffix,
-				),
-				Category: gp.Category,
-				Uri:      make([]string, 0),
+		for _, a := range args {
+			if installer.IsArtifactFile(a) {
+				artifacts = append(artifacts, a)
+				continue
 			}
+
+			pack := packageFromArg(a)
 			toInstall = append(toInstall, pack)
 		}
 
@@ -79,6 +89,31 @@ var installCmd = &cobra.Command{
 			repos = append(repos, r)
 		}
 
+		// Local artifacts and directories are served by temporary repositories
+		tmpdir, err := ioutil.TempDir(os.TempDir(), "luet-local")
+		if err != nil {
+			Fatal("Error: " + err.Error())
+		}
+		defer os.RemoveAll(tmpdir)
+
+		for i, dir := range repoDirs {
+			r, err := installer.DirRepository(fmt.Sprintf("repo-dir-%d", i), dir, filepath.Join(tmpdir, fmt.Sprintf("repo-dir-%d", i)))
+			if err != nil {
+				os.RemoveAll(tmpdir)
+				Fatal("Error: " + err.Error())
+			}
+			repos = append(repos, r)
+		}
+		if len(artifacts) > 0 {
+			r, packages, err := installer.ArtifactsRepository("local-artifacts", artifacts, filepath.Join(tmpdir, "artifacts"))
+			if err != nil {
+				os.RemoveAll(tmpdir)
+				Fatal("Error: " + err.Error())
+			}
+			repos = append(repos, r)
+			toInstall = append(toInstall, packages...)
+		}
+
 		stype := LuetCfg.Viper.GetString("solver.type")
 		discount := LuetCfg.Viper.GetFloat64("solver.discount")
 		rate := LuetCfg.Viper.GetFloat64("solver.rate")
@@ -91,18 +126,25 @@ var installCmd = &cobra.Command{
 
 		Debug("Solver", LuetCfg.GetSolverOptions().CompactString())
 
-		inst := installer.NewLuetInstaller(installer.LuetInstallerOptions{Concurrency: LuetCfg.GetGeneral().Concurrency, SolverOptions: *LuetCfg.GetSolverOptions()})
+		opts := installerOptions()
+		opts.AllowDowngrade = allowDowngrade
+		inst := installer.NewLuetInstaller(opts)
 		inst.Repositories(repos)
 
-		if LuetCfg.GetSystem().DatabaseEngine == "boltdb" {
-			systemDB = pkg.NewBoltDatabase(
-				filepath.Join(LuetCfg.GetSystem().GetSystemRepoDatabaseDirPath(), "luet.db"))
+		systemDB = systemDatabase()
+		system := &installer.System{Database: systemDB, Target: LuetCfg.GetSystem().Rootfs}
+		if lockfile != "" {
+			lock, lerr := installer.LoadLockfile(lockfile)
+			if lerr != nil {
+				os.RemoveAll(tmpdir)
+				Fatal("Error: " + lerr.Error())
+			}
+			err = inst.InstallLocked(lock, system)
 		} else {
-			systemDB = pkg.NewInMemoryDatabase(true)
+			err = inst.Install(toInstall, system)
 		}
-		system := &installer.System{Database: systemDB, Target: LuetCfg.GetSystem().Rootfs}
-		err := inst.Install(toInstall, system)
 		if err != nil {
+			os.RemoveAll(tmpdir)
 			Fatal("Error: " + err.Error())
 		}
 	},
@@ -119,6 +161,9 @@ func init() {
 	installCmd.Flags().Float32("solver-rate", 0.7, "Solver learning rate")
 	installCmd.Flags().Float32("solver-discount", 1.0, "Solver discount rate")
 	installCmd.Flags().Int("solver-attempts", 9000, "Solver maximum attempts")
+	installCmd.Flags().StringSlice("repo-dir", []string{}, "Use the repository or the build output in the given directory for this command")
+	installCmd.Flags().Bool("allow-downgrade", false, "Replace the installed versions of the requested packages, also with older ones")
+	installCmd.Flags().String("lockfile", "", "Install exactly the packages of the given lockfile")
 
 	RootCmd.AddCommand(installCmd)
 }
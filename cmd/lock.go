@@ -0,0 +1,98 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"os"
+
+	. "github.com/mudler/luet/pkg/config"
+	installer "github.com/mudler/luet/pkg/installer"
+	. "github.com/mudler/luet/pkg/logger"
+	pkg "github.com/mudler/luet/pkg/package"
+
+	"github.com/spf13/cobra"
+)
+
+var lockCmd = &cobra.Command{
+	Use:   "lock [<pkg1> <pkg2> ...]",
+	Short: "Write a lockfile of the installed packages or of an install request",
+	Long: `Write a lockfile with the exact packages installed in the system, along with the repository,
+the slot, the repository revision and the artifact checksums of each of them.
+
+If packages are given, the lockfile pins the packages needed to install them in a new system instead.
+The lockfile can be installed later with luet install --lockfile, which fails if any artifact or slot changed.
+The repository revision is recorded for reference only, as only the checksums pin the artifacts.`,
+	Example: `
+$> luet lock --output luet.lock
+$> luet lock --output luet.lock system/base
+$> luet install --lockfile luet.lock
+`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		LuetCfg.Viper.BindPFlag("system.database_path", cmd.Flags().Lookup("system-dbpath"))
+		LuetCfg.Viper.BindPFlag("system.rootfs", cmd.Flags().Lookup("system-target"))
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var toLock []pkg.Package
+		var systemDB pkg.PackageDatabase
+
+		output, _ := cmd.Flags().GetString("output")
+
+		for _, a := range args {
+			pack := packageFromArg(a)
+			toLock = append(toLock, pack)
+		}
+
+		repos := installer.Repositories{}
+		for _, repo := range LuetCfg.SystemRepositories {
+			if !repo.Enable {
+				continue
+			}
+			r := installer.NewSystemRepository(repo)
+			repos = append(repos, r)
+		}
+
+		inst := newInstaller()
+		inst.Repositories(repos)
+
+		// Install requests are solved for a new system
+		if len(toLock) > 0 {
+			systemDB = pkg.NewInMemoryDatabase(false)
+		} else {
+			systemDB = systemDatabase()
+		}
+		system := &installer.System{Database: systemDB, Target: LuetCfg.GetSystem().Rootfs}
+
+		lock, err := inst.Lock(toLock, system)
+		if err != nil {
+			Fatal("Error: " + err.Error())
+		}
+		if err := lock.Write(output); err != nil {
+			Fatal("Error: " + err.Error())
+		}
+		Info("Locked", len(lock.Packages), "packages in", output)
+	},
+}
+
+func init() {
+	path, err := os.Getwd()
+	if err != nil {
+		Fatal(err)
+	}
+	lockCmd.Flags().String("system-dbpath", path, "System db path")
+	lockCmd.Flags().String("system-target", path, "System rootpath")
+	lockCmd.Flags().String("output", "luet.lock", "Path of the lockfile")
+	RootCmd.AddCommand(lockCmd)
+}
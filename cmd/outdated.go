@@ -0,0 +1,88 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"os"
+
+	. "github.com/mudler/luet/pkg/config"
+	installer "github.com/mudler/luet/pkg/installer"
+	. "github.com/mudler/luet/pkg/logger"
+	pkg "github.com/mudler/luet/pkg/package"
+
+	"github.com/spf13/cobra"
+)
+
+// outdatedExitCode is returned when updates are available, distinct from the failures of Fatal
+const outdatedExitCode = 100
+
+var outdatedCmd = &cobra.Command{
+	Use:   "outdated",
+	Short: "List the installed packages with newer versions available",
+	Long: `List the installed packages which have newer versions available in the repositories,
+along with the repository they come from.
+
+The command exits with code 100 when updates are available, so it can be used to check
+for stale systems. Any other non-zero code means that the check itself failed.`,
+	Example: `
+$> luet outdated; [ $? -eq 100 ] && echo "updates available"
+`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		LuetCfg.Viper.BindPFlag("system.database_path", cmd.Flags().Lookup("system-dbpath"))
+		LuetCfg.Viper.BindPFlag("system.rootfs", cmd.Flags().Lookup("system-target"))
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var systemDB pkg.PackageDatabase
+
+		repos := installer.Repositories{}
+		for _, repo := range LuetCfg.SystemRepositories {
+			if !repo.Enable {
+				continue
+			}
+			r := installer.NewSystemRepository(repo)
+			repos = append(repos, r)
+		}
+
+		inst := newInstaller()
+		inst.Repositories(repos)
+		synced, err := inst.SyncRepositories(false)
+		if err != nil {
+			Fatal("Error: " + err.Error())
+		}
+
+		systemDB = systemDatabase()
+
+		updates := synced.Outdated(systemDB.World())
+		for _, u := range updates {
+			Info(":package:", u.Installed.GetCategory(), u.Installed.GetName(), u.Installed.GetVersion(),
+				":arrow_right:", u.Package.GetCategory(), u.Package.GetName(), u.Package.GetVersion(),
+				"repository:", u.Repo.GetName())
+		}
+		if len(updates) > 0 {
+			os.Exit(outdatedExitCode)
+		}
+	},
+}
+
+func init() {
+	path, err := os.Getwd()
+	if err != nil {
+		Fatal(err)
+	}
+	outdatedCmd.Flags().String("system-dbpath", path, "System db path")
+	outdatedCmd.Flags().String("system-target", path, "System rootpath")
+	RootCmd.AddCommand(outdatedCmd)
+}
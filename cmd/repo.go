@@ -33,5 +33,13 @@ func init() {
 	repoGroupCmd.AddCommand(
 		NewRepoListCommand(),
 		NewRepoUpdateCommand(),
+		NewRepoAddCommand(),
+		NewRepoRemoveCommand(),
+		NewRepoEnableCommand(),
+		NewRepoDisableCommand(),
+		NewRepoSetPriorityCommand(),
+		NewRepoMirrorCommand(),
+		NewRepoPromoteCommand(),
+		NewRepoPruneCommand(),
 	)
 }
@@ -0,0 +1,83 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+package cmd_repo
+
+import (
+	"strings"
+
+	. "github.com/mudler/luet/pkg/config"
+	installer "github.com/mudler/luet/pkg/installer"
+	. "github.com/mudler/luet/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+func NewRepoAddCommand() *cobra.Command {
+	var ans = &cobra.Command{
+		Use:   "add <name> <url> [OPTIONS]",
+		Short: "Add a repository.",
+		Long: `Add a repository to the first repositories configuration directory (repos_confdir).
+The type of the repository is detected from the url if not given, and the repository.yaml
+of the repository is fetched to validate it.`,
+		Example: `
+$> luet repo add myrepo https://example.com/repo
+$> luet repo add --type docker --priority 10 myrepo quay.io/org/repo
+`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			name, url := args[0], args[1]
+			t, _ := cmd.Flags().GetString("type")
+			description, _ := cmd.Flags().GetString("description")
+			priority, _ := cmd.Flags().GetInt("priority")
+			cached, _ := cmd.Flags().GetBool("cached")
+			disabled, _ := cmd.Flags().GetBool("disabled")
+			noCheck, _ := cmd.Flags().GetBool("no-check")
+
+			if _, err := LuetCfg.GetSystemRepository(name); err == nil {
+				Fatal("Repository " + name + " already exists")
+			}
+
+			if t == "" {
+				t = installer.DetectRepositoryType(url)
+			}
+			if t == "disk" {
+				url = strings.TrimPrefix(url, "file://")
+			}
+
+			r := NewLuetRepository(name, t, description, []string{url}, priority, !disabled, cached)
+			if !noCheck {
+				spec, err := installer.NewSystemRepository(*r).(*installer.LuetSystemRepository).Probe()
+				if err != nil {
+					Fatal("Invalid repository " + url + ": " + err.Error())
+				}
+				if r.Description == "" {
+					r.Description = spec.GetDescription()
+				}
+			}
+
+			saveRepository(r)
+			Info("Repository", name, "added")
+		},
+	}
+
+	ans.Flags().StringP("type", "t", "", "Repository type (disk, http, docker). Detected from the url if not given.")
+	ans.Flags().String("description", "", "Repository description.")
+	ans.Flags().Int("priority", 1, "Repository priority.")
+	ans.Flags().Bool("cached", true, "Cache the repository tree locally.")
+	ans.Flags().Bool("disabled", false, "Add the repository disabled.")
+	ans.Flags().Bool("no-check", false, "Don't fetch the repository.yaml to validate the repository.")
+
+	return ans
+}
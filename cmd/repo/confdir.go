@@ -0,0 +1,39 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+package cmd_repo
+
+import (
+	. "github.com/mudler/luet/pkg/config"
+	. "github.com/mudler/luet/pkg/logger"
+	repository "github.com/mudler/luet/pkg/repository"
+)
+
+// configuredRepository returns the repository name as defined in the repositories configuration directories
+func configuredRepository(name string) *LuetRepository {
+	file, r, err := repository.FindRepositoryFile(LuetCfg, name)
+	if err != nil {
+		Fatal(err.Error())
+	}
+	if file == "" {
+		Fatal("Repository " + name + " is not defined in the repositories configuration directories")
+	}
+	return r
+}
+
+func saveRepository(r *LuetRepository) {
+	if err := repository.SaveRepository(LuetCfg, r); err != nil {
+		Fatal("Error on saving repository " + r.Name + ": " + err.Error())
+	}
+}
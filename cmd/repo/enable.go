@@ -0,0 +1,45 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+package cmd_repo
+
+import (
+	. "github.com/mudler/luet/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+func newRepoToggleCommand(use, short string, enable bool) *cobra.Command {
+	return &cobra.Command{
+		Use:   use + " <name> [name2] ...",
+		Short: short,
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, name := range args {
+				r := configuredRepository(name)
+				r.Enable = enable
+				saveRepository(r)
+				Info("Repository", name, use+"d")
+			}
+		},
+	}
+}
+
+func NewRepoEnableCommand() *cobra.Command {
+	return newRepoToggleCommand("enable", "Enable repositories.", true)
+}
+
+func NewRepoDisableCommand() *cobra.Command {
+	return newRepoToggleCommand("disable", "Disable repositories.", false)
+}
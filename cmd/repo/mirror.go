@@ -0,0 +1,61 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd_repo
+
+import (
+	. "github.com/mudler/luet/pkg/config"
+	installer "github.com/mudler/luet/pkg/installer"
+	. "github.com/mudler/luet/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+func NewRepoMirrorCommand() *cobra.Command {
+	var ans = &cobra.Command{
+		Use:   "mirror <repo> <dir> [package1] [package2] [OPTIONS]",
+		Short: "Create an offline copy of a repository.",
+		Long: `Download the tree and the artifacts of a repository in a directory,
+which can be used as a disk repository or served with serve-repo.
+Artifacts already in the directory are downloaded again only if they changed.`,
+		Example: `
+# Mirror the whole repo1 repository:
+$> luet repo mirror repo1 /srv/luet/repo1
+
+# Mirror only the packages needed to install foo/bar
+$> luet repo mirror repo1 /srv/luet/repo1 foo/bar
+`,
+		Args: cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			repo, err := LuetCfg.GetSystemRepository(args[0])
+			if err != nil {
+				Fatal(err.Error())
+			}
+
+			packages, err := parsePackages(args[2:])
+			if err != nil {
+				Fatal(err.Error())
+			}
+
+			mirror, err := installer.Mirror(installer.NewSystemRepository(*repo), args[1], packages)
+			if err != nil {
+				Fatal("Error on mirroring repository " + args[0] + ": " + err.Error())
+			}
+			Info("Mirrored", len(mirror.GetIndex()), "packages of", args[0], "in", args[1])
+		},
+	}
+
+	return ans
+}
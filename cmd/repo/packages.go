@@ -0,0 +1,56 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd_repo
+
+import (
+	"fmt"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+	pkg "github.com/mudler/luet/pkg/package"
+)
+
+// parsePackages converts the package strings given as arguments to package selectors
+func parsePackages(args []string) ([]pkg.Package, error) {
+	var packages []pkg.Package
+	for _, a := range args {
+		gp, err := _gentoo.ParsePackageStr(a)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid package string %s: %s", a, err.Error())
+		}
+
+		if gp.Version == "" {
+			gp.Version = "0"
+			gp.Condition = _gentoo.PkgCondGreaterEqual
+		}
+
+		pack := &pkg.DefaultPackage{
+			Name: gp.Name,
+			Version: fmt.Sprintf("%s%s%s",
+				pkg.PkgSelectorConditionFromInt(gp.Condition.Int()).String(),
+				gp.Version,
+				gp.VersionSuffix,
+			),
+			Category: gp.Category,
+			Uri:      make([]string, 0),
+		}
+		// "0" is the default slot of the parser, which maps to packages without a slot
+		if gp.Slot != "0" {
+			pack.SetSlot(gp.Slot)
+		}
+		packages = append(packages, pack)
+	}
+	return packages, nil
+}
@@ -0,0 +1,44 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+package cmd_repo
+
+import (
+	"fmt"
+	"strconv"
+
+	. "github.com/mudler/luet/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+func NewRepoSetPriorityCommand() *cobra.Command {
+	var ans = &cobra.Command{
+		Use:   "set-priority <name> <priority>",
+		Short: "Set the priority of a repository.",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			priority, err := strconv.Atoi(args[1])
+			if err != nil {
+				Fatal("Invalid priority " + args[1])
+			}
+			r := configuredRepository(args[0])
+			r.Priority = priority
+			saveRepository(r)
+			Info(fmt.Sprintf("Repository %s priority set to %d", args[0], priority))
+		},
+	}
+
+	return ans
+}
@@ -0,0 +1,73 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd_repo
+
+import (
+	"fmt"
+
+	. "github.com/mudler/luet/pkg/config"
+	installer "github.com/mudler/luet/pkg/installer"
+	. "github.com/mudler/luet/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+func NewRepoPromoteCommand() *cobra.Command {
+	var ans = &cobra.Command{
+		Use:   "promote --from <repo> --to <repo> <package1> [package2] [OPTIONS]",
+		Short: "Copy packages between repositories.",
+		Long: `Copy the artifacts and the definitions of the given packages from a repository
+to another one, publishing a new revision of the target repository.
+The target repository must be of disk or docker type.`,
+		Example: `
+# Promote foo/bar from staging to stable:
+$> luet repo promote --from staging --to stable foo/bar
+`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			fromName, _ := cmd.Flags().GetString("from")
+			toName, _ := cmd.Flags().GetString("to")
+			if fromName == "" || toName == "" {
+				Fatal("Both --from and --to are required")
+			}
+
+			from, err := LuetCfg.GetSystemRepository(fromName)
+			if err != nil {
+				Fatal(err.Error())
+			}
+			to, err := LuetCfg.GetSystemRepository(toName)
+			if err != nil {
+				Fatal(err.Error())
+			}
+
+			packages, err := parsePackages(args)
+			if err != nil {
+				Fatal(err.Error())
+			}
+
+			repo, err := installer.Promote(installer.NewSystemRepository(*from), installer.NewSystemRepository(*to), packages)
+			if err != nil {
+				Fatal("Error on promoting packages: " + err.Error())
+			}
+			Info(fmt.Sprintf("Repository %s is now at revision %d", toName, repo.GetRevision()))
+		},
+	}
+
+	ans.Flags().String("from", "", "Repository to take the packages from.")
+	ans.Flags().String("to", "", "Repository to copy the packages to.")
+
+	return ans
+}
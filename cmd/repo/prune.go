@@ -0,0 +1,88 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd_repo
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/mudler/luet/pkg/config"
+	installer "github.com/mudler/luet/pkg/installer"
+	. "github.com/mudler/luet/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+func NewRepoPruneCommand() *cobra.Command {
+	var ans = &cobra.Command{
+		Use:   "prune <repo> [OPTIONS]",
+		Short: "Remove old artifacts from a repository.",
+		Long: `Remove the artifacts which are not retained by the given policies from a disk
+repository, along with their metadata and definitions, and publish a new revision of it.
+An artifact is retained if it matches any of the policies.`,
+		Example: `
+# Keep only the last two versions of each package and their dependencies:
+$> luet repo prune --keep-versions 2 --keep-dependencies myrepo
+
+# Show what would be removed, keeping artifacts built after a date:
+$> luet repo prune --keep-newer-than 2020-06-01 --dry-run myrepo
+`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			keepVersions, _ := cmd.Flags().GetInt("keep-versions")
+			keepNewerThan, _ := cmd.Flags().GetString("keep-newer-than")
+			keepDeps, _ := cmd.Flags().GetBool("keep-dependencies")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+			policy := installer.PrunePolicy{KeepVersions: keepVersions, KeepDependencies: keepDeps}
+			if keepNewerThan != "" {
+				t, err := time.Parse("2006-01-02", keepNewerThan)
+				if err != nil {
+					t, err = time.Parse(time.RFC3339, keepNewerThan)
+				}
+				if err != nil {
+					Fatal("Invalid date " + keepNewerThan + ", use YYYY-MM-DD or RFC3339")
+				}
+				policy.KeepNewerThan = t
+			}
+
+			r, err := LuetCfg.GetSystemRepository(args[0])
+			if err != nil {
+				Fatal(err.Error())
+			}
+
+			pruned, err := installer.Prune(installer.NewSystemRepository(*r), policy, dryRun)
+			if err != nil {
+				Fatal("Error on pruning repository: " + err.Error())
+			}
+
+			if dryRun {
+				for _, a := range pruned {
+					fmt.Println("Would remove", a.GetCompileSpec().GetPackage().HumanReadableString())
+				}
+				return
+			}
+			Info(fmt.Sprintf("Removed %d artifacts from %s", len(pruned), args[0]))
+		},
+	}
+
+	ans.Flags().Int("keep-versions", 0, "Keep the last N versions of each package.")
+	ans.Flags().String("keep-newer-than", "", "Keep the artifacts built after the given date (YYYY-MM-DD or RFC3339).")
+	ans.Flags().Bool("keep-dependencies", false, "Keep also the dependencies of the retained packages.")
+	ans.Flags().Bool("dry-run", false, "Only list the artifacts which would be removed.")
+
+	return ans
+}
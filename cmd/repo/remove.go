@@ -0,0 +1,40 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+package cmd_repo
+
+import (
+	. "github.com/mudler/luet/pkg/config"
+	. "github.com/mudler/luet/pkg/logger"
+	repository "github.com/mudler/luet/pkg/repository"
+
+	"github.com/spf13/cobra"
+)
+
+func NewRepoRemoveCommand() *cobra.Command {
+	var ans = &cobra.Command{
+		Use:   "remove <name>",
+		Short: "Remove a repository.",
+		Long:  `Remove the file defining the repository from the repositories configuration directories.`,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := repository.RemoveRepository(LuetCfg, args[0]); err != nil {
+				Fatal(err.Error())
+			}
+			Info("Repository", args[0], "removed")
+		},
+	}
+
+	return ans
+}
@@ -16,7 +16,6 @@ package cmd
 
 import (
 	"os"
-	"path/filepath"
 	"regexp"
 
 	. "github.com/mudler/luet/pkg/config"
@@ -30,7 +29,15 @@ import (
 var searchCmd = &cobra.Command{
 	Use:   "search <term>",
 	Short: "Search packages",
-	Long:  `Search for installed and available packages`,
+	Long: `Search for installed and available packages.
+
+With --file, the packages shipping files matching the term are searched: an absolute path
+is matched exactly, anything else is used as a regex.`,
+	Example: `
+$> luet search foo
+$> luet search --file /usr/bin/foo
+$> luet search --installed --file 'bin/.*foo'
+`,
 	PreRun: func(cmd *cobra.Command, args []string) {
 		LuetCfg.Viper.BindPFlag("system.database_path", cmd.Flags().Lookup("system-dbpath"))
 		LuetCfg.Viper.BindPFlag("system.rootfs", cmd.Flags().Lookup("system-target"))
@@ -47,6 +54,7 @@ var searchCmd = &cobra.Command{
 			Fatal("Wrong number of arguments (expected 1)")
 		}
 		installed := LuetCfg.Viper.GetBool("installed")
+		searchFiles, _ := cmd.Flags().GetBool("file")
 		stype := LuetCfg.Viper.GetString("solver.type")
 		discount := LuetCfg.Viper.GetFloat64("solver.discount")
 		rate := LuetCfg.Viper.GetFloat64("solver.rate")
@@ -70,7 +78,7 @@ var searchCmd = &cobra.Command{
 				repos = append(repos, r)
 			}
 
-			inst := installer.NewLuetInstaller(installer.LuetInstallerOptions{Concurrency: LuetCfg.GetGeneral().Concurrency, SolverOptions: *LuetCfg.GetSolverOptions()})
+			inst := newInstaller()
 
 			inst.Repositories(repos)
 			synced, err := inst.SyncRepositories(false)
@@ -80,20 +88,48 @@ var searchCmd = &cobra.Command{
 
 			Info("--- Search results: ---")
 
+			if searchFiles {
+				matches, err := synced.SearchFiles(args[0])
+				if err != nil {
+					Fatal("Error: " + err.Error())
+				}
+				for _, m := range matches {
+					Info(":package:", m.Package.GetCategory(), m.Package.GetName(),
+						m.Package.GetVersion(), "repository:", m.Repo.GetName())
+					for _, f := range m.Files {
+						Info("  " + f)
+					}
+				}
+				return
+			}
+
 			matches := synced.Search(args[0])
 			for _, m := range matches {
 				Info(":package:", m.Package.GetCategory(), m.Package.GetName(),
 					m.Package.GetVersion(), "repository:", m.Repo.GetName())
+				for _, r := range synced.ReplacedBy(m.Package) {
+					Info("  :arrow_right: replaced by", r.Package.GetCategory(), r.Package.GetName(),
+						r.Package.GetVersion(), "repository:", r.Repo.GetName())
+				}
 			}
 		} else {
 
-			if LuetCfg.GetSystem().DatabaseEngine == "boltdb" {
-				systemDB = pkg.NewBoltDatabase(
-					filepath.Join(LuetCfg.GetSystem().GetSystemRepoDatabaseDirPath(), "luet.db"))
-			} else {
-				systemDB = pkg.NewInMemoryDatabase(true)
-			}
+			systemDB = systemDatabase()
 			system := &installer.System{Database: systemDB, Target: LuetCfg.GetSystem().Rootfs}
+			if searchFiles {
+				matches, err := system.SearchFiles(args[0])
+				if err != nil {
+					Fatal("Error: " + err.Error())
+				}
+				for _, m := range matches {
+					Info(":package:", m.Package.GetCategory(), m.Package.GetName(), m.Package.GetVersion())
+					for _, f := range m.Files {
+						Info("  " + f)
+					}
+				}
+				return
+			}
+
 			var term = regexp.MustCompile(args[0])
 
 			for _, k := range system.Database.GetPackages() {
@@ -115,6 +151,7 @@ func init() {
 	searchCmd.Flags().String("system-dbpath", path, "System db path")
 	searchCmd.Flags().String("system-target", path, "System rootpath")
 	searchCmd.Flags().Bool("installed", false, "Search between system packages")
+	searchCmd.Flags().Bool("file", false, "Search the packages shipping files matching the term")
 	searchCmd.Flags().String("solver-type", "", "Solver strategy ( Defaults none, available: "+AvailableResolvers+" )")
 	searchCmd.Flags().Float32("solver-rate", 0.7, "Solver learning rate")
 	searchCmd.Flags().Float32("solver-discount", 1.0, "Solver discount rate")
@@ -18,6 +18,8 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/mudler/luet/pkg/compiler"
+	installer "github.com/mudler/luet/pkg/installer"
 	. "github.com/mudler/luet/pkg/logger"
 
 	"github.com/spf13/cobra"
@@ -27,20 +29,72 @@ import (
 var serverepoCmd = &cobra.Command{
 	Use:   "serve-repo",
 	Short: "Embedded micro-http server",
-	Long:  `Embedded mini http server for serving local repositories`,
+	Long: `Embedded mini http server for serving local repositories.
+
+When a token or a username is configured, artifacts can be uploaded with PUT requests:
+the package tarball first, then its finalizer (<fingerprint>.finalize.yaml) if it has one,
+and its metadata file. Each uploaded metadata file is verified against its tarball and a
+new revision of the repository is published. A package published again keeps its previous
+finalizer, unless a new one is uploaded.
+
+Credentials can also be given with the LUET_TOKEN, LUET_USERNAME and LUET_PASSWORD
+environment variables.`,
+	Example: `
+# Serve the repository in /srv/repo over TLS, accepting uploads with a token:
+$> LUET_TOKEN=xxx luet serve-repo --dir /srv/repo --tls-cert cert.pem --tls-key key.pem
+
+# Upload an artifact from CI:
+$> curl -H "Authorization: Bearer xxx" -T foo-bar-1.0.package.tar.gz https://repo.example.com/
+$> curl -H "Authorization: Bearer xxx" -T foo-bar-1.0.finalize.yaml https://repo.example.com/
+$> curl -H "Authorization: Bearer xxx" -T foo-bar-1.0.metadata.yaml https://repo.example.com/
+`,
 	PreRun: func(cmd *cobra.Command, args []string) {
 		viper.BindPFlag("dir", cmd.Flags().Lookup("dir"))
 		viper.BindPFlag("address", cmd.Flags().Lookup("address"))
 		viper.BindPFlag("port", cmd.Flags().Lookup("port"))
+		viper.BindPFlag("token", cmd.Flags().Lookup("token"))
+		viper.BindPFlag("username", cmd.Flags().Lookup("username"))
+		viper.BindPFlag("password", cmd.Flags().Lookup("password"))
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 
 		dir := viper.GetString("dir")
 		port := viper.GetString("port")
 		address := viper.GetString("address")
+		authReads, _ := cmd.Flags().GetBool("auth-downloads")
+		tlsCert, _ := cmd.Flags().GetString("tls-cert")
+		tlsKey, _ := cmd.Flags().GetString("tls-key")
+		name, _ := cmd.Flags().GetString("name")
+		descr, _ := cmd.Flags().GetString("descr")
+		urls, _ := cmd.Flags().GetStringSlice("urls")
+		treetype, _ := cmd.Flags().GetString("tree-compression")
+		maxUpload, _ := cmd.Flags().GetInt64("max-upload-size")
+
+		opts := installer.RepositoryServerOptions{
+			Dir:                dir,
+			Token:              viper.GetString("token"),
+			Username:           viper.GetString("username"),
+			Password:           viper.GetString("password"),
+			AuthenticatedReads: authReads,
+			MaxUploadSize:      maxUpload << 20,
+			Name:               name,
+			Description:        descr,
+			Urls:               urls,
+			TreeCompression:    compiler.CompressionImplementation(treetype),
+		}
+		if authReads && opts.Token == "" && opts.Username == "" {
+			Fatal("--auth-downloads requires a token or a username")
+		}
+		if (tlsCert == "") != (tlsKey == "") {
+			Fatal("Both --tls-cert and --tls-key are required for TLS")
+		}
 
-		http.Handle("/", http.FileServer(http.Dir(dir)))
+		http.Handle("/", installer.NewRepositoryServer(opts))
 
+		if tlsCert != "" {
+			Info("Serving ", dir, " on HTTPS port: ", port)
+			Fatal(http.ListenAndServeTLS(address+":"+port, tlsCert, tlsKey, nil))
+		}
 		Info("Serving ", dir, " on HTTP port: ", port)
 		Fatal(http.ListenAndServe(address+":"+port, nil))
 	},
@@ -54,6 +108,17 @@ func init() {
 	serverepoCmd.Flags().String("dir", path, "Packages folder (output from build)")
 	serverepoCmd.Flags().String("port", "9090", "Listening port")
 	serverepoCmd.Flags().String("address", "0.0.0.0", "Listening address")
+	serverepoCmd.Flags().String("token", "", "Token accepted for uploads (token or Bearer authorization)")
+	serverepoCmd.Flags().String("username", "", "Username accepted for uploads (basic authentication)")
+	serverepoCmd.Flags().String("password", "", "Password accepted for uploads (basic authentication)")
+	serverepoCmd.Flags().Bool("auth-downloads", false, "Require the credentials also for downloads")
+	serverepoCmd.Flags().Int64("max-upload-size", installer.DefaultMaxUploadSize>>20, "Maximum size of an uploaded artifact, in MiB")
+	serverepoCmd.Flags().String("tls-cert", "", "TLS certificate file")
+	serverepoCmd.Flags().String("tls-key", "", "TLS key file")
+	serverepoCmd.Flags().String("name", "luet", "Repository name, used when the repository is created by the first upload")
+	serverepoCmd.Flags().String("descr", "luet", "Repository description, used when the repository is created by the first upload")
+	serverepoCmd.Flags().StringSlice("urls", []string{}, "Repository URLs, used when the repository is created by the first upload")
+	serverepoCmd.Flags().String("tree-compression", "none", "Tree compression alg, used when the repository is created by the first upload: none, gzip")
 
 	RootCmd.AddCommand(serverepoCmd)
 }
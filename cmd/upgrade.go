@@ -16,18 +16,18 @@ package cmd
 
 import (
 	"os"
-	"path/filepath"
 
 	. "github.com/mudler/luet/pkg/config"
 	installer "github.com/mudler/luet/pkg/installer"
 	. "github.com/mudler/luet/pkg/logger"
 	pkg "github.com/mudler/luet/pkg/package"
 
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
 	"github.com/spf13/cobra"
 )
 
 var upgradeCmd = &cobra.Command{
-	Use:   "upgrade",
+	Use:   "upgrade [<pkg1> <pkg2> ...]",
 	Short: "Upgrades the system",
 	PreRun: func(cmd *cobra.Command, args []string) {
 		LuetCfg.Viper.BindPFlag("system.database_path", installCmd.Flags().Lookup("system-dbpath"))
@@ -37,9 +37,24 @@ var upgradeCmd = &cobra.Command{
 		LuetCfg.Viper.BindPFlag("solver.rate", cmd.Flags().Lookup("solver-rate"))
 		LuetCfg.Viper.BindPFlag("solver.max_attempts", cmd.Flags().Lookup("solver-attempts"))
 	},
-	Long: `Upgrades packages in parallel`,
+	Long: `Upgrades packages in parallel.
+
+When packages are given, only those are upgraded, along with the packages required by their new versions.`,
+	Example: `
+$> luet upgrade
+$> luet upgrade foo/bar foo/baz
+`,
 	Run: func(cmd *cobra.Command, args []string) {
 		var systemDB pkg.PackageDatabase
+		var toUpgrade []pkg.Package
+
+		for _, a := range args {
+			gp, err := _gentoo.ParsePackageStr(a)
+			if err != nil {
+				Fatal("Invalid package string ", a, ": ", err.Error())
+			}
+			toUpgrade = append(toUpgrade, &pkg.DefaultPackage{Name: gp.Name, Category: gp.Category})
+		}
 
 		repos := installer.Repositories{}
 		for _, repo := range LuetCfg.SystemRepositories {
@@ -63,21 +78,20 @@ var upgradeCmd = &cobra.Command{
 
 		Debug("Solver", LuetCfg.GetSolverOptions().String())
 
-		inst := installer.NewLuetInstaller(installer.LuetInstallerOptions{Concurrency: LuetCfg.GetGeneral().Concurrency, SolverOptions: *LuetCfg.GetSolverOptions()})
+		inst := newInstaller()
 		inst.Repositories(repos)
 		_, err := inst.SyncRepositories(false)
 		if err != nil {
 			Fatal("Error: " + err.Error())
 		}
 
-		if LuetCfg.GetSystem().DatabaseEngine == "boltdb" {
-			systemDB = pkg.NewBoltDatabase(
-				filepath.Join(LuetCfg.GetSystem().GetSystemRepoDatabaseDirPath(), "luet.db"))
+		systemDB = systemDatabase()
+		system := &installer.System{Database: systemDB, Target: LuetCfg.GetSystem().Rootfs}
+		if len(toUpgrade) > 0 {
+			err = inst.UpgradePackages(toUpgrade, system)
 		} else {
-			systemDB = pkg.NewInMemoryDatabase(true)
+			err = inst.Upgrade(system)
 		}
-		system := &installer.System{Database: systemDB, Target: LuetCfg.GetSystem().Rootfs}
-		err = inst.Upgrade(system)
 		if err != nil {
 			Fatal("Error: " + err.Error())
 		}
@@ -0,0 +1,118 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	. "github.com/mudler/luet/pkg/config"
+	installer "github.com/mudler/luet/pkg/installer"
+	. "github.com/mudler/luet/pkg/logger"
+	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/mudler/luet/pkg/solver"
+
+	"github.com/spf13/cobra"
+)
+
+var whyNotCmd = &cobra.Command{
+	Use:   "why-not <pkg>",
+	Short: "Show why a package can't be installed",
+	Long:  `Show the installed packages and the conflicts which prevent a package to be installed`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		LuetCfg.Viper.BindPFlag("system.database_path", cmd.Flags().Lookup("system-dbpath"))
+		LuetCfg.Viper.BindPFlag("system.rootfs", cmd.Flags().Lookup("system-target"))
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			Fatal("Wrong number of arguments (expected 1)")
+		}
+
+		pack := packageFromArg(args[0])
+
+		repos := installer.Repositories{}
+		for _, repo := range LuetCfg.SystemRepositories {
+			if !repo.Enable {
+				continue
+			}
+			r := installer.NewSystemRepository(repo)
+			repos = append(repos, r)
+		}
+
+		inst := newInstaller()
+		inst.Repositories(repos)
+		synced, err := inst.SyncRepositories(false)
+		if err != nil {
+			Fatal("Error: " + err.Error())
+		}
+
+		systemDB := systemDatabase()
+
+		allRepos := pkg.NewInMemoryDatabase(false)
+		synced.SyncDatabase(allRepos)
+
+		candidate, err := allRepos.FindPackageCandidate(pack)
+		if err != nil {
+			Fatal("Error: " + err.Error())
+		}
+		if _, err := allRepos.FindPackage(candidate); err != nil {
+			Fatal("Package " + args[0] + " not found in the repositories")
+		}
+		if _, err := systemDB.FindPackage(candidate); err == nil {
+			Info(":package:", candidate.HumanReadableString(), "is already installed")
+			return
+		}
+
+		installed := systemDB.World()
+		for _, i := range installed {
+			for _, c := range candidate.GetConflicts() {
+				if c.RequirementMatches(i) {
+					Info(":no_entry:", candidate.HumanReadableString(), "conflicts with the installed", i.HumanReadableString())
+				}
+			}
+			for _, c := range i.GetConflicts() {
+				if c.RequirementMatches(candidate) {
+					Info(":no_entry:", "The installed", i.HumanReadableString(), "conflicts with", candidate.HumanReadableString())
+				}
+			}
+		}
+
+		s := solver.NewSolver(systemDB, allRepos, pkg.NewInMemoryDatabase(false))
+		blockers, err := s.ConflictingPackages(candidate, installed)
+		if err != nil {
+			Fatal("Error: " + err.Error())
+		}
+		if len(blockers) == 0 {
+			Info(":package:", candidate.HumanReadableString(), "can be installed")
+			return
+		}
+
+		Info(":package:", candidate.HumanReadableString(), "can't be installed alongside:")
+		for _, b := range blockers {
+			fmt.Println("  " + b.HumanReadableString())
+		}
+	},
+}
+
+func init() {
+	path, err := os.Getwd()
+	if err != nil {
+		Fatal(err)
+	}
+	whyNotCmd.Flags().String("system-dbpath", path, "System db path")
+	whyNotCmd.Flags().String("system-target", path, "System rootpath")
+	RootCmd.AddCommand(whyNotCmd)
+}
@@ -0,0 +1,86 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	. "github.com/mudler/luet/pkg/config"
+	. "github.com/mudler/luet/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+// maxChains is the maximum number of requirement chains shown by luet why
+const maxChains = 100
+
+var whyCmd = &cobra.Command{
+	Use:   "why <pkg>",
+	Short: "Show why a package is installed",
+	Long:  `Show the requirement chains which lead from the installed packages to the given one`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		LuetCfg.Viper.BindPFlag("system.database_path", cmd.Flags().Lookup("system-dbpath"))
+		LuetCfg.Viper.BindPFlag("system.rootfs", cmd.Flags().Lookup("system-target"))
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			Fatal("Wrong number of arguments (expected 1)")
+		}
+
+		pack := packageFromArg(args[0])
+
+		systemDB := systemDatabase()
+
+		candidate, err := systemDB.FindPackageCandidate(pack)
+		if err != nil {
+			Fatal("Error: " + err.Error())
+		}
+		installed, err := systemDB.FindPackage(candidate)
+		if err != nil {
+			Fatal("Package " + args[0] + " is not installed")
+		}
+
+		chains := installed.RequirementChains(systemDB, maxChains)
+		if len(chains) == 1 && len(chains[0]) == 1 {
+			Info(":package:", installed.HumanReadableString(), "is not required by any installed package")
+			return
+		}
+
+		Info(":package:", installed.HumanReadableString(), "is required by:")
+		if len(chains) == maxChains {
+			Info("Showing only the first", maxChains, "requirement chains")
+		}
+		for _, chain := range chains {
+			var names []string
+			for _, p := range chain {
+				names = append(names, p.HumanReadableString())
+			}
+			fmt.Println("  " + strings.Join(names, " -> "))
+		}
+	},
+}
+
+func init() {
+	path, err := os.Getwd()
+	if err != nil {
+		Fatal(err)
+	}
+	whyCmd.Flags().String("system-dbpath", path, "System db path")
+	whyCmd.Flags().String("system-target", path, "System rootpath")
+	RootCmd.AddCommand(whyCmd)
+}
@@ -24,6 +24,7 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"time"
 
 	gzip "github.com/klauspost/pgzip"
 
@@ -60,6 +61,7 @@ func (i ArtifactIndex) CleanPath() ArtifactIndex {
 			Dependencies:    art.Dependencies,
 			CompressionType: art.CompressionType,
 			Checksums:       art.Checksums,
+			BuildTime:       art.BuildTime,
 		})
 	}
 	return newIndex
@@ -77,6 +79,8 @@ type PackageArtifact struct {
 	Checksums       Checksums                 `json:"checksums"`
 	SourceAssertion solver.PackagesAssertions `json:"-"`
 	CompressionType CompressionImplementation `json:"compressiontype"`
+	// BuildTime is the time the artifact archive was created, in RFC3339 format
+	BuildTime string `json:"buildtime,omitempty"`
 }
 
 func NewPackageArtifact(path string) Artifact {
@@ -119,6 +123,19 @@ func (a *PackageArtifact) GetChecksums() Checksums {
 func (a *PackageArtifact) SetChecksums(c Checksums) {
 	a.Checksums = c
 }
+// GetBuildTime returns the time the artifact archive was created, the zero time if it is unknown
+func (a *PackageArtifact) GetBuildTime() time.Time {
+	t, err := time.Parse(time.RFC3339, a.BuildTime)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func (a *PackageArtifact) SetBuildTime(t time.Time) {
+	a.BuildTime = t.UTC().Format(time.RFC3339)
+}
+
 func (a *PackageArtifact) Hash() error {
 	return a.Checksums.Generate(a)
 }
@@ -221,6 +238,7 @@ func (a *PackageArtifact) SetPath(p string) {
 
 // Compress Archives and compress (TODO) to the artifact path
 func (a *PackageArtifact) Compress(src string, concurrency int) error {
+	a.SetBuildTime(time.Now())
 	switch a.CompressionType {
 	case GZip:
 		err := helpers.Tar(src, a.Path)
@@ -17,6 +17,7 @@ package compiler
 
 import (
 	"runtime"
+	"time"
 
 	"github.com/mudler/luet/pkg/config"
 	pkg "github.com/mudler/luet/pkg/package"
@@ -96,6 +97,9 @@ type Artifact interface {
 
 	GetChecksums() Checksums
 	SetChecksums(c Checksums)
+
+	GetBuildTime() time.Time
+	SetBuildTime(t time.Time)
 }
 
 type ArtifactNode struct {
@@ -32,7 +32,7 @@ import (
 )
 
 var LuetCfg = NewLuetConfig(v.GetViper())
-var AvailableResolvers = strings.Join([]string{solver.QLearningResolverType}, " ")
+var AvailableResolvers = strings.Join([]string{solver.QLearningResolverType, solver.MinimalRelaxationResolverType}, " ")
 
 type LuetLoggingConfig struct {
 	Path       string `mapstructure:"path"`
@@ -50,6 +50,17 @@ type LuetGeneralConfig struct {
 	FatalWarns      bool `mapstructure:"fatal_warnings"`
 }
 
+type LuetDownloadConfig struct {
+	// Retries is the number of additional attempts on each mirror
+	Retries int `mapstructure:"retries"`
+	// BackoffMs is the delay before the first retry, doubled on each attempt
+	BackoffMs int `mapstructure:"backoff_ms"`
+	// Timeout is the maximum duration in seconds of each attempt on each mirror, 0 disables it
+	Timeout int `mapstructure:"timeout"`
+	// Concurrency is the number of artifacts downloaded in parallel
+	Concurrency int `mapstructure:"concurrency"`
+}
+
 type LuetSolverOptions struct {
 	Type        string  `mapstructure:"type"`
 	LearnRate   float32 `mapstructure:"rate"`
@@ -65,6 +76,8 @@ func (opts LuetSolverOptions) Resolver() solver.PackageResolver {
 
 		}
 		return solver.SimpleQLearningSolver()
+	case solver.MinimalRelaxationResolverType:
+		return solver.NewMinimalRelaxationResolver(opts.MaxAttempts)
 	}
 
 	return &solver.DummyPackageResolver{}
@@ -80,6 +93,8 @@ type LuetSystemConfig struct {
 	DatabasePath   string `yaml:"database_path" mapstructure:"database_path"`
 	Rootfs         string `yaml:"rootfs" mapstructure:"rootfs"`
 	PkgsCachePath  string `yaml:"pkgs_cache_path" mapstructure:"pkgs_cache_path"`
+	HooksDir       string `yaml:"hooks_dir" mapstructure:"hooks_dir"`
+	TriggersDir    string `yaml:"triggers_dir" mapstructure:"triggers_dir"`
 }
 
 func (sc LuetSystemConfig) GetRepoDatabaseDirPath(name string) string {
@@ -131,6 +146,10 @@ type LuetRepository struct {
 	Cached         bool              `json:"cached,omitempty" yaml:"cached,omitempty" mapstructure:"cached,omitempty"`
 	Authentication map[string]string `json:"auth,omitempty" yaml:"auth,omitempty" mapstructure:"auth,omitempty"`
 	TreePath       string            `json:"tree_path,omitempty" yaml:"tree_path,omitempty" mapstructure:"tree_path"`
+	// Timeout is the maximum duration in seconds of each download attempt from the urls, 0 uses the download timeout
+	Timeout int `json:"timeout,omitempty" yaml:"timeout,omitempty" mapstructure:"timeout,omitempty"`
+	// UrlTimeouts overrides Timeout for single urls
+	UrlTimeouts map[string]int `json:"url_timeouts,omitempty" yaml:"url_timeouts,omitempty" mapstructure:"url_timeouts,omitempty"`
 
 	// Serialized options not used in repository configuration
 
@@ -170,6 +189,18 @@ func NewEmptyLuetRepository() *LuetRepository {
 	}
 }
 
+// GetTimeout returns the maximum duration of each download attempt from url.
+// Without a timeout for the url or the repository, the download timeout applies.
+func (r *LuetRepository) GetTimeout(url string) time.Duration {
+	if t, ok := r.UrlTimeouts[url]; ok && t > 0 {
+		return time.Duration(t) * time.Second
+	}
+	if r.Timeout > 0 {
+		return time.Duration(r.Timeout) * time.Second
+	}
+	return LuetCfg.GetDownload().GetTimeout()
+}
+
 func (r *LuetRepository) String() string {
 	return fmt.Sprintf("[%s] prio: %d, type: %s, enable: %t, cached: %t",
 		r.Name, r.Priority, r.Type, r.Enable, r.Cached)
@@ -178,10 +209,11 @@ func (r *LuetRepository) String() string {
 type LuetConfig struct {
 	Viper *v.Viper
 
-	Logging LuetLoggingConfig `mapstructure:"logging"`
-	General LuetGeneralConfig `mapstructure:"general"`
-	System  LuetSystemConfig  `mapstructure:"system"`
-	Solver  LuetSolverOptions `mapstructure:"solver"`
+	Logging  LuetLoggingConfig  `mapstructure:"logging"`
+	General  LuetGeneralConfig  `mapstructure:"general"`
+	System   LuetSystemConfig   `mapstructure:"system"`
+	Solver   LuetSolverOptions  `mapstructure:"solver"`
+	Download LuetDownloadConfig `mapstructure:"download"`
 
 	RepositoriesConfDir []string         `mapstructure:"repos_confdir"`
 	CacheRepositories   []LuetRepository `mapstructure:"repetitors"`
@@ -220,11 +252,18 @@ func GenDefault(viper *v.Viper) {
 	viper.SetDefault("system.database_path", "/var/cache/luet")
 	viper.SetDefault("system.rootfs", "/")
 	viper.SetDefault("system.pkgs_cache_path", "packages")
+	viper.SetDefault("system.hooks_dir", "/etc/luet/hooks.d")
+	viper.SetDefault("system.triggers_dir", "/etc/luet/triggers.d")
 
 	viper.SetDefault("repos_confdir", []string{"/etc/luet/repos.conf.d"})
 	viper.SetDefault("cache_repositories", []string{})
 	viper.SetDefault("system_repositories", []string{})
 
+	viper.SetDefault("download.retries", 3)
+	viper.SetDefault("download.backoff_ms", 500)
+	viper.SetDefault("download.timeout", 300)
+	viper.SetDefault("download.concurrency", 4)
+
 	viper.SetDefault("solver.type", "")
 	viper.SetDefault("solver.rate", 0.7)
 	viper.SetDefault("solver.discount", 1.0)
@@ -251,6 +290,10 @@ func (c *LuetConfig) GetSolverOptions() *LuetSolverOptions {
 	return &c.Solver
 }
 
+func (c *LuetConfig) GetDownload() *LuetDownloadConfig {
+	return &c.Download
+}
+
 func (c *LuetConfig) GetSystemRepository(name string) (*LuetRepository, error) {
 	var ans *LuetRepository = nil
 
@@ -303,6 +346,25 @@ func (c *LuetGeneralConfig) GetSpinnerMs() time.Duration {
 	return duration
 }
 
+func (c *LuetDownloadConfig) String() string {
+	ans := fmt.Sprintf(`
+download:
+  retries: %d
+  backoff_ms: %d
+  timeout: %d
+  concurrency: %d`, c.Retries, c.BackoffMs, c.Timeout, c.Concurrency)
+
+	return ans
+}
+
+func (c *LuetDownloadConfig) GetBackoff() time.Duration {
+	return time.Duration(c.BackoffMs) * time.Millisecond
+}
+
+func (c *LuetDownloadConfig) GetTimeout() time.Duration {
+	return time.Duration(c.Timeout) * time.Second
+}
+
 func (c *LuetLoggingConfig) String() string {
 	ans := fmt.Sprintf(`
 logging:
@@ -319,8 +381,10 @@ system:
   database_engine: %s
   database_path: %s
   pkgs_cache_path: %s
+  hooks_dir: %s
+  triggers_dir: %s
   rootfs: %s`,
-		c.DatabaseEngine, c.DatabasePath, c.PkgsCachePath, c.Rootfs)
+		c.DatabaseEngine, c.DatabasePath, c.PkgsCachePath, c.HooksDir, c.TriggersDir, c.Rootfs)
 
 	return ans
 }
@@ -16,10 +16,13 @@
 package client_test
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	. "github.com/mudler/luet/cmd"
 	config "github.com/mudler/luet/pkg/config"
+	client "github.com/mudler/luet/pkg/installer/client"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -34,3 +37,8 @@ func TestClient(t *testing.T) {
 	config.LuetCfg.GetSystem().PkgsCachePath = ""
 	RunSpecs(t, "Client Suite")
 }
+
+// Mirrors start from the same ranking in every spec, even if their ports were used before
+var _ = BeforeEach(func() {
+	os.Remove(filepath.Join(config.LuetCfg.GetSystem().GetSystemRepoDatabaseDirPath(), client.MIRRORS_HEALTH_FILE))
+})
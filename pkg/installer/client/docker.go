@@ -0,0 +1,561 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mudler/luet/pkg/compiler"
+	"github.com/mudler/luet/pkg/config"
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/mudler/luet/pkg/logger"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	OCIManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	OCIConfigMediaType   = "application/vnd.oci.image.config.v1+json"
+	// LuetFileMediaType is the media type of the layers holding repository files
+	LuetFileMediaType = "application/vnd.luet.file.v1"
+
+	ociTitleAnnotation = "org.opencontainers.image.title"
+	maxTagLength       = 128
+)
+
+var invalidTagChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// registryStatusError is an unexpected status answered by the registry
+type registryStatusError struct {
+	Message string
+	Code    int
+}
+
+func (e *registryStatusError) Error() string {
+	return e.Message
+}
+
+func statusError(msg string, resp *http.Response) error {
+	return &registryStatusError{Message: msg + ": " + resp.Status, Code: resp.StatusCode}
+}
+
+// registryRef is a repository inside a registry, e.g. https://registry.example.com and org/repo
+type registryRef struct {
+	Base string
+	Name string
+}
+
+// DockerClient stores each repository file (repository.yaml, the tree tarball and the package artifacts)
+// as a single layer OCI artifact in a container registry, tagged after the file name.
+// Urls are in the form [scheme://]registry[:port]/repository, scheme defaults to https.
+type DockerClient struct {
+	RepoData RepoData
+
+	Progress *Progress
+
+	client *http.Client
+	tokens map[string]string
+}
+
+func NewDockerClient(r RepoData) *DockerClient {
+	return &DockerClient{RepoData: r, client: &http.Client{}, tokens: map[string]string{}}
+}
+
+func (c *DockerClient) SetProgress(p *Progress) {
+	c.Progress = p
+}
+
+// progressWriter reports the bytes written through it to a Progress
+type progressWriter struct {
+	progress *Progress
+	name     string
+	size     int64
+	done     int64
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	w.done += int64(len(b))
+	if w.progress != nil {
+		w.progress.Update(w.name, w.size, w.done)
+	}
+	return len(b), nil
+}
+
+// FileTag returns the tag used to store a file in the registry.
+// Characters which are not allowed in tags are replaced, and a hash of the name is appended
+// when any was replaced, so different names can't share the same tag. Long names are shortened.
+func FileTag(name string) string {
+	tag := invalidTagChars.ReplaceAllString(name, "_")
+	if strings.HasPrefix(tag, ".") || strings.HasPrefix(tag, "-") {
+		tag = "_" + tag
+	}
+	if tag == name && len(tag) <= maxTagLength {
+		return tag
+	}
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:16]
+	if len(tag)+len(sum)+1 > maxTagLength {
+		tag = tag[:maxTagLength-len(sum)-1]
+	}
+	return tag + "-" + sum
+}
+
+func parseRegistryRef(uri string) (*registryRef, error) {
+	if !strings.Contains(uri, "://") {
+		uri = "https://" + uri
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.Trim(u.Path, "/")
+	if u.Host == "" || name == "" {
+		return nil, errors.New("Invalid registry repository " + uri)
+	}
+	return &registryRef{Base: u.Scheme + "://" + u.Host, Name: name}, nil
+}
+
+func (r *registryRef) url(p string) string {
+	return r.Base + "/v2/" + r.Name + p
+}
+
+func (c *DockerClient) basicAuth() string {
+	if val, ok := c.RepoData.Authentication["basic"]; ok {
+		return val
+	}
+	user, hasUser := c.RepoData.Authentication["username"]
+	pass, hasPass := c.RepoData.Authentication["password"]
+	if hasUser || hasPass {
+		return base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
+	}
+	return ""
+}
+
+// parseChallenge parses a WWW-Authenticate header, returning the scheme and its parameters
+func parseChallenge(header string) (string, map[string]string) {
+	params := map[string]string{}
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	scheme := strings.ToLower(parts[0])
+	if len(parts) == 1 {
+		return scheme, params
+	}
+	for _, m := range regexp.MustCompile(`(\w+)="([^"]*)"`).FindAllStringSubmatch(parts[1], -1) {
+		params[strings.ToLower(m[1])] = m[2]
+	}
+	return scheme, params
+}
+
+func (c *DockerClient) fetchToken(ctx context.Context, params map[string]string) (string, error) {
+	realm, ok := params["realm"]
+	if !ok {
+		return "", errors.New("No realm in the registry authentication challenge")
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	if service, ok := params["service"]; ok {
+		q.Set("service", service)
+	}
+	if scope, ok := params["scope"]; ok {
+		q.Set("scope", scope)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	if basic := c.basicAuth(); basic != "" {
+		req.Header.Set("Authorization", "Basic "+basic)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", statusError("Failed getting registry token", resp)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", errors.Wrap(err, "Failed decoding registry token")
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// do performs a request against the registry, answering to the authentication challenges.
+// body is called for every attempt, so the request can be sent again after authenticating.
+func (c *DockerClient) do(ctx context.Context, ref *registryRef, method, u string, header http.Header, body func() (io.ReadCloser, int64, error)) (*http.Response, error) {
+	access := "pull"
+	if method != "GET" && method != "HEAD" {
+		access = "pull,push"
+	}
+	key := ref.Base + "/" + ref.Name + ":" + access
+
+	for attempt := 0; attempt < 2; attempt++ {
+		var reader io.ReadCloser
+		var size int64
+		if body != nil {
+			var err error
+			reader, size, err = body()
+			if err != nil {
+				return nil, err
+			}
+		}
+		req, err := http.NewRequest(method, u, reader)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		if body != nil {
+			req.ContentLength = size
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+
+		if token, ok := c.tokens[key]; ok {
+			req.Header.Set("Authorization", token)
+		} else if val, ok := c.RepoData.Authentication["token"]; ok {
+			req.Header.Set("Authorization", "Bearer "+val)
+		}
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		scheme, params := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+		switch scheme {
+		case "bearer":
+			if _, ok := params["scope"]; !ok {
+				params["scope"] = "repository:" + ref.Name + ":" + access
+			}
+			token, err := c.fetchToken(ctx, params)
+			if err != nil {
+				return nil, err
+			}
+			c.tokens[key] = "Bearer " + token
+		case "basic":
+			basic := c.basicAuth()
+			if basic == "" {
+				return nil, &registryStatusError{Message: "Registry requires credentials", Code: http.StatusUnauthorized}
+			}
+			c.tokens[key] = "Basic " + basic
+		default:
+			return nil, errors.New("Unsupported registry authentication: " + scheme)
+		}
+	}
+	return nil, errors.New("Registry authentication failed")
+}
+
+func (c *DockerClient) manifest(ctx context.Context, ref *registryRef, tag string) (*ociManifest, error) {
+	header := http.Header{}
+	header.Set("Accept", OCIManifestMediaType)
+	resp, err := c.do(ctx, ref, "GET", ref.url("/manifests/"+tag), header, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError("Failed getting manifest "+tag, resp)
+	}
+	var m ociManifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, errors.Wrap(err, "Failed decoding manifest "+tag)
+	}
+	if len(m.Layers) != 1 {
+		return nil, errors.New("Manifest " + tag + " is not a luet file")
+	}
+	return &m, nil
+}
+
+// download retrieves name from the registry repository and writes it to dst, returning its size
+func (c *DockerClient) download(ctx context.Context, ref *registryRef, name, dst string) (int64, error) {
+	m, err := c.manifest(ctx, ref, FileTag(name))
+	if err != nil {
+		return 0, err
+	}
+	layer := m.Layers[0]
+
+	resp, err := c.do(ctx, ref, "GET", ref.url("/blobs/"+layer.Digest), nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, statusError("Failed getting blob "+layer.Digest, resp)
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, hash, &progressWriter{progress: c.Progress, name: name, size: layer.Size}), resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if digest := fmt.Sprintf("sha256:%x", hash.Sum(nil)); digest != layer.Digest {
+		return 0, errors.New("Digest mismatch for " + name + ": expected " + layer.Digest + " got " + digest)
+	}
+	return n, nil
+}
+
+// downloadFromMirrors retrieves name to dst from the repository urls, with the retries, timeouts
+// and mirrors ranking of the download configuration.
+func (c *DockerClient) downloadFromMirrors(name, dst string) error {
+	if len(c.RepoData.Urls) == 0 {
+		return errors.New("No urls defined for the repository")
+	}
+
+	return downloadFromMirrors(name, c.RepoData, func(ctx context.Context, mirror string) (float64, bool, error) {
+		ref, err := parseRegistryRef(mirror)
+		if err != nil {
+			return 0, false, err
+		}
+
+		Debug("Downloading", name, "from", mirror)
+		start := time.Now()
+		n, err := c.download(ctx, ref, name, dst)
+		if statusErr, ok := err.(*registryStatusError); ok {
+			return 0, retryableStatus(statusErr.Code), err
+		} else if err != nil {
+			return 0, true, err
+		}
+
+		Info("Downloaded", name, "of", fmt.Sprintf("%.2f", (float64(n)/1000)/1000), "MB")
+		return float64(n) / time.Since(start).Seconds(), true, nil
+	})
+}
+
+func (c *DockerClient) DownloadArtifact(artifact compiler.Artifact) (compiler.Artifact, error) {
+	artifactName := path.Base(artifact.GetPath())
+	cacheFile := filepath.Join(config.LuetCfg.GetSystem().GetSystemPkgsCacheDirPath(), artifactName)
+
+	// Check if file is already in cache
+	if helpers.Exists(cacheFile) {
+		Info("Use artifact", artifactName, "from cache.")
+	} else {
+		temp, err := ioutil.TempDir(os.TempDir(), "tree")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(temp)
+
+		Info("Downloading artifact", artifactName)
+		if err := c.downloadFromMirrors(artifactName, filepath.Join(temp, artifactName)); err != nil {
+			return nil, err
+		}
+		if err := helpers.CopyFile(filepath.Join(temp, artifactName), cacheFile); err != nil {
+			return nil, err
+		}
+	}
+
+	newart := artifact
+	newart.SetPath(cacheFile)
+	return newart, nil
+}
+
+func (c *DockerClient) DownloadFile(name string) (string, error) {
+	file, err := ioutil.TempFile(os.TempDir(), "DockerClient")
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+
+	Info("Downloading", name)
+	if err := c.downloadFromMirrors(name, file.Name()); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+func (c *DockerClient) blobExists(ref *registryRef, digest string) (bool, error) {
+	resp, err := c.do(context.Background(), ref, "HEAD", ref.url("/blobs/"+digest), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+func (c *DockerClient) uploadBlob(ref *registryRef, digest string, body func() (io.ReadCloser, int64, error)) error {
+	exists, err := c.blobExists(ref, digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	resp, err := c.do(context.Background(), ref, "POST", ref.url("/blobs/uploads/"), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return errors.New("Failed starting blob upload: " + resp.Status)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return err
+	}
+	base, _ := url.Parse(ref.Base)
+	location = base.ResolveReference(location)
+	q := location.Query()
+	q.Set("digest", digest)
+	location.RawQuery = q.Encode()
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	resp, err = c.do(context.Background(), ref, "PUT", location.String(), header, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return errors.New("Failed uploading blob " + digest + ": " + resp.Status)
+	}
+	return nil
+}
+
+func fileDigest(p string) (string, int64, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	n, err := io.Copy(hash, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), n, nil
+}
+
+func (c *DockerClient) upload(ref *registryRef, p, name string) error {
+	digest, size, err := fileDigest(p)
+	if err != nil {
+		return err
+	}
+	err = c.uploadBlob(ref, digest, func() (io.ReadCloser, int64, error) {
+		f, err := os.Open(p)
+		return f, size, err
+	})
+	if err != nil {
+		return errors.Wrap(err, "Failed uploading "+name)
+	}
+
+	configData := []byte("{}")
+	configDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(configData))
+	err = c.uploadBlob(ref, configDigest, func() (io.ReadCloser, int64, error) {
+		return ioutil.NopCloser(bytes.NewReader(configData)), int64(len(configData)), nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "Failed uploading config for "+name)
+	}
+
+	manifest, err := json.Marshal(&ociManifest{
+		SchemaVersion: 2,
+		MediaType:     OCIManifestMediaType,
+		Config:        ociDescriptor{MediaType: OCIConfigMediaType, Digest: configDigest, Size: int64(len(configData))},
+		Layers: []ociDescriptor{{
+			MediaType:   LuetFileMediaType,
+			Digest:      digest,
+			Size:        size,
+			Annotations: map[string]string{ociTitleAnnotation: name},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", OCIManifestMediaType)
+	resp, err := c.do(context.Background(), ref, "PUT", ref.url("/manifests/"+FileTag(name)), header, func() (io.ReadCloser, int64, error) {
+		return ioutil.NopCloser(bytes.NewReader(manifest)), int64(len(manifest)), nil
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return errors.New("Failed uploading manifest for " + name + ": " + resp.Status)
+	}
+	return nil
+}
+
+// UploadFile pushes the file p as name to all the repository urls
+func (c *DockerClient) UploadFile(p, name string) error {
+	for _, uri := range c.RepoData.Urls {
+		ref, err := parseRegistryRef(uri)
+		if err != nil {
+			return err
+		}
+		Info("Uploading", name, "to", uri)
+		if err := c.upload(ref, p, name); err != nil {
+			return errors.Wrap(err, "While uploading to "+uri)
+		}
+	}
+	return nil
+}
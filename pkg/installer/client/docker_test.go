@@ -0,0 +1,241 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package client_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	compiler "github.com/mudler/luet/pkg/compiler"
+	config "github.com/mudler/luet/pkg/config"
+	helpers "github.com/mudler/luet/pkg/helpers"
+
+	. "github.com/mudler/luet/pkg/installer/client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeRegistry is a minimal in-process implementation of the registry API,
+// protected by a token server which accepts only user:pass
+type fakeRegistry struct {
+	sync.Mutex
+	server    *httptest.Server
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   int
+}
+
+func newFakeRegistry() *fakeRegistry {
+	r := &fakeRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}}
+	r.server = httptest.NewServer(r)
+	return r
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.Lock()
+	defer r.Unlock()
+
+	if req.URL.Path == "/token" {
+		user, pass, ok := req.BasicAuth()
+		if !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token": "secret"}`)
+		return
+	}
+
+	if req.Header.Get("Authorization") != "Bearer secret" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+r.server.URL+`/token",service="fake"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	p := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case strings.Contains(p, "/blobs/uploads/"):
+		name := p[:strings.Index(p, "/blobs/uploads/")]
+		if req.Method == "POST" {
+			r.uploads++
+			w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%d", name, r.uploads))
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		data, _ := ioutil.ReadAll(req.Body)
+		digest := req.URL.Query().Get("digest")
+		if digest != fmt.Sprintf("sha256:%x", sha256.Sum256(data)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.blobs[digest] = data
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(p, "/blobs/"):
+		data, ok := r.blobs[p[strings.LastIndex(p, "/")+1:]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case strings.Contains(p, "/manifests/"):
+		if req.Method == "PUT" {
+			data, _ := ioutil.ReadAll(req.Body)
+			r.manifests[p] = data
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		data, ok := r.manifests[p]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", OCIManifestMediaType)
+		w.Write(data)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+var _ = Describe("Docker client", func() {
+	var registry *fakeRegistry
+	var tmpdir string
+
+	BeforeEach(func() {
+		var err error
+		registry = newFakeRegistry()
+		tmpdir, err = ioutil.TempDir("", "test")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		registry.server.Close()
+		os.RemoveAll(tmpdir)
+	})
+
+	Context("With repository", func() {
+		It("Uploads and downloads single files", func() {
+			err := ioutil.WriteFile(filepath.Join(tmpdir, "repository.yaml"), []byte(`test`), os.ModePerm)
+			Expect(err).ToNot(HaveOccurred())
+
+			c := NewDockerClient(RepoData{
+				Urls:           []string{registry.server.URL + "/luet/repo"},
+				Authentication: map[string]string{"username": "user", "password": "pass"},
+			})
+			Expect(c.UploadFile(filepath.Join(tmpdir, "repository.yaml"), "repository.yaml")).ToNot(HaveOccurred())
+			Expect(registry.manifests).To(HaveKey("luet/repo/manifests/repository.yaml"))
+
+			path, err := c.DownloadFile("repository.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(helpers.Read(path)).To(Equal("test"))
+			os.RemoveAll(path)
+		})
+
+		It("Downloads artifacts", func() {
+			err := ioutil.WriteFile(filepath.Join(tmpdir, "a-1.0.package.tar"), []byte(`artifact`), os.ModePerm)
+			Expect(err).ToNot(HaveOccurred())
+
+			c := NewDockerClient(RepoData{
+				Urls:           []string{registry.server.URL + "/luet/repo"},
+				Authentication: map[string]string{"username": "user", "password": "pass"},
+			})
+			Expect(c.UploadFile(filepath.Join(tmpdir, "a-1.0.package.tar"), "a-1.0.package.tar")).ToNot(HaveOccurred())
+
+			path, err := c.DownloadArtifact(&compiler.PackageArtifact{Path: "a-1.0.package.tar"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(helpers.Read(path.GetPath())).To(Equal("artifact"))
+			os.RemoveAll(path.GetPath())
+		})
+
+		It("Fails without valid credentials", func() {
+			err := ioutil.WriteFile(filepath.Join(tmpdir, "test.txt"), []byte(`test`), os.ModePerm)
+			Expect(err).ToNot(HaveOccurred())
+
+			c := NewDockerClient(RepoData{
+				Urls:           []string{registry.server.URL + "/luet/repo"},
+				Authentication: map[string]string{"username": "user", "password": "wrong"},
+			})
+			Expect(c.UploadFile(filepath.Join(tmpdir, "test.txt"), "test.txt")).To(HaveOccurred())
+			Expect(registry.blobs).To(BeEmpty())
+		})
+
+		It("Fails on missing files", func() {
+			c := NewDockerClient(RepoData{
+				Urls:           []string{registry.server.URL + "/luet/repo"},
+				Authentication: map[string]string{"username": "user", "password": "pass"},
+			})
+			_, err := c.DownloadFile("missing.txt")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(registry.server.URL + "/luet/repo"))
+		})
+
+		It("Retries and fails over to the next registry", func() {
+			defer func(d config.LuetDownloadConfig) { *config.LuetCfg.GetDownload() = d }(*config.LuetCfg.GetDownload())
+			config.LuetCfg.GetDownload().Retries = 2
+			config.LuetCfg.GetDownload().BackoffMs = 1
+
+			var brokenRequests int32
+			broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&brokenRequests, 1)
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer broken.Close()
+
+			err := ioutil.WriteFile(filepath.Join(tmpdir, "repository.yaml"), []byte(`test`), os.ModePerm)
+			Expect(err).ToNot(HaveOccurred())
+			auth := map[string]string{"username": "user", "password": "pass"}
+			Expect(NewDockerClient(RepoData{Urls: []string{registry.server.URL + "/luet/repo"}, Authentication: auth}).
+				UploadFile(filepath.Join(tmpdir, "repository.yaml"), "repository.yaml")).ToNot(HaveOccurred())
+
+			c := NewDockerClient(RepoData{
+				Urls:           []string{broken.URL + "/luet/repo", registry.server.URL + "/luet/repo"},
+				Authentication: auth,
+			})
+			path, err := c.DownloadFile("repository.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(path)
+			Expect(helpers.Read(path)).To(Equal("test"))
+			Expect(atomic.LoadInt32(&brokenRequests)).To(Equal(int32(3)))
+		})
+
+		It("Fails without urls", func() {
+			c := NewDockerClient(RepoData{})
+			_, err := c.DownloadArtifact(&compiler.PackageArtifact{Path: "a-1.0.package.tar"})
+			Expect(err).To(HaveOccurred())
+			_, err = c.DownloadFile("repository.yaml")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Tags", func() {
+		It("Sanitizes file names", func() {
+			Expect(FileTag("repository.yaml")).To(Equal("repository.yaml"))
+			Expect(FileTag("cat-name+1.0.package.tar.gz")).To(HavePrefix("cat-name_1.0.package.tar.gz-"))
+			Expect(FileTag("cat-name+1.0.package.tar.gz")).ToNot(Equal(FileTag("cat-name_1.0.package.tar.gz")))
+			Expect(FileTag("cat-name_1.0.package.tar.gz")).To(Equal("cat-name_1.0.package.tar.gz"))
+			Expect(FileTag(".hidden")).To(HavePrefix("_.hidden-"))
+			long := FileTag(strings.Repeat("a", 200))
+			Expect(len(long)).To(Equal(128))
+			Expect(long).ToNot(Equal(FileTag(strings.Repeat("a", 201))))
+		})
+	})
+})
@@ -16,6 +16,7 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"math"
@@ -23,6 +24,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"time"
 
 	. "github.com/mudler/luet/pkg/logger"
 
@@ -35,12 +37,17 @@ import (
 
 type HttpClient struct {
 	RepoData RepoData
+	Progress *Progress
 }
 
 func NewHttpClient(r RepoData) *HttpClient {
 	return &HttpClient{RepoData: r}
 }
 
+func (c *HttpClient) SetProgress(p *Progress) {
+	c.Progress = p
+}
+
 func (c *HttpClient) PrepareReq(dst, url string) (*grab.Request, error) {
 
 	req, err := grab.NewRequest(dst, url)
@@ -64,63 +71,83 @@ func Round(input float64) float64 {
 	return math.Floor(input + 0.5)
 }
 
-func (c *HttpClient) DownloadArtifact(artifact compiler.Artifact) (compiler.Artifact, error) {
-	var u *url.URL = nil
-	var err error
-	var req *grab.Request
-	var temp string
-
-	artifactName := path.Base(artifact.GetPath())
-	cacheFile := filepath.Join(config.LuetCfg.GetSystem().GetSystemPkgsCacheDirPath(), artifactName)
-	ok := false
-
-	// Check if file is already in cache
-	if helpers.Exists(cacheFile) {
-		Info("Use artifact", artifactName, "from cache.")
-	} else {
+// download retrieves name from the repository urls to dst, failing over between the mirrors.
+// Partial downloads are kept next to dst and resumed by the following attempts on the same mirror.
+func (c *HttpClient) download(name, dst string) error {
+	partial := dst + ".part"
+	partialMirror := ""
+	client := grab.NewClient()
 
-		temp, err = ioutil.TempDir(os.TempDir(), "tree")
+	return downloadFromMirrors(name, c.RepoData, func(ctx context.Context, mirror string) (float64, bool, error) {
+		u, err := url.Parse(mirror)
 		if err != nil {
-			return nil, err
+			return 0, false, err
 		}
-		defer os.RemoveAll(temp)
-
-		client := grab.NewClient()
-
-		for _, uri := range c.RepoData.Urls {
-			Info("Downloading artifact", artifactName, "from", uri)
-
-			u, err = url.Parse(uri)
-			if err != nil {
-				continue
-			}
-			u.Path = path.Join(u.Path, artifactName)
+		u.Path = path.Join(u.Path, name)
 
-			req, err = c.PrepareReq(temp, u.String())
-			if err != nil {
-				continue
-			}
+		// Mirrors may serve different builds of the same file, don't mix their parts
+		if partialMirror != "" && partialMirror != mirror {
+			os.Remove(partial)
+		}
+		partialMirror = mirror
 
-			resp := client.Do(req)
-			if err = resp.Err(); err != nil {
-				continue
-			}
+		resp, err := c.fetch(ctx, client, name, u.String(), partial)
+		if err == nil {
+			err = os.Rename(partial, dst)
+		}
+		switch {
+		case err == grab.ErrBadLength:
+			// The partial file doesn't belong to the remote one
+			os.Remove(partial)
+			return 0, true, err
+		case grab.IsStatusCodeError(err):
+			return 0, retryableStatus(int(err.(grab.StatusCodeError))), err
+		case err != nil:
+			return 0, true, err
+		}
 
-			Info("Downloaded", artifactName, "of",
-				fmt.Sprintf("%.2f", (float64(resp.BytesComplete())/1000)/1000), "MB (",
-				fmt.Sprintf("%.2f", (float64(resp.BytesPerSecond())/1024)/1024), "MiB/s )")
+		Info("Downloaded", name, "of",
+			fmt.Sprintf("%.2f", (float64(resp.BytesComplete())/1000)/1000), "MB (",
+			fmt.Sprintf("%.2f", (float64(resp.BytesPerSecond())/1024)/1024), "MiB/s )")
+		return resp.BytesPerSecond(), true, nil
+	})
+}
 
-			Debug("Copying file ", filepath.Join(temp, artifactName), "to", cacheFile)
-			err = helpers.CopyFile(filepath.Join(temp, artifactName), cacheFile)
-			if err != nil {
-				continue
+func (c *HttpClient) fetch(ctx context.Context, client *grab.Client, name, u, dst string) (*grab.Response, error) {
+	req, err := c.PrepareReq(dst, u)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	Debug("Downloading", u)
+	resp := client.Do(req)
+	if c.Progress != nil {
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+	TRANSFER:
+		for {
+			select {
+			case <-resp.Done:
+				break TRANSFER
+			case <-ticker.C:
+				c.Progress.Update(name, resp.Size, resp.BytesComplete())
 			}
-
-			ok = true
-			break
 		}
+	}
+	return resp, resp.Err()
+}
 
-		if !ok {
+func (c *HttpClient) DownloadArtifact(artifact compiler.Artifact) (compiler.Artifact, error) {
+	artifactName := path.Base(artifact.GetPath())
+	cacheFile := filepath.Join(config.LuetCfg.GetSystem().GetSystemPkgsCacheDirPath(), artifactName)
+
+	// Check if file is already in cache
+	if helpers.Exists(cacheFile) {
+		Info("Use artifact", artifactName, "from cache.")
+	} else {
+		Info("Downloading artifact", artifactName)
+		if err := c.download(artifactName, cacheFile); err != nil {
 			return nil, err
 		}
 	}
@@ -131,61 +158,18 @@ func (c *HttpClient) DownloadArtifact(artifact compiler.Artifact) (compiler.Arti
 }
 
 func (c *HttpClient) DownloadFile(name string) (string, error) {
-	var file *os.File = nil
-	var u *url.URL = nil
-	var err error
-	var req *grab.Request
-	var temp string
-
-	ok := false
-
-	temp, err = ioutil.TempDir(os.TempDir(), "tree")
+	file, err := ioutil.TempFile(os.TempDir(), "HttpClient")
 	if err != nil {
 		return "", err
 	}
+	file.Close()
 
-	client := grab.NewClient()
-
-	for _, uri := range c.RepoData.Urls {
-
-		file, err = ioutil.TempFile(os.TempDir(), "HttpClient")
-		if err != nil {
-			continue
-		}
-
-		u, err = url.Parse(uri)
-		if err != nil {
-			continue
-		}
-		u.Path = path.Join(u.Path, name)
-
-		Info("Downloading", u.String())
-
-		req, err = c.PrepareReq(temp, u.String())
-		if err != nil {
-			continue
-		}
-
-		resp := client.Do(req)
-		if err = resp.Err(); err != nil {
-			continue
-		}
-
-		Info("Downloaded", filepath.Base(resp.Filename), "of",
-			fmt.Sprintf("%.2f", (float64(resp.BytesComplete())/1000)/1000), "MB (",
-			fmt.Sprintf("%.2f", (float64(resp.BytesPerSecond())/1024)/1024), "MiB/s )")
-
-		err = helpers.CopyFile(filepath.Join(temp, name), file.Name())
-		if err != nil {
-			continue
-		}
-		ok = true
-		break
-	}
-
-	if !ok {
+	Info("Downloading", name)
+	if err := c.download(name, file.Name()); err != nil {
+		os.Remove(file.Name())
+		os.Remove(file.Name() + ".part")
 		return "", err
 	}
 
-	return file.Name(), err
+	return file.Name(), nil
 }
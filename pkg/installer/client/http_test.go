@@ -21,8 +21,12 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	compiler "github.com/mudler/luet/pkg/compiler"
+	config "github.com/mudler/luet/pkg/config"
 	helpers "github.com/mudler/luet/pkg/helpers"
 
 	. "github.com/mudler/luet/pkg/installer/client"
@@ -70,4 +74,179 @@ var _ = Describe("Http client", func() {
 		})
 
 	})
+
+	Context("With mirrors", func() {
+		var tmpdir string
+		var good, broken *httptest.Server
+		var brokenRequests int32
+		var download config.LuetDownloadConfig
+
+		BeforeEach(func() {
+			var err error
+			tmpdir, err = ioutil.TempDir("", "test")
+			Expect(err).ToNot(HaveOccurred())
+			err = ioutil.WriteFile(filepath.Join(tmpdir, "test.txt"), []byte(`test content`), os.ModePerm)
+			Expect(err).ToNot(HaveOccurred())
+
+			good = httptest.NewServer(http.FileServer(http.Dir(tmpdir)))
+			brokenRequests = 0
+			broken = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&brokenRequests, 1)
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+
+			download = *config.LuetCfg.GetDownload()
+			config.LuetCfg.GetDownload().Retries = 2
+			config.LuetCfg.GetDownload().BackoffMs = 1
+		})
+
+		AfterEach(func() {
+			good.Close()
+			broken.Close()
+			os.RemoveAll(tmpdir)
+			*config.LuetCfg.GetDownload() = download
+		})
+
+		It("Retries and fails over to the next mirror", func() {
+			c := NewHttpClient(RepoData{Urls: []string{broken.URL, good.URL}})
+			path, err := c.DownloadFile("test.txt")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(path)
+			Expect(helpers.Read(path)).To(Equal("test content"))
+			Expect(brokenRequests).To(Equal(int32(3)))
+
+			// The failing mirror is ranked last from now on
+			Expect(LoadMirrorHealth().Rank([]string{broken.URL, good.URL})).To(Equal([]string{good.URL, broken.URL}))
+			info, err := os.Stat(filepath.Join(config.LuetCfg.GetSystem().GetSystemRepoDatabaseDirPath(), MIRRORS_HEALTH_FILE))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0644)))
+			atomic.StoreInt32(&brokenRequests, 0)
+			path2, err := c.DownloadFile("test.txt")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(path2)
+			Expect(brokenRequests).To(Equal(int32(0)))
+		})
+
+		It("Reports all the failed attempts", func() {
+			c := NewHttpClient(RepoData{Urls: []string{broken.URL, good.URL}})
+			_, err := c.DownloadFile("missing.txt")
+			Expect(err).To(HaveOccurred())
+			downloadErr, ok := err.(*DownloadError)
+			Expect(ok).To(BeTrue())
+			// Three attempts on the broken mirror, the not found isn't retried
+			Expect(len(downloadErr.Errors)).To(Equal(4))
+			Expect(err.Error()).To(ContainSubstring("500"))
+			Expect(err.Error()).To(ContainSubstring("404"))
+		})
+
+		It("Resumes partial downloads from the cache", func() {
+			cache, err := ioutil.TempDir("", "cache")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(cache)
+			defer func(p string) { config.LuetCfg.GetSystem().PkgsCachePath = p }(config.LuetCfg.GetSystem().PkgsCachePath)
+			config.LuetCfg.GetSystem().PkgsCachePath = cache
+
+			err = ioutil.WriteFile(filepath.Join(cache, "test.txt.part"), []byte(`test`), os.ModePerm)
+			Expect(err).ToNot(HaveOccurred())
+
+			var ranges []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "GET" {
+					ranges = append(ranges, r.Header.Get("Range"))
+				}
+				http.FileServer(http.Dir(tmpdir)).ServeHTTP(w, r)
+			}))
+			defer server.Close()
+
+			c := NewHttpClient(RepoData{Urls: []string{server.URL}})
+			a, err := c.DownloadArtifact(&compiler.PackageArtifact{Path: "test.txt"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(helpers.Read(a.GetPath())).To(Equal("test content"))
+			Expect(ranges).To(Equal([]string{"bytes=4-"}))
+			Expect(helpers.Exists(filepath.Join(cache, "test.txt.part"))).To(BeFalse())
+		})
+
+		It("Restarts partial downloads on a different mirror", func() {
+			config.LuetCfg.GetDownload().Retries = 0
+			interrupted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "12")
+				w.Write([]byte(`test`))
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			}))
+			defer interrupted.Close()
+
+			var ranges []string
+			other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "GET" {
+					ranges = append(ranges, r.Header.Get("Range"))
+				}
+				http.ServeContent(w, r, "test.txt", time.Time{}, strings.NewReader(`other build!`))
+			}))
+			defer other.Close()
+
+			c := NewHttpClient(RepoData{Urls: []string{interrupted.URL, other.URL}})
+			path, err := c.DownloadFile("test.txt")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(path)
+			Expect(helpers.Read(path)).To(Equal("other build!"))
+			Expect(ranges).To(Equal([]string{""}))
+		})
+
+		It("Gives up on slow mirrors", func() {
+			config.LuetCfg.GetDownload().Retries = 0
+			config.LuetCfg.GetDownload().Timeout = 1
+			slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(1500 * time.Millisecond)
+			}))
+			defer slow.Close()
+
+			c := NewHttpClient(RepoData{Urls: []string{slow.URL, good.URL}})
+			path, err := c.DownloadFile("test.txt")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(path)
+			Expect(helpers.Read(path)).To(Equal("test content"))
+		})
+
+		It("Applies the timeout of each mirror", func() {
+			config.LuetCfg.GetDownload().Retries = 0
+			config.LuetCfg.GetDownload().Timeout = 0
+			slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(1500 * time.Millisecond)
+			}))
+			defer slow.Close()
+
+			c := NewHttpClient(RepoData{
+				Urls:     []string{slow.URL, good.URL},
+				Timeouts: map[string]time.Duration{slow.URL: time.Second},
+			})
+			path, err := c.DownloadFile("test.txt")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(path)
+			Expect(helpers.Read(path)).To(Equal("test content"))
+		})
+
+		It("Applies the timeout to each attempt on each mirror", func() {
+			config.LuetCfg.GetDownload().Retries = 1
+			config.LuetCfg.GetDownload().Timeout = 1
+			release := make(chan struct{})
+			var slowRequests int32
+			stall := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&slowRequests, 1)
+				<-release
+			})
+			slow1 := httptest.NewServer(stall)
+			slow2 := httptest.NewServer(stall)
+			defer slow1.Close()
+			defer slow2.Close()
+			defer close(release)
+
+			c := NewHttpClient(RepoData{Urls: []string{slow1.URL, slow2.URL, good.URL}})
+			path, err := c.DownloadFile("test.txt")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(path)
+			Expect(helpers.Read(path)).To(Equal("test content"))
+			Expect(atomic.LoadInt32(&slowRequests)).To(Equal(int32(4)))
+		})
+	})
 })
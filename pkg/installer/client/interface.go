@@ -15,7 +15,23 @@
 
 package client
 
+import (
+	"time"
+
+	"github.com/mudler/luet/pkg/config"
+)
+
 type RepoData struct {
 	Urls           []string
 	Authentication map[string]string
+	// Timeouts is the maximum duration of each download attempt from the urls.
+	// Urls without a timeout use the download timeout.
+	Timeouts map[string]time.Duration
+}
+
+func (r RepoData) timeout(url string) time.Duration {
+	if t, ok := r.Timeouts[url]; ok {
+		return t
+	}
+	return config.LuetCfg.GetDownload().GetTimeout()
 }
@@ -0,0 +1,222 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/mudler/luet/pkg/config"
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/mudler/luet/pkg/logger"
+	"github.com/pkg/errors"
+)
+
+const MIRRORS_HEALTH_FILE = "mirrors.yaml"
+
+// healthMutex serializes the updates of the mirrors health file
+var healthMutex sync.Mutex
+
+// MirrorStats tracks how a mirror behaved in the previous downloads
+type MirrorStats struct {
+	// Failures is the number of consecutive failed attempts
+	Failures int `json:"failures"`
+	// Speed is the average download speed in bytes/s
+	Speed       float64 `json:"speed"`
+	LastFailure int64   `json:"last_failure,omitempty"`
+	LastSuccess int64   `json:"last_success,omitempty"`
+}
+
+// MirrorHealth is the ranking of the mirrors, persisted across runs
+type MirrorHealth struct {
+	Mirrors map[string]*MirrorStats `json:"mirrors"`
+}
+
+func mirrorHealthPath() string {
+	return filepath.Join(config.LuetCfg.GetSystem().GetSystemRepoDatabaseDirPath(), MIRRORS_HEALTH_FILE)
+}
+
+// LoadMirrorHealth reads the mirrors health from the system database directory.
+// A missing or corrupted file results in an empty ranking.
+func LoadMirrorHealth() *MirrorHealth {
+	h := &MirrorHealth{Mirrors: map[string]*MirrorStats{}}
+	path := mirrorHealthPath()
+	if !helpers.Exists(path) {
+		return h
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return h
+	}
+	if err := yaml.Unmarshal(data, h); err != nil || h.Mirrors == nil {
+		return &MirrorHealth{Mirrors: map[string]*MirrorStats{}}
+	}
+	return h
+}
+
+// UpdateMirrorHealth loads the mirrors health, applies f and writes it back
+func UpdateMirrorHealth(f func(*MirrorHealth)) error {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+
+	h := LoadMirrorHealth()
+	f(h)
+	data, err := yaml.Marshal(h)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(mirrorHealthPath(), data, 0644)
+}
+
+// updateMirrorHealth updates the mirrors health, failing to persist it doesn't prevent the downloads
+func updateMirrorHealth(f func(*MirrorHealth)) {
+	if err := UpdateMirrorHealth(f); err != nil {
+		Warning("Failed updating the mirrors health:", err.Error())
+	}
+}
+
+func (h *MirrorHealth) stats(mirror string) *MirrorStats {
+	s, ok := h.Mirrors[mirror]
+	if !ok {
+		s = &MirrorStats{}
+		h.Mirrors[mirror] = s
+	}
+	return s
+}
+
+// Success records a successful download from mirror at the given speed in bytes/s
+func (h *MirrorHealth) Success(mirror string, speed float64) {
+	s := h.stats(mirror)
+	s.Failures = 0
+	s.LastSuccess = time.Now().Unix()
+	if speed <= 0 {
+		return
+	}
+	if s.Speed == 0 {
+		s.Speed = speed
+	} else {
+		s.Speed = 0.7*s.Speed + 0.3*speed
+	}
+}
+
+// Failure records a failed download attempt from mirror
+func (h *MirrorHealth) Failure(mirror string) {
+	s := h.stats(mirror)
+	s.Failures++
+	s.LastFailure = time.Now().Unix()
+}
+
+// Rank sorts the mirrors from the most to the least reliable: failing mirrors go last,
+// the others are sorted by speed. Mirrors never used before are tried first, to learn their speed,
+// and the given order is kept on ties.
+func (h *MirrorHealth) Rank(mirrors []string) []string {
+	score := func(mirror string) (int, float64) {
+		s, ok := h.Mirrors[mirror]
+		if !ok {
+			return 0, math.Inf(1)
+		}
+		if s.Speed == 0 {
+			return s.Failures, math.Inf(1)
+		}
+		return s.Failures, s.Speed
+	}
+
+	ranked := append([]string{}, mirrors...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		failuresA, speedA := score(ranked[i])
+		failuresB, speedB := score(ranked[j])
+		if failuresA != failuresB {
+			return failuresA < failuresB
+		}
+		return speedA > speedB
+	})
+	return ranked
+}
+
+// mirrorAttempt downloads a file from a mirror, returning the download speed in bytes/s.
+// retry tells whether a failed attempt is worth repeating on the same mirror.
+type mirrorAttempt func(ctx context.Context, mirror string) (speed float64, retry bool, err error)
+
+// downloadFromMirrors runs attempt on the repository urls, ranked by their health, each one up to Retries more
+// times with an exponential backoff. Every attempt on every mirror has the timeout of the mirror, so a slow mirror
+// can't consume the time of the following ones. The health of the mirrors is updated with the outcome.
+func downloadFromMirrors(name string, data RepoData, attempt mirrorAttempt) error {
+	opts := config.LuetCfg.GetDownload()
+	downloadErr := &DownloadError{Name: name}
+
+	for _, mirror := range LoadMirrorHealth().Rank(data.Urls) {
+		for i := 0; i <= opts.Retries; i++ {
+			if i > 0 {
+				backoff := opts.GetBackoff() * time.Duration(1<<uint(i-1))
+				Debug("Retrying", name, "from", mirror, "in", backoff)
+				time.Sleep(backoff)
+			}
+
+			ctx, cancel := attemptContext(data.timeout(mirror))
+			speed, retry, err := attempt(ctx, mirror)
+			cancel()
+			if err == nil {
+				updateMirrorHealth(func(h *MirrorHealth) { h.Success(mirror, speed) })
+				return nil
+			}
+
+			Debug("Failed downloading", name, "from", mirror, ":", err.Error())
+			downloadErr.Errors = append(downloadErr.Errors, errors.Wrapf(err, "%s (attempt %d)", mirror, i+1))
+			updateMirrorHealth(func(h *MirrorHealth) { h.Failure(mirror) })
+			if !retry {
+				break
+			}
+		}
+	}
+
+	return downloadErr
+}
+
+// attemptContext returns the context of a single download attempt, bounded by timeout when set
+func attemptContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// retryableStatus returns true if a request answered with the HTTP status code is worth repeating
+func retryableStatus(code int) bool {
+	return code < 400 || code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+}
+
+// DownloadError collects the errors of all the attempts made to download a file
+type DownloadError struct {
+	Name   string
+	Errors []error
+}
+
+func (e *DownloadError) Error() string {
+	var msgs []string
+	for _, err := range e.Errors {
+		msgs = append(msgs, "  "+err.Error())
+	}
+	return fmt.Sprintf("Failed downloading %s after %d attempts:\n%s", e.Name, len(e.Errors), strings.Join(msgs, "\n"))
+}
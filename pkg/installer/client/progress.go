@@ -0,0 +1,113 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type transfer struct {
+	size     int64
+	done     int64
+	initial  int64
+	complete bool
+}
+
+// Progress aggregates the state of concurrent downloads, reporting the transferred bytes and the ETA.
+// Clients update it while transferring, and the caller marks each file as complete.
+type Progress struct {
+	sync.Mutex
+
+	// Files is the number of files expected to be downloaded
+	Files int
+
+	started   time.Time
+	transfers map[string]*transfer
+}
+
+func NewProgress(files int) *Progress {
+	return &Progress{Files: files, started: time.Now(), transfers: map[string]*transfer{}}
+}
+
+func (p *Progress) get(name string, done int64) *transfer {
+	t, ok := p.transfers[name]
+	if !ok {
+		// Bytes already present (e.g. resumed downloads) don't count for the speed
+		t = &transfer{initial: done}
+		p.transfers[name] = t
+	}
+	return t
+}
+
+// Update records that done bytes of name are available, out of size (0 if unknown)
+func (p *Progress) Update(name string, size, done int64) {
+	p.Lock()
+	defer p.Unlock()
+	t := p.get(name, done)
+	if size > 0 {
+		t.size = size
+	}
+	t.done = done
+}
+
+// Complete marks name as available, with the given size
+func (p *Progress) Complete(name string, size int64) {
+	p.Lock()
+	defer p.Unlock()
+	t := p.get(name, size)
+	t.size = size
+	t.done = size
+	t.complete = true
+}
+
+// Stats returns the number of complete files, the available and expected bytes of the files
+// seen so far, the download speed in bytes/s and the estimated time to complete them.
+func (p *Progress) Stats() (complete int, done, size int64, speed float64, eta time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+
+	var transferred int64
+	for _, t := range p.transfers {
+		if t.complete {
+			complete++
+		}
+		done += t.done
+		size += t.size
+		transferred += t.done - t.initial
+	}
+
+	if elapsed := time.Since(p.started).Seconds(); elapsed > 0 {
+		speed = float64(transferred) / elapsed
+	}
+	if speed > 0 && size > done {
+		eta = time.Duration(float64(size-done) / speed * float64(time.Second))
+	}
+	return
+}
+
+func (p *Progress) String() string {
+	complete, done, size, speed, eta := p.Stats()
+	s := fmt.Sprintf("%d/%d files, %.2f/%.2f MB, %.2f MiB/s",
+		complete, p.Files,
+		(float64(done)/1000)/1000, (float64(size)/1000)/1000,
+		(speed/1024)/1024)
+	if eta > 0 {
+		s += ", ETA " + eta.Round(time.Second).String()
+	}
+	return s
+}
@@ -0,0 +1,51 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package client_test
+
+import (
+	"time"
+
+	. "github.com/mudler/luet/pkg/installer/client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Progress", func() {
+	It("Aggregates concurrent downloads", func() {
+		p := NewProgress(3)
+		p.Update("a", 1000, 0)
+		p.Update("b", 3000, 1000) // resumed
+		time.Sleep(10 * time.Millisecond)
+		p.Update("a", 1000, 500)
+		p.Update("b", 3000, 2000)
+		p.Complete("c", 500)
+
+		complete, done, size, speed, eta := p.Stats()
+		Expect(complete).To(Equal(1))
+		Expect(done).To(Equal(int64(3000)))
+		Expect(size).To(Equal(int64(4500)))
+		Expect(speed).To(BeNumerically(">", 0))
+		Expect(eta).To(BeNumerically(">", 0))
+		Expect(p.String()).To(ContainSubstring("1/3 files"))
+
+		p.Complete("a", 1000)
+		p.Complete("b", 3000)
+		complete, done, size, _, eta = p.Stats()
+		Expect(complete).To(Equal(3))
+		Expect(done).To(Equal(size))
+		Expect(eta).To(Equal(time.Duration(0)))
+	})
+})
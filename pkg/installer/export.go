@@ -0,0 +1,175 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/docker/docker/pkg/archive"
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	ExportTar = "tar"
+	ExportOCI = "oci"
+)
+
+type ExportOptions struct {
+	// Exclude are the paths, relative to the rootfs, left out of the export
+	Exclude []string
+	// Reference is the name of the image in the index of the OCI layout
+	Reference string
+}
+
+// tarRootfs returns the uncompressed tar stream of the rootfs
+func tarRootfs(rootfs string, opts ExportOptions) (io.ReadCloser, error) {
+	var exclude []string
+	for _, e := range opts.Exclude {
+		exclude = append(exclude, strings.TrimPrefix(filepath.Clean("/"+e), "/"))
+	}
+	return archive.TarWithOptions(rootfs, &archive.TarOptions{Compression: archive.Uncompressed, ExcludePatterns: exclude})
+}
+
+// ExportTarball writes the rootfs as a tarball in dst, compressed with gzip if dst ends with .gz or .tgz
+func ExportTarball(rootfs, dst string, opts ExportOptions) error {
+	fs, err := tarRootfs(rootfs, opts)
+	if err != nil {
+		return errors.Wrap(err, "Failed creating tarball of "+rootfs)
+	}
+	defer fs.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return errors.Wrap(err, "Failed creating "+dst)
+	}
+	defer out.Close()
+
+	if !strings.HasSuffix(dst, ".gz") && !strings.HasSuffix(dst, ".tgz") {
+		if _, err := io.Copy(out, fs); err != nil {
+			return errors.Wrap(err, "Failed writing "+dst)
+		}
+		return out.Sync()
+	}
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, fs); err != nil {
+		return errors.Wrap(err, "Failed writing "+dst)
+	}
+	if err := gz.Close(); err != nil {
+		return errors.Wrap(err, "Failed writing "+dst)
+	}
+	return out.Sync()
+}
+
+// ExportOCILayout writes the rootfs as an image with a single layer in the OCI image layout dst
+func ExportOCILayout(rootfs, dst string, opts ExportOptions) error {
+	blobs := filepath.Join(dst, "blobs", string(digest.Canonical))
+	if err := os.MkdirAll(blobs, os.ModePerm); err != nil {
+		return errors.Wrap(err, "Failed creating "+blobs)
+	}
+
+	fs, err := tarRootfs(rootfs, opts)
+	if err != nil {
+		return errors.Wrap(err, "Failed creating tarball of "+rootfs)
+	}
+	defer fs.Close()
+
+	// The layer is compressed while computing the digests of the compressed and the uncompressed tarball
+	tmp, err := ioutil.TempFile(blobs, "layer")
+	if err != nil {
+		return errors.Wrap(err, "Failed creating layer")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	layerDigester := digest.Canonical.Digester()
+	diffDigester := digest.Canonical.Digester()
+	gz := gzip.NewWriter(io.MultiWriter(tmp, layerDigester.Hash()))
+	if _, err := io.Copy(io.MultiWriter(gz, diffDigester.Hash()), fs); err != nil {
+		return errors.Wrap(err, "Failed writing layer")
+	}
+	if err := gz.Close(); err != nil {
+		return errors.Wrap(err, "Failed writing layer")
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		return errors.Wrap(err, "Failed writing layer")
+	}
+	layer := v1.Descriptor{MediaType: v1.MediaTypeImageLayerGzip, Digest: layerDigester.Digest(), Size: info.Size()}
+	if err := os.Rename(tmp.Name(), filepath.Join(blobs, layer.Digest.Encoded())); err != nil {
+		return errors.Wrap(err, "Failed writing layer")
+	}
+
+	config, err := writeBlob(blobs, v1.MediaTypeImageConfig, v1.Image{
+		Architecture: runtime.GOARCH,
+		OS:           "linux",
+		RootFS:       v1.RootFS{Type: "layers", DiffIDs: []digest.Digest{diffDigester.Digest()}},
+	})
+	if err != nil {
+		return err
+	}
+
+	manifest, err := writeBlob(blobs, v1.MediaTypeImageManifest, v1.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Config:    config,
+		Layers:    []v1.Descriptor{layer},
+	})
+	if err != nil {
+		return err
+	}
+	if opts.Reference != "" {
+		manifest.Annotations = map[string]string{v1.AnnotationRefName: opts.Reference}
+	}
+
+	if err := writeJSON(filepath.Join(dst, "index.json"), v1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: []v1.Descriptor{manifest},
+	}); err != nil {
+		return err
+	}
+	return writeJSON(filepath.Join(dst, v1.ImageLayoutFile), v1.ImageLayout{Version: v1.ImageLayoutVersion})
+}
+
+// writeBlob stores the JSON encoding of v in the blobs directory, returning its descriptor
+func writeBlob(blobs, mediaType string, v interface{}) (v1.Descriptor, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v1.Descriptor{}, errors.Wrap(err, "Failed encoding "+mediaType)
+	}
+	d := v1.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(data), Size: int64(len(data))}
+	if err := ioutil.WriteFile(filepath.Join(blobs, d.Digest.Encoded()), data, 0644); err != nil {
+		return v1.Descriptor{}, errors.Wrap(err, "Failed writing "+mediaType)
+	}
+	return d, nil
+}
+
+func writeJSON(dst string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "Failed encoding "+dst)
+	}
+	return errors.Wrap(ioutil.WriteFile(dst, data, 0644), "Failed writing "+dst)
+}
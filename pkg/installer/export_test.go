@@ -0,0 +1,135 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/mudler/luet/pkg/installer"
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// tarEntries returns the names of the regular files in the tar stream
+func tarEntries(r io.Reader) []string {
+	var files []string
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		Expect(err).ToNot(HaveOccurred())
+		if h.Typeflag == tar.TypeReg {
+			files = append(files, h.Name)
+		}
+	}
+}
+
+// readBlob decodes the JSON blob of the descriptor in the OCI layout
+func readBlob(layout string, d v1.Descriptor, v interface{}) {
+	data, err := ioutil.ReadFile(filepath.Join(layout, "blobs", "sha256", d.Digest.Encoded()))
+	Expect(err).ToNot(HaveOccurred())
+	Expect(digest.FromBytes(data)).To(Equal(d.Digest))
+	Expect(json.Unmarshal(data, v)).ToNot(HaveOccurred())
+}
+
+var _ = Describe("Bootstrap", func() {
+	var target, out string
+
+	BeforeEach(func() {
+		target = tempDir("bootstrap")
+		out = tempDir("bootstrap")
+
+		repo := newTestRepository("bootstrap")
+		repo.Add(testPackage("base", "1.0"), map[string]string{"etc/os-release": "base"})
+
+		system, err := BootstrapSystem(target, "/var/cache/luet")
+		Expect(err).ToNot(HaveOccurred())
+		inst := newTestInstaller(LuetInstallerOptions{Concurrency: 1}, repo.Generate())
+		Expect(inst.Install([]pkg.Package{testPackage("base", "1.0")}, system)).ToNot(HaveOccurred())
+		Expect(len(system.Database.World())).To(Equal(1))
+	})
+
+	It("Initializes the database inside the target", func() {
+		Expect(helpers.Exists(filepath.Join(target, "var", "cache", "luet", "luet.db"))).To(BeTrue())
+		_, err := BootstrapSystem(target, "/var/cache/luet")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Exports the rootfs as a tarball", func() {
+		dst := filepath.Join(out, "rootfs.tar.gz")
+		Expect(ExportTarball(target, dst, ExportOptions{})).ToNot(HaveOccurred())
+		f, err := os.Open(dst)
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tarEntries(gz)).To(ConsistOf("etc/os-release", "var/cache/luet/luet.db"))
+
+		dst = filepath.Join(out, "rootfs.tar")
+		Expect(ExportTarball(target, dst, ExportOptions{Exclude: []string{"/var/cache/luet"}})).ToNot(HaveOccurred())
+		f, err = os.Open(dst)
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+		Expect(tarEntries(f)).To(ConsistOf("etc/os-release"))
+	})
+
+	It("Exports the rootfs as an OCI image layout", func() {
+		Expect(ExportOCILayout(target, out, ExportOptions{Reference: "latest", Exclude: []string{"/var/cache/luet"}})).ToNot(HaveOccurred())
+		Expect(helpers.Read(filepath.Join(out, "oci-layout"))).To(Equal(`{"imageLayoutVersion":"1.0.0"}`))
+
+		index := v1.Index{}
+		data, err := ioutil.ReadFile(filepath.Join(out, "index.json"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(json.Unmarshal(data, &index)).ToNot(HaveOccurred())
+		Expect(len(index.Manifests)).To(Equal(1))
+		Expect(index.Manifests[0].Annotations[v1.AnnotationRefName]).To(Equal("latest"))
+
+		manifest := v1.Manifest{}
+		readBlob(out, index.Manifests[0], &manifest)
+		Expect(len(manifest.Layers)).To(Equal(1))
+		image := v1.Image{}
+		readBlob(out, manifest.Config, &image)
+
+		f, err := os.Open(filepath.Join(out, "blobs", "sha256", manifest.Layers[0].Digest.Encoded()))
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+		layer, err := digest.FromReader(f)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(layer).To(Equal(manifest.Layers[0].Digest))
+
+		_, err = f.Seek(0, io.SeekStart)
+		Expect(err).ToNot(HaveOccurred())
+		gz, err := gzip.NewReader(f)
+		Expect(err).ToNot(HaveOccurred())
+		diffID := digest.Canonical.Digester()
+		Expect(tarEntries(io.TeeReader(gz, diffID.Hash()))).To(ConsistOf("etc/os-release"))
+		_, err = io.Copy(diffID.Hash(), gz)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(image.RootFS.DiffIDs).To(Equal([]digest.Digest{diffID.Digest()}))
+	})
+})
@@ -0,0 +1,252 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/mudler/luet/pkg/compiler"
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/mudler/luet/pkg/logger"
+	pkg "github.com/mudler/luet/pkg/package"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// FILES_INDEX is the gzipped yaml which maps the fingerprint of each package of a repository to its files
+const FILES_INDEX = "files.yaml.gz"
+
+// FileMatch is a package shipping files matching a search
+type FileMatch struct {
+	Repo    Repository
+	Package pkg.Package
+	Files   []string
+}
+
+// buildFilesIndex returns the files of each artifact of index, which are stored in dir.
+// Artifacts whose tarball is not in dir are left out of the index.
+func buildFilesIndex(index compiler.ArtifactIndex, dir string) (map[string][]string, error) {
+	files := map[string][]string{}
+	for _, a := range index {
+		if a.GetCompileSpec() == nil || a.GetCompileSpec().GetPackage() == nil {
+			continue
+		}
+		if !helpers.Exists(filepath.Join(dir, path.Base(a.GetPath()))) {
+			Warning("Artifact", path.Base(a.GetPath()), "not found, its files won't be searchable")
+			continue
+		}
+		list, err := artifactFiles(a, dir)
+		if err != nil {
+			return nil, err
+		}
+		files[a.GetCompileSpec().GetPackage().GetFingerPrint()] = list
+	}
+	return files, nil
+}
+
+// artifactFiles returns the files of the artifact a, which is stored in dir
+func artifactFiles(a compiler.Artifact, dir string) ([]string, error) {
+	orig, ok := a.(*compiler.PackageArtifact)
+	if !ok {
+		return nil, errors.New("Unsupported artifact " + a.GetPath())
+	}
+	local := *orig
+	local.Path = filepath.Join(dir, path.Base(a.GetPath()))
+	list, err := local.FileList()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed listing the files of "+path.Base(a.GetPath()))
+	}
+	for i := range list {
+		list[i] = path.Clean("/" + list[i])
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// writeFilesIndex writes files in dst and returns its sha256
+func writeFilesIndex(files map[string][]string, dst string) (string, error) {
+	data, err := yaml.Marshal(files)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(dst, buf.Bytes(), 0644); err != nil {
+		return "", err
+	}
+	return fileSha256(dst)
+}
+
+func readFilesIndex(src string) (map[string][]string, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed reading the files index")
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed reading the files index")
+	}
+	files := map[string][]string{}
+	if err := yaml.Unmarshal(data, &files); err != nil {
+		return nil, errors.Wrap(err, "Failed reading the files index")
+	}
+	return files, nil
+}
+
+// loadFilesIndex loads the files index of the repository stored in dir, if any, so it's kept when writing it again
+func (r *LuetSystemRepository) loadFilesIndex(dir string) error {
+	if r.Files != nil || r.FilesIndex == "" || !helpers.Exists(filepath.Join(dir, r.FilesIndex)) {
+		return nil
+	}
+	files, err := readFilesIndex(filepath.Join(dir, r.FilesIndex))
+	if err != nil {
+		return err
+	}
+	r.Files = files
+	return nil
+}
+
+// GetFiles returns the files shipped by each package of the repository, keyed by the package fingerprint.
+// The files index is downloaded on the first call.
+func (r *LuetSystemRepository) GetFiles() (map[string][]string, error) {
+	if r.Files != nil {
+		return r.Files, nil
+	}
+	if r.FilesIndex == "" {
+		return nil, errors.New("Repository " + r.GetName() + " has no files index")
+	}
+
+	c := r.Client()
+	if c == nil {
+		return nil, errors.New("No client could be generated from repository " + r.GetName())
+	}
+	file, err := c.DownloadFile(r.FilesIndex)
+	if err != nil {
+		return nil, errors.Wrap(err, "While downloading "+r.FilesIndex)
+	}
+	defer os.Remove(file)
+
+	if sum, err := fileSha256(file); err != nil || sum != r.FilesChecksum {
+		return nil, errors.New("Files index integrity check failure")
+	}
+	files, err := readFilesIndex(file)
+	if err != nil {
+		return nil, err
+	}
+	r.Files = files
+	return files, nil
+}
+
+// fileMatcher matches file paths against s: absolute paths are matched exactly, anything else as a regex
+func fileMatcher(s string) (func(string) bool, error) {
+	if path.IsAbs(s) && regexp.QuoteMeta(s) == s {
+		s = path.Clean(s)
+		return func(f string) bool { return f == s }, nil
+	}
+	term, err := regexp.Compile(s)
+	if err != nil {
+		return nil, err
+	}
+	return term.MatchString, nil
+}
+
+// SearchFiles returns the packages of the repositories shipping files matching s,
+// which is either an absolute path or a regex. Repositories without a files index are skipped.
+func (re Repositories) SearchFiles(s string) ([]FileMatch, error) {
+	match, err := fileMatcher(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid search term "+s)
+	}
+
+	sort.Sort(re)
+	var matches []FileMatch
+	for _, r := range re {
+		files, err := r.GetFiles()
+		if err != nil {
+			Warning("Skipping repository", r.GetName(), ":", err.Error())
+			continue
+		}
+
+		var repoMatches []FileMatch
+		for _, p := range r.GetTree().GetDatabase().World() {
+			var found []string
+			for _, f := range files[p.GetFingerPrint()] {
+				if match(f) {
+					found = append(found, f)
+				}
+			}
+			if len(found) > 0 {
+				repoMatches = append(repoMatches, FileMatch{Repo: r, Package: p, Files: found})
+			}
+		}
+		sort.SliceStable(repoMatches, func(i, j int) bool {
+			return repoMatches[i].Package.GetFingerPrint() < repoMatches[j].Package.GetFingerPrint()
+		})
+		matches = append(matches, repoMatches...)
+	}
+	return matches, nil
+}
+
+// SearchFiles returns the installed packages owning files matching s,
+// which is either an absolute path or a regex
+func (s *System) SearchFiles(term string) ([]FileMatch, error) {
+	match, err := fileMatcher(term)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid search term "+term)
+	}
+
+	var matches []FileMatch
+	for _, p := range s.Database.World() {
+		files, err := s.Database.GetPackageFiles(p)
+		if err != nil {
+			continue
+		}
+		var found []string
+		for _, f := range files {
+			if f = path.Clean("/" + f); match(f) {
+				found = append(found, f)
+			}
+		}
+		if len(found) > 0 {
+			sort.Strings(found)
+			matches = append(matches, FileMatch{Package: p, Files: found})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Package.GetFingerPrint() < matches[j].Package.GetFingerPrint()
+	})
+	return matches, nil
+}
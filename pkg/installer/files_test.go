@@ -0,0 +1,102 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/mudler/luet/pkg/installer"
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Files index", func() {
+	var dir string
+	var repo *testRepository
+	var repos Repositories
+
+	BeforeEach(func() {
+		repo = newTestRepository("files")
+		dir = repo.Dir
+		repo.Add(testPackage("foo", "1.0"), map[string]string{"usr/bin/foo": "foo", "usr/share/foo/README": "foo"})
+		repo.Add(testPackage("bar", "1.0"), map[string]string{"usr/bin/bar": "bar", "usr/bin/foobar": "bar"})
+
+		generated := repo.Generate()
+		Expect(dir + "/" + FILES_INDEX).To(BeAnExistingFile())
+
+		synced, err := generated.Sync(false)
+		Expect(err).ToNot(HaveOccurred())
+		repos = Repositories{synced}
+	})
+
+	It("Finds the package shipping a file", func() {
+		matches, err := repos.SearchFiles("/usr/bin/foo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(matches)).To(Equal(1))
+		Expect(matches[0].Package.GetName()).To(Equal("foo"))
+		Expect(matches[0].Files).To(Equal([]string{"/usr/bin/foo"}))
+		Expect(matches[0].Repo.GetName()).To(Equal("files"))
+	})
+
+	It("Generates repositories with missing artifacts from their metadata", func() {
+		Expect(os.Remove(dir + "/bar-test-1.0.package.tar")).ToNot(HaveOccurred())
+
+		synced, err := repo.Generate().Sync(false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(synced.GetIndex())).To(Equal(2))
+		matches, err := Repositories{synced}.SearchFiles("usr/bin/")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(matches)).To(Equal(1))
+		Expect(matches[0].Package.GetName()).To(Equal("foo"))
+	})
+
+	It("Searches files with regexes", func() {
+		matches, err := repos.SearchFiles("bin/foo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(matches)).To(Equal(2))
+		Expect(matches[0].Package.GetName()).To(Equal("bar"))
+		Expect(matches[0].Files).To(Equal([]string{"/usr/bin/foobar"}))
+
+		matches, err = repos.SearchFiles("/usr/bin/missing")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(matches).To(BeEmpty())
+
+		_, err = repos.SearchFiles("[")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Finds the installed package owning a file", func() {
+		db := pkg.NewInMemoryDatabase(false)
+		system := &System{Database: db, Target: "/"}
+		_, err := db.CreatePackage(testPackage("foo", "1.0"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(db.SetPackageFiles(&pkg.PackageFile{PackageFingerprint: "foo-test-1.0", Files: []string{"usr/bin/foo", "usr/share/foo/README"}})).ToNot(HaveOccurred())
+
+		matches, err := system.SearchFiles("/usr/bin/foo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(matches)).To(Equal(1))
+		Expect(matches[0].Package.GetName()).To(Equal("foo"))
+		Expect(matches[0].Files).To(Equal([]string{"/usr/bin/foo"}))
+	})
+
+	It("Detects corrupted indexes", func() {
+		Expect(ioutil.WriteFile(dir+"/"+FILES_INDEX, []byte("corrupted"), os.ModePerm)).ToNot(HaveOccurred())
+		_, err := repos[0].GetFiles()
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,29 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+//go:build !linux
+// +build !linux
+
+package installer
+
+import (
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+func chrootCommand(c, target string) (*exec.Cmd, error) {
+	return nil, errors.New("Finalizers can run inside " + target + " only on Linux, set host: true to run them on the host")
+}
@@ -0,0 +1,95 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/mudler/luet/pkg/installer"
+	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/mudler/luet/pkg/tree"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// copyShell copies /bin/sh and the libraries it's linked to into target
+func copyShell(target string) {
+	out, err := exec.Command("ldd", "/bin/sh").CombinedOutput()
+	if err != nil {
+		Skip("ldd is not available: " + err.Error())
+	}
+	files := []string{"/bin/sh"}
+	for _, m := range regexp.MustCompile(`(/\S+)`).FindAllString(string(out), -1) {
+		files = append(files, m)
+	}
+	for _, f := range files {
+		// Read through the symlinks, they could point outside target
+		data, err := ioutil.ReadFile(f)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.MkdirAll(filepath.Join(target, filepath.Dir(f)), os.ModePerm)).ToNot(HaveOccurred())
+		Expect(ioutil.WriteFile(filepath.Join(target, f), data, 0755)).ToNot(HaveOccurred())
+	}
+}
+
+var _ = Describe("Finalizers", func() {
+	var fakeroot, host string
+	var repo *testRepository
+	var inst Installer
+	var system *System
+
+	service := testPackage("service", "1.0")
+	finalizer := func(definition string) {
+		repo.Add(service, map[string]string{"etc/service.conf": "1.0"})
+		Expect(ioutil.WriteFile(filepath.Join(tree.DefinitionDir(repo.Tree, service), "finalize.yaml"), []byte(definition), os.ModePerm)).ToNot(HaveOccurred())
+		inst = newTestInstaller(LuetInstallerOptions{Concurrency: 1}, repo.Generate())
+	}
+
+	BeforeEach(func() {
+		repo = newTestRepository("finalizers")
+		host = tempDir("finalizers")
+		system = newTestSystem()
+		fakeroot = system.Target
+	})
+
+	It("Runs the finalizers on the host only when asked", func() {
+		finalizer("host: true\ninstall:\n- touch " + host + "/marker $LUET_ROOTFS/marker\n")
+		Expect(inst.Install([]pkg.Package{service}, system)).ToNot(HaveOccurred())
+		Expect(helpers.Exists(filepath.Join(host, "marker"))).To(BeTrue())
+		Expect(helpers.Exists(filepath.Join(fakeroot, "marker"))).To(BeTrue())
+	})
+
+	It("Doesn't run the finalizers on the host for other targets", func() {
+		finalizer("install:\n- touch " + host + "/marker\n")
+		// The target has no shell to run the finalizer with
+		Expect(inst.Install([]pkg.Package{service}, system)).To(HaveOccurred())
+		Expect(helpers.Exists(filepath.Join(host, "marker"))).To(BeFalse())
+	})
+
+	It("Runs the finalizers chrooted into the target", func() {
+		if os.Geteuid() != 0 {
+			Skip("chroot requires root")
+		}
+		copyShell(fakeroot)
+		finalizer("install:\n- test -e /etc/service.conf && echo $LUET_ROOTFS > /marker\n")
+		Expect(inst.Install([]pkg.Package{service}, system)).ToNot(HaveOccurred())
+		Expect(helpers.Read(filepath.Join(fakeroot, "marker"))).To(Equal(fakeroot + "\n"))
+	})
+})
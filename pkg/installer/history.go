@@ -0,0 +1,212 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mudler/luet/pkg/config"
+	. "github.com/mudler/luet/pkg/logger"
+	pkg "github.com/mudler/luet/pkg/package"
+
+	"github.com/pkg/errors"
+)
+
+// transaction is a transaction in progress
+type transaction struct {
+	*pkg.Transaction
+
+	// started is set once the system is going to be changed, with the files touched by the transaction
+	// and the hooks executed for it
+	started bool
+	files   []string
+	hooks   []*LuetHook
+
+	// touched are the files actually installed or removed, collected for the triggers
+	mu      sync.Mutex
+	touched map[string]bool
+}
+
+// touch collects the files installed or removed by the transaction
+func (t *transaction) touch(files ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.touched == nil {
+		t.touched = map[string]bool{}
+	}
+	for _, f := range files {
+		t.touched[f] = true
+	}
+}
+
+// touchedFiles returns the sorted files installed or removed by the transaction
+func (t *transaction) touchedFiles() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var files []string
+	for f := range t.touched {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// transact runs op as a transaction of the given operation, then appends to the history of the system
+// the packages it changed and its outcome. The triggers matching the files touched by op are executed once,
+// then the post hooks if op started changing the system. As for post hooks, failing triggers are reported
+// as warnings: the packages are already changed, so the transaction stays undoable.
+func (l *LuetInstaller) transact(s *System, operation string, op func(t *transaction) error) error {
+	t := &transaction{Transaction: &pkg.Transaction{Timestamp: time.Now(), Command: strings.Join(os.Args, " "), Operation: operation}}
+	err := op(t)
+	if len(t.touched) > 0 {
+		if e := l.runTriggers(t, s); e != nil {
+			Warning(e.Error())
+		}
+	}
+	if err == nil && t.Empty() {
+		return nil
+	}
+	if err != nil {
+		t.Error = err.Error()
+	}
+	if t.started {
+		if e := l.runHooks(HookPost, t, s); e != nil {
+			Warning(e.Error())
+		}
+	}
+	if e := s.Database.AddTransaction(t.Transaction); e != nil {
+		Warning("Failed recording the transaction in the history:", e.Error())
+	}
+	return err
+}
+
+// begin loads the hooks and executes the pre ones right before changing the system, then marks the transaction
+// as started. files returns the files touched by the transaction, it's called only if there are hooks to execute.
+func (l *LuetInstaller) begin(t *transaction, s *System, files func() ([]string, error)) error {
+	var err error
+	if t.hooks, err = LoadHooks(l.Options.HooksDir); err != nil {
+		return err
+	}
+	for _, h := range t.hooks {
+		if h.RunsFor(t.Operation) {
+			if t.files, err = files(); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	if err := l.runHooks(HookPre, t, s); err != nil {
+		return err
+	}
+	t.started = true
+	return nil
+}
+
+// Undo applies the inverse of the transaction with the given id: the packages it added are removed,
+// the ones it removed are installed again and the ones it replaced are swapped back.
+// The old versions which are not available anymore in the repositories are taken from the package cache.
+func (l *LuetInstaller) Undo(id int, s *System) error {
+	undo, err := s.Database.GetTransaction(id)
+	if err != nil {
+		return err
+	}
+	if !undo.Succeeded() {
+		return errors.New("Transaction " + strconv.Itoa(id) + " failed, it can't be undone")
+	}
+
+	var old, new []pkg.Package
+	for _, p := range undo.Added {
+		old = append(old, p)
+	}
+	for _, r := range undo.Replaced {
+		// Packages installed again
+		if r.From.Matches(r.To) {
+			continue
+		}
+		old = append(old, r.To)
+		new = append(new, r.From)
+	}
+	for _, p := range undo.Removed {
+		new = append(new, p)
+	}
+
+	for i, p := range old {
+		installed, err := s.Database.FindPackage(p)
+		if err != nil {
+			return errors.New("Package " + p.HumanReadableString() + " is not installed anymore")
+		}
+		old[i] = installed
+	}
+	var missing []pkg.Package
+	for _, p := range new {
+		if _, err := s.Database.FindPackage(p); err == nil {
+			Warning("Package", p.HumanReadableString(), "is already installed")
+			continue
+		}
+		missing = append(missing, p)
+	}
+	if len(old) == 0 && len(missing) == 0 {
+		return errors.New("Nothing to undo")
+	}
+
+	return l.transact(s, "undo", func(t *transaction) error {
+		syncedRepos, err := l.SyncRepositories(true)
+		if err != nil {
+			return err
+		}
+		allRepos := pkg.NewInMemoryDatabase(false)
+		syncedRepos.SyncDatabase(allRepos)
+
+		var cached []string
+		for _, p := range missing {
+			if _, err := allRepos.FindPackage(p); err == nil {
+				continue
+			}
+			matches, _ := filepath.Glob(filepath.Join(config.LuetCfg.GetSystem().GetSystemPkgsCacheDirPath(), p.GetFingerPrint()+".package.tar*"))
+			if len(matches) == 0 {
+				return errors.New("Package " + p.HumanReadableString() + " is not available in the repositories or in the package cache")
+			}
+			cached = append(cached, matches[0])
+		}
+		if len(cached) > 0 {
+			tmpdir, err := ioutil.TempDir(os.TempDir(), "luet-undo")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(tmpdir)
+
+			cache, _, err := ArtifactsRepository("package-cache", cached, tmpdir)
+			if err != nil {
+				return errors.Wrap(err, "Failed reading the package cache")
+			}
+			synced, err := cache.Sync(false)
+			if err != nil {
+				return errors.Wrap(err, "Failed syncing the package cache")
+			}
+			syncedRepos = append(Repositories{synced}, syncedRepos...)
+			syncedRepos.SyncDatabase(allRepos)
+		}
+
+		return l.swap(syncedRepos, allRepos, old, missing, s, t)
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"os"
+	"path/filepath"
+
+	config "github.com/mudler/luet/pkg/config"
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/mudler/luet/pkg/installer"
+	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/mudler/luet/pkg/tree"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("History", func() {
+	var fakeroot string
+	var repo *testRepository
+	var inst Installer
+	var system *System
+
+	BeforeEach(func() {
+		repo = newTestRepository("history")
+		for _, v := range []string{"1.0", "1.1"} {
+			repo.Add(testPackage("journal", v), map[string]string{"journal": v})
+		}
+
+		inst = newTestInstaller(LuetInstallerOptions{Concurrency: 1}, repo.Generate())
+		system = newTestSystem()
+		fakeroot = system.Target
+		Expect(inst.Install([]pkg.Package{testPackage("journal", "1.0")}, system)).ToNot(HaveOccurred())
+	})
+
+	It("Records the transactions", func() {
+		Expect(inst.Upgrade(system)).ToNot(HaveOccurred())
+		Expect(inst.Uninstall(testPackage("journal", "1.1"), system)).ToNot(HaveOccurred())
+		Expect(inst.Install([]pkg.Package{testPackage("missing", "1.0")}, system)).To(HaveOccurred())
+
+		history, err := system.Database.GetTransactions()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(history)).To(Equal(4))
+
+		Expect(history[0].Added).To(Equal([]*pkg.DefaultPackage{{Name: "journal", Category: "test", Version: "1.0"}}))
+		Expect(history[0].Succeeded()).To(BeTrue())
+		Expect(history[0].Command).ToNot(BeEmpty())
+		Expect(history[1].Replaced).To(Equal([]*pkg.PackageReplacement{{
+			From: testPackage("journal", "1.0"),
+			To:   testPackage("journal", "1.1"),
+		}}))
+		Expect(history[2].Removed).To(Equal([]*pkg.DefaultPackage{{Name: "journal", Category: "test", Version: "1.1"}}))
+		Expect(history[3].Succeeded()).To(BeFalse())
+	})
+
+	It("Undoes transactions", func() {
+		Expect(inst.Upgrade(system)).ToNot(HaveOccurred())
+		Expect(helpers.Read(filepath.Join(fakeroot, "journal"))).To(Equal("1.1"))
+
+		Expect(inst.Undo(2, system)).ToNot(HaveOccurred())
+		Expect(helpers.Read(filepath.Join(fakeroot, "journal"))).To(Equal("1.0"))
+		world := system.Database.World()
+		Expect(len(world)).To(Equal(1))
+		Expect(world[0].GetVersion()).To(Equal("1.0"))
+
+		Expect(inst.Undo(1, system)).ToNot(HaveOccurred())
+		Expect(helpers.Exists(filepath.Join(fakeroot, "journal"))).To(BeFalse())
+		Expect(system.Database.World()).To(BeEmpty())
+
+		// Undoing the removal installs the package again
+		Expect(inst.Undo(4, system)).ToNot(HaveOccurred())
+		Expect(helpers.Read(filepath.Join(fakeroot, "journal"))).To(Equal("1.0"))
+
+		Expect(inst.Undo(1, system)).ToNot(HaveOccurred())
+		Expect(inst.Undo(1, system)).To(HaveOccurred())
+	})
+
+	It("Takes the old versions from the package cache", func() {
+		cache := tempDir("cache")
+		defer func(p string) { config.LuetCfg.GetSystem().PkgsCachePath = p }(config.LuetCfg.GetSystem().PkgsCachePath)
+		config.LuetCfg.GetSystem().PkgsCachePath = cache
+
+		Expect(inst.Reinstall([]pkg.Package{&pkg.DefaultPackage{Name: "journal", Category: "test"}}, system)).ToNot(HaveOccurred())
+		Expect(filepath.Join(cache, "journal-test-1.0.metadata.yaml")).To(BeAnExistingFile())
+		Expect(inst.Upgrade(system)).ToNot(HaveOccurred())
+
+		// Drop the old version from the repository
+		Expect(os.RemoveAll(tree.DefinitionDir(repo.Tree, testPackage("journal", "1.0")))).ToNot(HaveOccurred())
+		for _, f := range []string{"journal-test-1.0.package.tar", "journal-test-1.0.metadata.yaml"} {
+			Expect(os.Remove(filepath.Join(repo.Dir, f))).ToNot(HaveOccurred())
+		}
+		repo.Generate()
+
+		Expect(inst.Undo(3, system)).ToNot(HaveOccurred())
+		Expect(helpers.Read(filepath.Join(fakeroot, "journal"))).To(Equal("1.0"))
+	})
+})
@@ -0,0 +1,191 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/mudler/luet/pkg/logger"
+	pkg "github.com/mudler/luet/pkg/package"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+const (
+	HookPre  = "pre"
+	HookPost = "post"
+)
+
+// LuetHook is a hook defined by the administrator, which is executed before or after the transactions.
+// A hook without packages and files runs for every transaction of its operations, otherwise it runs
+// only if the transaction changes a package matching one of the globs of packages (as category/name),
+// or touches a file matching one of the globs of files (or one of their directories).
+type LuetHook struct {
+	Name string `json:"-"`
+	// When is the phase of the hook, pre or post
+	When string `json:"when"`
+	// Operations are the transactions operations the hook runs for, all if empty
+	Operations []string `json:"operations,omitempty"`
+	Packages   []string `json:"packages,omitempty"`
+	Files      []string `json:"files,omitempty"`
+	Commands   []string `json:"commands"`
+}
+
+// HookPlan is the plan of the transaction given as JSON to the hooks on their standard input
+type HookPlan struct {
+	*pkg.Transaction
+	Phase string   `json:"phase"`
+	Files []string `json:"files"`
+}
+
+// LoadHooks reads the hooks defined in the yaml files of dir, sorted by file name
+func LoadHooks(dir string) ([]*LuetHook, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "Failed reading hooks directory "+dir)
+	}
+
+	var hooks []*LuetHook
+	for _, e := range entries {
+		if e.IsDir() || !(strings.HasSuffix(e.Name(), ".yaml") || strings.HasSuffix(e.Name(), ".yml")) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed reading hook "+e.Name())
+		}
+		h := &LuetHook{}
+		if err := yaml.Unmarshal(data, h); err != nil {
+			return nil, errors.Wrap(err, "Failed parsing hook "+e.Name())
+		}
+		if h.When != HookPre && h.When != HookPost {
+			return nil, errors.New("Invalid phase '" + h.When + "' of hook " + e.Name() + ": must be pre or post")
+		}
+		h.Name = e.Name()
+		hooks = append(hooks, h)
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].Name < hooks[j].Name })
+	return hooks, nil
+}
+
+// RunsFor returns true if the hook runs for the transactions of the operation
+func (h *LuetHook) RunsFor(operation string) bool {
+	if len(h.Operations) == 0 {
+		return true
+	}
+	for _, o := range h.Operations {
+		if o == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns true if the hook has to run for the transaction touching files
+func (h *LuetHook) Matches(t *pkg.Transaction, files []string) bool {
+	if !h.RunsFor(t.Operation) {
+		return false
+	}
+	if len(h.Packages) == 0 && len(h.Files) == 0 {
+		return true
+	}
+
+	packages := append([]*pkg.DefaultPackage{}, t.Added...)
+	packages = append(packages, t.Removed...)
+	for _, r := range t.Replaced {
+		packages = append(packages, r.From, r.To)
+	}
+	for _, glob := range h.Packages {
+		for _, p := range packages {
+			if ok, _ := path.Match(glob, p.GetCategory()+"/"+p.GetName()); ok {
+				return true
+			}
+		}
+	}
+
+	for _, glob := range h.Files {
+		for _, f := range files {
+			if matchFile(glob, f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchFile returns true if the file, or one of its directories, matches the glob
+func matchFile(glob, f string) bool {
+	for f = path.Clean("/" + f); f != "/"; f = path.Dir(f) {
+		if ok, _ := path.Match(glob, f); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Run executes the commands of the hook, giving them the plan on the standard input.
+// target is the root of the system changed by the transaction.
+func (h *LuetHook) Run(plan []byte, target string) error {
+	for _, c := range h.Commands {
+		Debug("hook:", h.Name, "sh", "-c", c)
+		cmd := exec.Command("sh", "-c", c)
+		cmd.Stdin = bytes.NewReader(plan)
+		cmd.Env = append(os.Environ(), "LUET_HOOK="+h.Name, "LUET_HOOK_PHASE="+h.When, "LUET_ROOTFS="+target)
+		stdoutStderr, err := cmd.CombinedOutput()
+		if err != nil {
+			return errors.Wrap(err, "Failed running command: "+string(stdoutStderr))
+		}
+		Info(string(stdoutStderr))
+	}
+	return nil
+}
+
+// runHooks executes the hooks of the phase matching the transaction, among the ones loaded when it began.
+// A failing pre hook aborts the transaction, before the system is changed.
+func (l *LuetInstaller) runHooks(phase string, t *transaction, s *System) error {
+	plan, err := json.Marshal(&HookPlan{Transaction: t.Transaction, Phase: phase, Files: t.files})
+	if err != nil {
+		return errors.Wrap(err, "Failed encoding the transaction plan")
+	}
+	for _, h := range t.hooks {
+		if h.When != phase || !h.Matches(t.Transaction, t.files) {
+			continue
+		}
+		Info("Executing", phase, "hook", h.Name)
+		if err := h.Run(plan, s.Target); err != nil {
+			if phase == HookPre {
+				return errors.Wrap(err, "Transaction aborted by hook "+h.Name)
+			}
+			return errors.Wrap(err, "Failed executing hook "+h.Name)
+		}
+	}
+	return nil
+}
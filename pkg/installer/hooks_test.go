@@ -0,0 +1,120 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/mudler/luet/pkg/installer"
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Hooks", func() {
+	var hooksDir, fakeroot string
+	var inst Installer
+	var system *System
+
+	hook := func(name, definition string) {
+		Expect(ioutil.WriteFile(filepath.Join(hooksDir, name), []byte(definition), os.ModePerm)).ToNot(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		hooksDir = tempDir("hooks")
+
+		repo := newTestRepository("hooks")
+		repo.Add(testPackage("daemon", "1.0"), map[string]string{"etc/daemon.conf": "1.0"})
+
+		inst = newTestInstaller(LuetInstallerOptions{Concurrency: 1, HooksDir: hooksDir}, repo.Generate())
+		system = newTestSystem()
+		fakeroot = system.Target
+	})
+
+	It("Loads and matches hooks", func() {
+		hook("10-packages.yaml", "when: pre\noperations: [install]\npackages: [\"test/*\"]\ncommands: [\"true\"]\n")
+		hook("20-files.yml", "when: post\nfiles: [\"/etc\"]\ncommands: [\"true\"]\n")
+		hook("README", "not a hook")
+
+		hooks, err := LoadHooks(hooksDir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(hooks)).To(Equal(2))
+		Expect(hooks[0].Name).To(Equal("10-packages.yaml"))
+
+		t := &pkg.Transaction{Operation: "install"}
+		t.Add(testPackage("daemon", "1.0"))
+		Expect(hooks[0].Matches(t, nil)).To(BeTrue())
+		Expect(hooks[1].Matches(t, []string{"etc/daemon.conf"})).To(BeTrue())
+		Expect(hooks[1].Matches(t, []string{"usr/bin/daemon"})).To(BeFalse())
+
+		t.Operation = "uninstall"
+		Expect(hooks[0].Matches(t, nil)).To(BeFalse())
+
+		hook("30-invalid.yaml", "when: sometimes\ncommands: [\"true\"]\n")
+		_, err = LoadHooks(hooksDir)
+		Expect(err).To(HaveOccurred())
+
+		hooks, err = LoadHooks(filepath.Join(hooksDir, "missing"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hooks).To(BeEmpty())
+	})
+
+	It("Gives the transaction plan to the hooks", func() {
+		plan := filepath.Join(hooksDir, "plan.json")
+		hook("pre.yaml", "when: pre\nfiles: [\"/etc/*.conf\"]\ncommands: [\"cat > "+plan+"\", \"test ! -e $LUET_ROOTFS/etc/daemon.conf\"]\n")
+		hook("post.yaml", "when: post\ncommands: [\"test -e $LUET_ROOTFS/etc/daemon.conf && touch $LUET_ROOTFS/post-$LUET_HOOK_PHASE\"]\n")
+
+		Expect(inst.Install([]pkg.Package{testPackage("daemon", "1.0")}, system)).ToNot(HaveOccurred())
+		Expect(helpers.Exists(filepath.Join(fakeroot, "post-post"))).To(BeTrue())
+
+		data, err := ioutil.ReadFile(plan)
+		Expect(err).ToNot(HaveOccurred())
+		p := &HookPlan{}
+		Expect(json.Unmarshal(data, p)).ToNot(HaveOccurred())
+		Expect(p.Phase).To(Equal(HookPre))
+		Expect(p.Operation).To(Equal("install"))
+		Expect(p.Added).To(Equal([]*pkg.DefaultPackage{{Name: "daemon", Category: "test", Version: "1.0"}}))
+		Expect(p.Files).To(ContainElement("etc/daemon.conf"))
+	})
+
+	It("Loads the hooks once per transaction", func() {
+		// The hooks changed during the transaction apply from the next one
+		hook("pre.yaml", "when: pre\ncommands: [\"rm "+filepath.Join(hooksDir, "post.yaml")+"\"]\n")
+		hook("post.yaml", "when: post\ncommands: [\"touch $LUET_ROOTFS/post\"]\n")
+
+		Expect(inst.Install([]pkg.Package{testPackage("daemon", "1.0")}, system)).ToNot(HaveOccurred())
+		Expect(helpers.Exists(filepath.Join(fakeroot, "post"))).To(BeTrue())
+	})
+
+	It("Aborts the transaction if a pre hook fails", func() {
+		hook("abort.yaml", "when: pre\noperations: [install]\ncommands: [\"exit 1\"]\n")
+		hook("post.yaml", "when: post\ncommands: [\"touch $LUET_ROOTFS/post\"]\n")
+
+		Expect(inst.Install([]pkg.Package{testPackage("daemon", "1.0")}, system)).To(HaveOccurred())
+		Expect(helpers.Exists(filepath.Join(fakeroot, "etc", "daemon.conf"))).To(BeFalse())
+		Expect(helpers.Exists(filepath.Join(fakeroot, "post"))).To(BeFalse())
+		Expect(system.Database.World()).To(BeEmpty())
+
+		history, err := system.Database.GetTransactions()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(history)).To(Equal(1))
+		Expect(history[0].Succeeded()).To(BeFalse())
+	})
+})
@@ -21,12 +21,15 @@ import (
 	"os/exec"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/ghodss/yaml"
 	compiler "github.com/mudler/luet/pkg/compiler"
 	"github.com/mudler/luet/pkg/config"
 	"github.com/mudler/luet/pkg/helpers"
+	"github.com/mudler/luet/pkg/installer/client"
 	. "github.com/mudler/luet/pkg/logger"
 	pkg "github.com/mudler/luet/pkg/package"
 	"github.com/mudler/luet/pkg/solver"
@@ -38,6 +41,14 @@ import (
 type LuetInstallerOptions struct {
 	SolverOptions config.LuetSolverOptions
 	Concurrency   int
+	// DownloadConcurrency is the number of parallel downloads, defaults to Concurrency
+	DownloadConcurrency int
+	// AllowDowngrade lets Install replace the installed versions of the requested packages
+	AllowDowngrade bool
+	// HooksDir is the directory of the hooks executed before and after the transactions
+	HooksDir string
+	// TriggersDir is the directory of the file triggers, relative to the system target
+	TriggersDir string
 }
 
 type LuetInstaller struct {
@@ -55,26 +66,28 @@ type ArtifactMatch struct {
 type LuetFinalizer struct {
 	Install   []string `json:"install"`
 	Uninstall []string `json:"uninstall"` // TODO: Where to store?
+	// Host runs the commands on the host instead of inside the system target, when it's not /.
+	// The commands can reach the target through the LUET_ROOTFS environment variable.
+	Host bool `json:"host,omitempty"`
 }
 
-func (f *LuetFinalizer) RunInstall() error {
-	for _, c := range f.Install {
-		Debug("finalizer:", "sh", "-c", c)
-		cmd := exec.Command("sh", "-c", c)
-		stdoutStderr, err := cmd.CombinedOutput()
-		if err != nil {
-			return errors.Wrap(err, "Failed running command: "+string(stdoutStderr))
-		}
-		Info(string(stdoutStderr))
-	}
-	return nil
+// RunInstall executes the install commands inside target, chrooted, unless it's / or the finalizer runs on the host
+func (f *LuetFinalizer) RunInstall(target string) error {
+	return f.run(f.Install, target)
 }
 
 // TODO: We don't store uninstall finalizers ?!
-func (f *LuetFinalizer) RunUnInstall() error {
-	for _, c := range f.Install {
-		Debug("finalizer:", "sh", "-c", c)
-		cmd := exec.Command("sh", "-c", c)
+func (f *LuetFinalizer) RunUnInstall(target string) error {
+	return f.run(f.Install, target)
+}
+
+func (f *LuetFinalizer) run(commands []string, target string) error {
+	for _, c := range commands {
+		cmd, err := shellCommand(c, target, f.Host)
+		if err != nil {
+			return err
+		}
+		Debug("finalizer:", cmd.Args)
 		stdoutStderr, err := cmd.CombinedOutput()
 		if err != nil {
 			return errors.Wrap(err, "Failed running command: "+string(stdoutStderr))
@@ -84,6 +97,27 @@ func (f *LuetFinalizer) RunUnInstall() error {
 	return nil
 }
 
+// shellCommand returns the command running c with sh, chrooted into target unless it's / or host is set.
+// The command gets the target in the LUET_ROOTFS environment variable.
+func shellCommand(c, target string, host bool) (*exec.Cmd, error) {
+	if target == "" {
+		target = "/"
+	}
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed resolving target "+target)
+	}
+
+	var cmd *exec.Cmd
+	if host || target == "/" {
+		cmd = exec.Command("sh", "-c", c)
+	} else if cmd, err = chrootCommand(c, target); err != nil {
+		return nil, err
+	}
+	cmd.Env = append(os.Environ(), "LUET_ROOTFS="+target)
+	return cmd, nil
+}
+
 func NewLuetFinalizerFromYaml(data []byte) (*LuetFinalizer, error) {
 	var p LuetFinalizer
 	err := yaml.Unmarshal(data, &p)
@@ -98,6 +132,82 @@ func NewLuetInstaller(opts LuetInstallerOptions) Installer {
 }
 
 func (l *LuetInstaller) Upgrade(s *System) error {
+	return l.transact(s, "upgrade", func(t *transaction) error {
+		return l.upgrade(nil, s, t)
+	})
+}
+
+// UpgradePackages upgrades only the installed packages with the same name of the given ones,
+// along with the packages required by their new versions
+func (l *LuetInstaller) UpgradePackages(p []pkg.Package, s *System) error {
+	for _, pi := range p {
+		if len(installedVersions(s, pi)) == 0 {
+			return errors.New("Package " + pi.GetPackageName() + " is not installed")
+		}
+	}
+	return l.transact(s, "upgrade", func(t *transaction) error {
+		return l.upgrade(p, s, t)
+	})
+}
+
+// Reinstall installs again the installed versions of the given packages, going through
+// the solver and the finalizers as a normal installation
+func (l *LuetInstaller) Reinstall(p []pkg.Package, s *System) error {
+	var installed []pkg.Package
+	for _, pi := range p {
+		vers := installedVersions(s, pi)
+		if len(vers) == 0 {
+			return errors.New("Package " + pi.GetPackageName() + " is not installed")
+		}
+		installed = append(installed, vers...)
+	}
+
+	return l.transact(s, "reinstall", func(t *transaction) error {
+		syncedRepos, err := l.SyncRepositories(true)
+		if err != nil {
+			return err
+		}
+		allRepos := pkg.NewInMemoryDatabase(false)
+		syncedRepos.SyncDatabase(allRepos)
+
+		return l.swap(syncedRepos, allRepos, installed, installed, s, t)
+	})
+}
+
+// installedVersions returns the installed packages with the same name of p, in any slot unless p has one
+func installedVersions(s *System, p pkg.Package) []pkg.Package {
+	var res []pkg.Package
+	for _, i := range s.Database.World() {
+		if i.GetPackageName() == p.GetPackageName() && (p.GetSlot() == "" || i.GetSlot() == p.GetSlot()) {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+// swap replaces the installed old packages with the new ones. Reverse dependencies of the
+// old packages are kept in the solution, so the new ones have to satisfy them
+func (l *LuetInstaller) swap(syncedRepos Repositories, allRepos pkg.PackageDatabase, old, new []pkg.Package, s *System, t *transaction) error {
+	for i, p := range new {
+		def, err := allRepos.FindPackage(p)
+		if err != nil {
+			return errors.Wrap(err, "Package "+p.HumanReadableString()+" is not available in the repositories")
+		}
+		new[i] = def
+	}
+
+	resolver := l.Options.SolverOptions.Resolver()
+	solv := solver.NewResolver(s.Database, allRepos, pkg.NewInMemoryDatabase(false), resolver)
+	solution, err := solv.Replace(old, new)
+	if err != nil {
+		return errors.Wrap(err, "Failed solving solution for package")
+	}
+	warnDropped(resolver)
+
+	return l.replace(syncedRepos, allRepos, old, solution, s, t)
+}
+
+func (l *LuetInstaller) upgrade(selected []pkg.Package, s *System, t *transaction) error {
 	syncedRepos, err := l.SyncRepositories(true)
 	if err != nil {
 		return err
@@ -107,27 +217,219 @@ func (l *LuetInstaller) Upgrade(s *System) error {
 	allRepos := pkg.NewInMemoryDatabase(false)
 	syncedRepos.SyncDatabase(allRepos)
 	// compute a "big" world
-	solv := solver.NewResolver(s.Database, allRepos, pkg.NewInMemoryDatabase(false), l.Options.SolverOptions.Resolver())
-	uninstall, solution, err := solv.Upgrade()
+	resolver := l.Options.SolverOptions.Resolver()
+	solv := solver.NewResolver(s.Database, allRepos, pkg.NewInMemoryDatabase(false), resolver)
+	var uninstall []pkg.Package
+	var solution solver.PackagesAssertions
+	if selected == nil {
+		uninstall, solution, err = solv.Upgrade()
+	} else {
+		uninstall, solution, err = solv.UpgradePackages(selected)
+	}
 	if err != nil {
 		return errors.Wrap(err, "Failed solving solution for upgrade")
 	}
+	warnDropped(resolver)
+
+	return l.replace(syncedRepos, allRepos, uninstall, solution, s, t)
+}
+
+// replace brings the system to the solution, replacing in place the old packages with their new versions.
+// All the artifacts are downloaded first, then the new files are unpacked over the old ones, the files
+// which are not shipped anymore are removed and the database records are swapped in a single step,
+// so the system is never left without a package being upgraded.
+// The old packages kept by the solution are installed again, the ones without a new version are removed last.
+func (l *LuetInstaller) replace(syncedRepos Repositories, allRepos pkg.PackageDatabase, old []pkg.Package, solution solver.PackagesAssertions, s *System, t *transaction) error {
+	reinstall := map[string]bool{}
+	for _, o := range old {
+		reinstall[o.GetFingerPrint()] = true
+	}
+	toInstall, err := l.matchArtifacts(syncedRepos, solution, s, reinstall)
+	if err != nil {
+		return err
+	}
+	if len(toInstall) == 0 && len(old) == 0 {
+		Info("Nothing to upgrade")
+		return nil
+	}
 
-	for _, u := range uninstall {
-		err := l.Uninstall(u, s)
+	toInstall, err = l.download(toInstall)
+	if err != nil {
+		return errors.Wrap(err, "Failed downloading packages")
+	}
+
+	// The files of the new packages are never removed, even if an old package
+	// other than the replaced one shipped them
+	keep := map[string]bool{}
+	newFiles := map[string][]string{}
+	var keys []string
+	for k, m := range toInstall {
+		files, err := m.Artifact.FileList()
 		if err != nil {
-			Warning("Failed uninstall for ", u.GetFingerPrint())
+			return errors.Wrap(err, "Could not open package archive")
+		}
+		newFiles[k] = files
+		for _, f := range files {
+			keep[f] = true
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	replaced := map[string]pkg.Package{}
+	paired := map[string]bool{}
+	for _, k := range keys {
+		p := toInstall[k].Package
+		for _, o := range old {
+			if paired[o.GetFingerPrint()] {
+				continue
+			}
+			if (o.GetPackageName() == p.GetPackageName() && o.GetSlot() == p.GetSlot()) || p.IsReplacing(o) {
+				replaced[k] = o
+				paired[o.GetFingerPrint()] = true
+				break
+			}
+		}
+		if _, ok := replaced[k]; ok {
+			continue
+		}
+		// A new version required by the solution replaces the installed one in the same slot
+		vers, _ := s.Database.FindPackageVersions(p)
+		for _, o := range vers {
+			if !paired[o.GetFingerPrint()] && !o.Matches(p) {
+				replaced[k] = o
+				paired[o.GetFingerPrint()] = true
+				break
+			}
 		}
 	}
 
-	toInstall := []pkg.Package{}
-	for _, assertion := range solution {
-		if assertion.Value {
-			toInstall = append(toInstall, assertion.Package)
+	for _, k := range keys {
+		if o, ok := replaced[k]; ok {
+			t.Replace(o, toInstall[k].Package)
+		} else {
+			t.Add(toInstall[k].Package)
+		}
+	}
+	for _, o := range old {
+		if !paired[o.GetFingerPrint()] {
+			t.Remove(o)
+		}
+	}
+
+	err = l.begin(t, s, func() ([]string, error) {
+		var files []string
+		for _, k := range keys {
+			files = append(files, newFiles[k]...)
+		}
+		for _, o := range replaced {
+			f, _ := s.Database.GetPackageFiles(o)
+			files = append(files, f...)
 		}
+		for _, o := range old {
+			f, _ := s.Database.GetPackageFiles(o)
+			files = append(files, f...)
+		}
+		return uniqueFiles(files), nil
+	})
+	if err != nil {
+		return err
 	}
 
-	return l.Install(toInstall, s)
+	for _, k := range keys {
+		m := toInstall[k]
+		if err := m.Artifact.Unpack(s.Target, true); err != nil {
+			return errors.Wrap(err, "Error met while unpacking rootfs")
+		}
+		t.touch(newFiles[k]...)
+		files := &pkg.PackageFile{PackageFingerprint: m.Package.GetFingerPrint(), Files: newFiles[k]}
+
+		o, ok := replaced[k]
+		if !ok {
+			if _, err := s.Database.CreatePackage(m.Package); err != nil {
+				return errors.Wrap(err, "Failed creating package")
+			}
+			if err := s.Database.SetPackageFiles(files); err != nil {
+				return errors.Wrap(err, "Failed setting package files")
+			}
+			Info(m.Package.HumanReadableString(), "Installed")
+			continue
+		}
+
+		oldFiles, _ := s.Database.GetPackageFiles(o)
+		removeFiles(s, oldFiles, keep)
+		t.touch(oldFiles...)
+		if err := s.Database.ReplacePackage(o, m.Package, files); err != nil {
+			return errors.Wrap(err, "Failed replacing "+o.HumanReadableString())
+		}
+		Info(o.HumanReadableString(), "Upgraded to", m.Package.HumanReadableString())
+	}
+
+	for _, o := range old {
+		if paired[o.GetFingerPrint()] {
+			continue
+		}
+		oldFiles, _ := s.Database.GetPackageFiles(o)
+		removeFiles(s, oldFiles, keep)
+		t.touch(oldFiles...)
+		s.Database.RemovePackageFiles(o)
+		if err := s.Database.RemovePackage(o); err != nil {
+			return errors.Wrap(err, "Failed removing package from database")
+		}
+		Info(o.GetFingerPrint(), "Removed")
+	}
+
+	executedFinalizer := map[string]bool{}
+	for _, k := range keys {
+		for _, ass := range solution.Order(allRepos, k) {
+			if m, ok := toInstall[ass.Package.GetFingerPrint()]; ok && ass.Value {
+				if err := l.runFinalizer(m, s, executedFinalizer); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// uniqueFiles returns the sorted files, without duplicates
+func uniqueFiles(files []string) []string {
+	seen := map[string]bool{}
+	res := []string{}
+	for _, f := range files {
+		if !seen[f] {
+			seen[f] = true
+			res = append(res, f)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// removeFiles removes the files from the system target, skipping the ones in keep
+func removeFiles(s *System, files []string, keep map[string]bool) {
+	for _, f := range files {
+		if keep[f] {
+			continue
+		}
+		target := filepath.Join(s.Target, f)
+		Info("Removing", target)
+		if err := os.Remove(target); err != nil {
+			Warning("Failed removing file (not present in the system target ?)", target)
+		}
+	}
+}
+
+// warnDropped reports the wanted packages which were left out by a relaxing resolver
+func warnDropped(r solver.PackageResolver) {
+	relaxed, ok := r.(*solver.MinimalRelaxationResolver)
+	if !ok {
+		return
+	}
+	for _, d := range relaxed.Dropped() {
+		Warning("Skipping", d.String())
+	}
 }
 
 func (l *LuetInstaller) SyncRepositories(inMemory bool) (Repositories, error) {
@@ -153,15 +455,48 @@ func (l *LuetInstaller) SyncRepositories(inMemory bool) (Repositories, error) {
 }
 
 func (l *LuetInstaller) Install(cp []pkg.Package, s *System) error {
-	var p []pkg.Package
+	return l.transact(s, "install", func(t *transaction) error {
+		return l.install(cp, s, t)
+	})
+}
+
+func (l *LuetInstaller) install(cp []pkg.Package, s *System, t *transaction) error {
+	var p, old []pkg.Package
+
+	// First get metas from all repos (and decodes trees)
+
+	syncedRepos, err := l.SyncRepositories(true)
+	if err != nil {
+		return err
+	}
+	// First match packages against repositories by priority
+	//	matches := syncedRepos.PackageMatches(p)
 
-	// Check if the package is installed first
-	for _, pi := range cp {
+	// compute a "big" world
+	allRepos := pkg.NewInMemoryDatabase(false)
+	syncedRepos.SyncDatabase(allRepos)
+
+	// Check if the package is installed first. Resolve against the repositories
+	// so we know the slot of the package we are going to install.
+	for _, pi := range syncedRepos.ResolveSelectors(cp) {
+		if def, err := allRepos.FindPackage(pi); err == nil {
+			pi = def
+		}
 
 		vers, _ := s.Database.FindPackageVersions(pi)
 
+		if len(vers) >= 1 && l.Options.AllowDowngrade {
+			if _, err := s.Database.FindPackage(pi); err == nil {
+				Warning("Package " + pi.GetFingerPrint() + " is already installed")
+				continue
+			}
+			old = append(old, vers...)
+			p = append(p, pi)
+			continue
+		}
+
 		if len(vers) >= 1 {
-			Warning("Filtering out package " + pi.GetFingerPrint() + ", it has other versions already installed. Uninstall one of them first ")
+			Warning("Filtering out package " + pi.GetFingerPrint() + ", it has other versions already installed in the same slot. Uninstall one of them first ")
 			continue
 			//return errors.New("Package " + pi.GetFingerPrint() + " has other versions already installed. Uninstall one of them first: " + strings.Join(vers, " "))
 
@@ -173,65 +508,44 @@ func (l *LuetInstaller) Install(cp []pkg.Package, s *System) error {
 		Warning("No package to install, bailing out with no errors")
 		return nil
 	}
-	// First get metas from all repos (and decodes trees)
 
-	syncedRepos, err := l.SyncRepositories(true)
-	if err != nil {
-		return err
+	// Other versions are installed, replace them in place
+	if len(old) > 0 {
+		return l.swap(syncedRepos, allRepos, old, p, s, t)
 	}
-	// First match packages against repositories by priority
-	//	matches := syncedRepos.PackageMatches(p)
 
-	// compute a "big" world
-	allRepos := pkg.NewInMemoryDatabase(false)
-	syncedRepos.SyncDatabase(allRepos)
-	p = syncedRepos.ResolveSelectors(p)
-
-	solv := solver.NewResolver(s.Database, allRepos, pkg.NewInMemoryDatabase(false), l.Options.SolverOptions.Resolver())
+	resolver := l.Options.SolverOptions.Resolver()
+	solv := solver.NewResolver(s.Database, allRepos, pkg.NewInMemoryDatabase(false), resolver)
 	solution, err := solv.Install(p)
 	if err != nil {
 		return errors.Wrap(err, "Failed solving solution for package")
 	}
+	warnDropped(resolver)
 
 	// Gathers things to install
-	toInstall := map[string]ArtifactMatch{}
-	for _, assertion := range solution {
-		if assertion.Value {
-			matches := syncedRepos.PackageMatches([]pkg.Package{assertion.Package})
-			if len(matches) == 0 {
-				return errors.New("Failed matching solutions against repository - where are definitions coming from?!")
-			}
-		A:
-			for _, artefact := range matches[0].Repo.GetIndex() {
-				if artefact.GetCompileSpec().GetPackage() == nil {
-					return errors.New("Package in compilespec empty")
-
-				}
-				if matches[0].Package.Matches(artefact.GetCompileSpec().GetPackage()) {
-					// Filter out already installed
-					if _, err := s.Database.FindPackage(assertion.Package); err != nil {
-						toInstall[assertion.Package.GetFingerPrint()] = ArtifactMatch{Package: assertion.Package, Artifact: artefact, Repository: matches[0].Repo}
-					}
-					break A
-				}
-			}
-		}
+	toInstall, err := l.matchArtifacts(syncedRepos, solution, s, nil)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for k := range toInstall {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		t.Add(toInstall[k].Package)
 	}
 
-	// Install packages into rootfs in parallel.
-	all := make(chan ArtifactMatch)
-
-	var wg = new(sync.WaitGroup)
-	for i := 0; i < l.Options.Concurrency; i++ {
-		wg.Add(1)
-		go l.installerWorker(i, wg, all, s)
+	// Download and verify all the artifacts first, so the rootfs is touched only
+	// when all of them are available.
+	toInstall, err = l.download(toInstall)
+	if err != nil {
+		return errors.Wrap(err, "Failed downloading packages")
 	}
 
-	for _, c := range toInstall {
-		all <- c
+	if err := l.unpack(toInstall, keys, s, t); err != nil {
+		return err
 	}
-	close(all)
-	wg.Wait()
 
 	executedFinalizer := map[string]bool{}
 
@@ -259,49 +573,225 @@ func (l *LuetInstaller) Install(cp []pkg.Package, s *System) error {
 					return errors.New("Couldn't find ArtifactMatch for " + ass.Package.GetFingerPrint())
 				}
 
-				treePackage, err := installed.Repository.GetTree().GetDatabase().FindPackage(ass.Package)
-				if err != nil {
-					return errors.Wrap(err, "Error getting package "+ass.Package.GetFingerPrint())
+				if err := l.runFinalizer(installed, s, executedFinalizer); err != nil {
+					return err
 				}
-				if helpers.Exists(treePackage.Rel(tree.FinalizerFile)) {
-					Info("Executing finalizer for " + ass.Package.GetName())
-					finalizerRaw, err := ioutil.ReadFile(treePackage.Rel(tree.FinalizerFile))
-					if err != nil {
-						return errors.Wrap(err, "Error reading file "+treePackage.Rel(tree.FinalizerFile))
-					}
-					if _, exists := executedFinalizer[ass.Package.GetFingerPrint()]; !exists {
-						finalizer, err := NewLuetFinalizerFromYaml(finalizerRaw)
-						if err != nil {
-							return errors.Wrap(err, "Error reading finalizer "+treePackage.Rel(tree.FinalizerFile))
-						}
-						err = finalizer.RunInstall()
-						if err != nil {
-							return errors.Wrap(err, "Error executing install finalizer "+treePackage.Rel(tree.FinalizerFile))
-						}
-						executedFinalizer[ass.Package.GetFingerPrint()] = true
+			}
+		}
+
+	}
+
+	return nil
+
+}
+
+// unpack executes the pre hooks of the transaction, then installs the downloaded artifacts into the system target in parallel
+func (l *LuetInstaller) unpack(toInstall map[string]ArtifactMatch, keys []string, s *System, t *transaction) error {
+	err := l.begin(t, s, func() ([]string, error) {
+		var files []string
+		for _, k := range keys {
+			f, err := toInstall[k].Artifact.FileList()
+			if err != nil {
+				return nil, errors.Wrap(err, "Could not open package archive")
+			}
+			files = append(files, f...)
+		}
+		return uniqueFiles(files), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Install packages into rootfs in parallel.
+	all := make(chan ArtifactMatch)
+
+	var wg = new(sync.WaitGroup)
+	for i := 0; i < l.Options.Concurrency; i++ {
+		wg.Add(1)
+		go l.installerWorker(i, wg, all, s, t)
+	}
+
+	for _, c := range toInstall {
+		all <- c
+	}
+	close(all)
+	wg.Wait()
+
+	return nil
+}
+
+// matchArtifacts returns the artifacts of the packages of the solution which are not installed yet,
+// or which have to be installed again
+func (l *LuetInstaller) matchArtifacts(syncedRepos Repositories, solution solver.PackagesAssertions, s *System, reinstall map[string]bool) (map[string]ArtifactMatch, error) {
+	toInstall := map[string]ArtifactMatch{}
+	for _, assertion := range solution {
+		if assertion.Value {
+			matches := syncedRepos.PackageMatches([]pkg.Package{assertion.Package})
+			if len(matches) == 0 {
+				return nil, errors.New("Failed matching solutions against repository - where are definitions coming from?!")
+			}
+		A:
+			for _, artefact := range matches[0].Repo.GetIndex() {
+				if artefact.GetCompileSpec().GetPackage() == nil {
+					return nil, errors.New("Package in compilespec empty")
+
+				}
+				if matches[0].Package.Matches(artefact.GetCompileSpec().GetPackage()) {
+					// Filter out already installed
+					if _, err := s.Database.FindPackage(assertion.Package); err != nil || reinstall[assertion.Package.GetFingerPrint()] {
+						toInstall[assertion.Package.GetFingerPrint()] = ArtifactMatch{Package: assertion.Package, Artifact: artefact, Repository: matches[0].Repo}
 					}
+					break A
 				}
 			}
 		}
-
 	}
+	return toInstall, nil
+}
 
+// runFinalizer executes in the system target the install finalizer of the installed package, if any and if it wasn't executed yet
+func (l *LuetInstaller) runFinalizer(installed ArtifactMatch, s *System, executed map[string]bool) error {
+	p := installed.Package
+	treePackage, err := installed.Repository.GetTree().GetDatabase().FindPackage(p)
+	if err != nil {
+		return errors.Wrap(err, "Error getting package "+p.GetFingerPrint())
+	}
+	if helpers.Exists(treePackage.Rel(tree.FinalizerFile)) {
+		Info("Executing finalizer for " + p.GetName())
+		finalizerRaw, err := ioutil.ReadFile(treePackage.Rel(tree.FinalizerFile))
+		if err != nil {
+			return errors.Wrap(err, "Error reading file "+treePackage.Rel(tree.FinalizerFile))
+		}
+		if _, exists := executed[p.GetFingerPrint()]; !exists {
+			finalizer, err := NewLuetFinalizerFromYaml(finalizerRaw)
+			if err != nil {
+				return errors.Wrap(err, "Error reading finalizer "+treePackage.Rel(tree.FinalizerFile))
+			}
+			err = finalizer.RunInstall(s.Target)
+			if err != nil {
+				return errors.Wrap(err, "Error executing install finalizer "+treePackage.Rel(tree.FinalizerFile))
+			}
+			executed[p.GetFingerPrint()] = true
+		}
+	}
 	return nil
+}
+
+// download fetches and verifies the artifacts of the matches in parallel, reporting the overall progress.
+// It returns the matches pointing to the downloaded artifacts, or the errors of all the failed downloads.
+func (l *LuetInstaller) download(toDownload map[string]ArtifactMatch) (map[string]ArtifactMatch, error) {
+	concurrency := l.Options.DownloadConcurrency
+	if concurrency <= 0 {
+		concurrency = l.Options.Concurrency
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	progress := client.NewProgress(len(toDownload))
+	Spinner(22)
+	stop := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				SpinnerText(" "+progress.String(), "Downloading")
+			}
+		}
+	}()
+
+	var mutex sync.Mutex
+	downloaded := map[string]ArtifactMatch{}
+	var failures []string
+
+	all := make(chan string)
+	var wg = new(sync.WaitGroup)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range all {
+				match := toDownload[k]
+				artifact, err := l.downloadPackage(match, progress)
 
+				mutex.Lock()
+				if err != nil {
+					failures = append(failures, match.Package.HumanReadableString()+": "+err.Error())
+				} else {
+					match.Artifact = artifact
+					downloaded[k] = match
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for k := range toDownload {
+		all <- k
+	}
+	close(all)
+	wg.Wait()
+
+	close(stop)
+	SpinnerStop()
+
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return nil, errors.New(strings.Join(failures, "\n"))
+	}
+	Info("Downloaded", progress.String())
+	return downloaded, nil
 }
 
-func (l *LuetInstaller) installPackage(a ArtifactMatch, s *System) error {
+func (l *LuetInstaller) downloadPackage(a ArtifactMatch, progress *client.Progress) (compiler.Artifact, error) {
+	c := a.Repository.Client()
+	if c == nil {
+		return nil, errors.New("No client could be generated from repository " + a.Repository.GetName())
+	}
+	if reporter, ok := c.(ProgressReporter); ok {
+		reporter.SetProgress(progress)
+	}
 
-	artifact, err := a.Repository.Client().DownloadArtifact(a.Artifact)
+	artifact, err := c.DownloadArtifact(a.Artifact)
 	if err != nil {
-		return errors.Wrap(err, "Error on download artifact")
+		return nil, errors.Wrap(err, "Error on download artifact")
+	}
+
+	if artifact.Verify() != nil {
+		// The cached copy may be stale, e.g. of a package rebuilt with the same version: fetch it again
+		os.Remove(artifact.GetPath())
+		artifact, err = c.DownloadArtifact(a.Artifact)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error on download artifact")
+		}
 	}
 
 	err = artifact.Verify()
 	if err != nil {
-		return errors.Wrap(err, "Artifact integrity check failure")
+		// Don't keep a corrupted artifact in the cache
+		os.Remove(artifact.GetPath())
+		return nil, errors.Wrap(err, "Artifact integrity check failure")
 	}
 
+	// Keep the metadata next to the cached artifact, so it can be installed again from the cache
+	if err := artifact.WriteYaml(filepath.Dir(artifact.GetPath())); err != nil {
+		Warning("Failed writing the metadata of", filepath.Base(artifact.GetPath()), ":", err.Error())
+	}
+
+	if info, err := os.Stat(artifact.GetPath()); err == nil {
+		progress.Complete(filepath.Base(artifact.GetPath()), info.Size())
+	}
+	return artifact, nil
+}
+
+// installPackage unpacks the downloaded artifact of the match into the system target
+func (l *LuetInstaller) installPackage(a ArtifactMatch, s *System, t *transaction) error {
+	artifact := a.Artifact
+
 	files, err := artifact.FileList()
 	if err != nil {
 		return errors.Wrap(err, "Could not open package archive")
@@ -311,18 +801,19 @@ func (l *LuetInstaller) installPackage(a ArtifactMatch, s *System) error {
 	if err != nil {
 		return errors.Wrap(err, "Error met while unpacking rootfs")
 	}
+	t.touch(files...)
 
 	// First create client and download
 	// Then unpack to system
 	return s.Database.SetPackageFiles(&pkg.PackageFile{PackageFingerprint: a.Package.GetFingerPrint(), Files: files})
 }
 
-func (l *LuetInstaller) installerWorker(i int, wg *sync.WaitGroup, c <-chan ArtifactMatch, s *System) error {
+func (l *LuetInstaller) installerWorker(i int, wg *sync.WaitGroup, c <-chan ArtifactMatch, s *System, t *transaction) error {
 	defer wg.Done()
 
 	for p := range c {
 		// TODO: Keep trace of what was added from the tar, and save it into system
-		err := l.installPackage(p, s)
+		err := l.installPackage(p, s, t)
 		if err != nil {
 			//TODO: Uninstall, rollback.
 			Fatal("Failed installing package "+p.Package.GetName(), err.Error())
@@ -333,7 +824,7 @@ func (l *LuetInstaller) installerWorker(i int, wg *sync.WaitGroup, c <-chan Arti
 	return nil
 }
 
-func (l *LuetInstaller) uninstall(p pkg.Package, s *System) error {
+func (l *LuetInstaller) uninstall(p pkg.Package, s *System, t *transaction) error {
 	files, err := s.Database.GetPackageFiles(p)
 	if err != nil {
 		return errors.Wrap(err, "Failed getting installed files")
@@ -348,6 +839,7 @@ func (l *LuetInstaller) uninstall(p pkg.Package, s *System) error {
 			Warning("Failed removing file (not present in the system target ?)", target)
 		}
 	}
+	t.touch(files...)
 	err = s.Database.RemovePackageFiles(p)
 	if err != nil {
 		return errors.Wrap(err, "Failed removing package files from database")
@@ -362,22 +854,37 @@ func (l *LuetInstaller) uninstall(p pkg.Package, s *System) error {
 }
 
 func (l *LuetInstaller) Uninstall(p pkg.Package, s *System) error {
-	// compute uninstall from all world - remove packages in parallel - run uninstall finalizer (in order) - mark the uninstallation in db
-	// Get installed definition
-	solv := solver.NewResolver(s.Database, s.Database, pkg.NewInMemoryDatabase(false), l.Options.SolverOptions.Resolver())
-	solution, err := solv.Uninstall(p)
-	if err != nil {
-		return errors.Wrap(err, "Uninstall failed")
-	}
-	for _, p := range solution {
-		Info("Uninstalling", p.GetFingerPrint())
-		err := l.uninstall(p, s)
+	return l.transact(s, "uninstall", func(t *transaction) error {
+		// compute uninstall from all world - remove packages in parallel - run uninstall finalizer (in order) - mark the uninstallation in db
+		// Get installed definition
+		solv := solver.NewResolver(s.Database, s.Database, pkg.NewInMemoryDatabase(false), l.Options.SolverOptions.Resolver())
+		solution, err := solv.Uninstall(p)
 		if err != nil {
 			return errors.Wrap(err, "Uninstall failed")
 		}
-	}
-	return nil
-
+		for _, p := range solution {
+			t.Remove(p)
+		}
+		err = l.begin(t, s, func() ([]string, error) {
+			var files []string
+			for _, p := range solution {
+				f, _ := s.Database.GetPackageFiles(p)
+				files = append(files, f...)
+			}
+			return uniqueFiles(files), nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, p := range solution {
+			Info("Uninstalling", p.GetFingerPrint())
+			err := l.uninstall(p, s, t)
+			if err != nil {
+				return errors.Wrap(err, "Uninstall failed")
+			}
+		}
+		return nil
+	})
 }
 
 func (l *LuetInstaller) Repositories(r []Repository) { l.PackageRepositories = r }
@@ -16,10 +16,17 @@
 package installer_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	. "github.com/mudler/luet/cmd"
+	"github.com/mudler/luet/pkg/compiler"
 	config "github.com/mudler/luet/pkg/config"
+	. "github.com/mudler/luet/pkg/installer"
+	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/mudler/luet/pkg/tree"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -34,3 +41,86 @@ func TestInstaller(t *testing.T) {
 	config.LuetCfg.GetSystem().PkgsCachePath = ""
 	RunSpecs(t, "Installer Suite")
 }
+
+// tempDirs are the temporary directories created by the running spec
+var tempDirs []string
+
+var _ = AfterEach(func() {
+	for _, d := range tempDirs {
+		os.RemoveAll(d)
+	}
+	tempDirs = nil
+})
+
+// tempDir returns a new temporary directory, which is removed after the running spec
+func tempDir(prefix string) string {
+	dir, err := ioutil.TempDir("", prefix)
+	Expect(err).ToNot(HaveOccurred())
+	tempDirs = append(tempDirs, dir)
+	return dir
+}
+
+// writeDefinition writes the definition of p in the tree
+func writeDefinition(treeDir string, p *pkg.DefaultPackage) {
+	dir := tree.DefinitionDir(treeDir, p)
+	Expect(os.MkdirAll(dir, os.ModePerm)).ToNot(HaveOccurred())
+	data, err := p.Yaml()
+	Expect(err).ToNot(HaveOccurred())
+	Expect(ioutil.WriteFile(filepath.Join(dir, tree.DefinitionFile), data, os.ModePerm)).ToNot(HaveOccurred())
+}
+
+// writeArtifact creates the package artifact of p with the given files, and its metadata, in dst
+func writeArtifact(dst string, p *pkg.DefaultPackage, files map[string]string) {
+	content := tempDir("content")
+	for f, data := range files {
+		Expect(os.MkdirAll(filepath.Dir(filepath.Join(content, f)), os.ModePerm)).ToNot(HaveOccurred())
+		Expect(ioutil.WriteFile(filepath.Join(content, f), []byte(data), os.ModePerm)).ToNot(HaveOccurred())
+	}
+
+	a := compiler.NewPackageArtifact(filepath.Join(dst, p.GetFingerPrint()+".package.tar"))
+	Expect(a.Compress(content, 1)).ToNot(HaveOccurred())
+	a.SetCompileSpec(&compiler.LuetCompilationSpec{Package: p})
+	Expect(a.WriteYaml(dst)).ToNot(HaveOccurred())
+}
+
+// testRepository is a disk repository in a temporary directory, generated from a temporary tree
+type testRepository struct {
+	Name string
+	Dir  string
+	Tree string
+}
+
+func newTestRepository(name string) *testRepository {
+	return &testRepository{Name: name, Dir: tempDir(name), Tree: tempDir("tree")}
+}
+
+// Add writes the definition of p and its artifact, with the given files
+func (r *testRepository) Add(p *pkg.DefaultPackage, files map[string]string) {
+	writeDefinition(r.Tree, p)
+	writeArtifact(r.Dir, p, files)
+}
+
+// Generate writes the repository metadata, then returns the repository to sync it
+func (r *testRepository) Generate() Repository {
+	generated, err := GenerateRepository(r.Name, "", "disk", []string{r.Dir}, 1, r.Dir, r.Tree, pkg.NewInMemoryDatabase(false))
+	Expect(err).ToNot(HaveOccurred())
+	Expect(generated.Write(r.Dir, false)).ToNot(HaveOccurred())
+	return NewSystemRepository(config.LuetRepository{Name: r.Name, Type: "disk", Urls: []string{r.Dir}})
+}
+
+// newTestSystem returns a system with an in memory database and a temporary target
+func newTestSystem() *System {
+	return &System{Database: pkg.NewInMemoryDatabase(false), Target: tempDir("fakeroot")}
+}
+
+// newTestInstaller returns an installer with the given options and repositories
+func newTestInstaller(opts LuetInstallerOptions, repos ...Repository) Installer {
+	inst := NewLuetInstaller(opts)
+	inst.Repositories(Repositories(repos))
+	return inst
+}
+
+// testPackage returns the package of the test category with the given name and version
+func testPackage(name, version string) *pkg.DefaultPackage {
+	return &pkg.DefaultPackage{Name: name, Category: "test", Version: version}
+}
@@ -23,6 +23,7 @@ import (
 	//	. "github.com/mudler/luet/pkg/installer"
 	compiler "github.com/mudler/luet/pkg/compiler"
 	backend "github.com/mudler/luet/pkg/compiler/backend"
+	config "github.com/mudler/luet/pkg/config"
 	"github.com/mudler/luet/pkg/helpers"
 	. "github.com/mudler/luet/pkg/installer"
 	pkg "github.com/mudler/luet/pkg/package"
@@ -493,4 +494,180 @@ urls:
 
 	})
 
+	Context("Downloads", func() {
+		var tmpdir, fakeroot string
+		var inst Installer
+		var system *System
+
+		BeforeEach(func() {
+			repo := newTestRepository("test")
+			repo.Tree = "../../tests/fixtures/buildable"
+			tmpdir = repo.Dir
+
+			writeArtifact(tmpdir, testPackage("b", "1.0"), map[string]string{"test5": "artifact5"})
+			writeArtifact(tmpdir, testPackage("c", "1.0"), map[string]string{"c": "c"})
+
+			inst = newTestInstaller(LuetInstallerOptions{Concurrency: 1, DownloadConcurrency: 2}, repo.Generate())
+			system = newTestSystem()
+			fakeroot = system.Target
+		})
+
+		It("Installs once all the artifacts are downloaded", func() {
+			err := inst.Install([]pkg.Package{
+				&pkg.DefaultPackage{Name: "b", Category: "test", Version: "1.0"},
+				&pkg.DefaultPackage{Name: "c", Category: "test", Version: "1.0"},
+			}, system)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(helpers.Read(filepath.Join(fakeroot, "test5"))).To(Equal("artifact5"))
+			Expect(helpers.Read(filepath.Join(fakeroot, "c"))).To(Equal("c"))
+		})
+
+		It("Doesn't touch the target when a download fails", func() {
+			Expect(os.Remove(filepath.Join(tmpdir, "c-test-1.0.package.tar"))).ToNot(HaveOccurred())
+
+			err := inst.Install([]pkg.Package{
+				&pkg.DefaultPackage{Name: "b", Category: "test", Version: "1.0"},
+				&pkg.DefaultPackage{Name: "c", Category: "test", Version: "1.0"},
+			}, system)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("test/c"))
+			Expect(helpers.Exists(filepath.Join(fakeroot, "test5"))).To(BeFalse())
+			Expect(system.Database.World()).To(BeEmpty())
+		})
+	})
+
+	Context("In place upgrades", func() {
+		var tmpdir, fakeroot string
+		var inst Installer
+		var system *System
+
+		BeforeEach(func() {
+			repo := newTestRepository("test")
+			tmpdir = repo.Dir
+
+			files := map[string]map[string]string{
+				"1.0": {"usr/bin/inplace": "1.0", "etc/inplace.conf": "conf", "usr/share/inplace/old": "old"},
+				"1.1": {"usr/bin/inplace": "1.1", "etc/inplace.conf": "conf", "usr/share/inplace/new": "new"},
+			}
+			for v, f := range files {
+				repo.Add(testPackage("inplace", v), f)
+			}
+
+			inst = newTestInstaller(LuetInstallerOptions{Concurrency: 1}, repo.Generate())
+			system = newTestSystem()
+			fakeroot = system.Target
+			Expect(inst.Install([]pkg.Package{testPackage("inplace", "1.0")}, system)).ToNot(HaveOccurred())
+			Expect(helpers.Read(filepath.Join(fakeroot, "usr/bin/inplace"))).To(Equal("1.0"))
+		})
+
+		It("Replaces the files and the database records of the old version", func() {
+			Expect(inst.Upgrade(system)).ToNot(HaveOccurred())
+
+			Expect(helpers.Read(filepath.Join(fakeroot, "usr/bin/inplace"))).To(Equal("1.1"))
+			Expect(helpers.Read(filepath.Join(fakeroot, "etc/inplace.conf"))).To(Equal("conf"))
+			Expect(helpers.Exists(filepath.Join(fakeroot, "usr/share/inplace/new"))).To(BeTrue())
+			Expect(helpers.Exists(filepath.Join(fakeroot, "usr/share/inplace/old"))).To(BeFalse())
+
+			world := system.Database.World()
+			Expect(len(world)).To(Equal(1))
+			Expect(world[0].GetVersion()).To(Equal("1.1"))
+			files, err := system.Database.GetPackageFiles(world[0])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(ConsistOf("usr/bin/inplace", "etc/inplace.conf", "usr/share/inplace/new"))
+			_, err = system.Database.GetPackageFiles(&pkg.DefaultPackage{Name: "inplace", Category: "test", Version: "1.0"})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Leaves the system untouched when a download fails", func() {
+			Expect(os.Remove(filepath.Join(tmpdir, "inplace-test-1.1.package.tar"))).ToNot(HaveOccurred())
+
+			Expect(inst.Upgrade(system)).To(HaveOccurred())
+			Expect(helpers.Read(filepath.Join(fakeroot, "usr/bin/inplace"))).To(Equal("1.0"))
+			Expect(helpers.Exists(filepath.Join(fakeroot, "usr/share/inplace/old"))).To(BeTrue())
+			world := system.Database.World()
+			Expect(len(world)).To(Equal(1))
+			Expect(world[0].GetVersion()).To(Equal("1.0"))
+		})
+
+		It("Reinstalls corrupted packages", func() {
+			Expect(os.Remove(filepath.Join(fakeroot, "usr/bin/inplace"))).ToNot(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(fakeroot, "etc/inplace.conf"), []byte("corrupted"), os.ModePerm)).ToNot(HaveOccurred())
+
+			Expect(inst.Reinstall([]pkg.Package{&pkg.DefaultPackage{Name: "inplace", Category: "test"}}, system)).ToNot(HaveOccurred())
+			Expect(helpers.Read(filepath.Join(fakeroot, "usr/bin/inplace"))).To(Equal("1.0"))
+			Expect(helpers.Read(filepath.Join(fakeroot, "etc/inplace.conf"))).To(Equal("conf"))
+			world := system.Database.World()
+			Expect(len(world)).To(Equal(1))
+			Expect(world[0].GetVersion()).To(Equal("1.0"))
+			files, err := system.Database.GetPackageFiles(world[0])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(files)).To(Equal(3))
+
+			Expect(inst.Reinstall([]pkg.Package{&pkg.DefaultPackage{Name: "missing", Category: "test"}}, system)).To(HaveOccurred())
+		})
+
+		It("Downgrades only when allowed", func() {
+			Expect(inst.Upgrade(system)).ToNot(HaveOccurred())
+			older := []pkg.Package{&pkg.DefaultPackage{Name: "inplace", Category: "test", Version: "1.0"}}
+
+			Expect(inst.Install(older, system)).ToNot(HaveOccurred())
+			Expect(helpers.Read(filepath.Join(fakeroot, "usr/bin/inplace"))).To(Equal("1.1"))
+
+			downgrader := newTestInstaller(LuetInstallerOptions{Concurrency: 1, AllowDowngrade: true},
+				NewSystemRepository(config.LuetRepository{Name: "test", Type: "disk", Urls: []string{tmpdir}}))
+			Expect(downgrader.Install(older, system)).ToNot(HaveOccurred())
+			Expect(helpers.Read(filepath.Join(fakeroot, "usr/bin/inplace"))).To(Equal("1.0"))
+			Expect(helpers.Exists(filepath.Join(fakeroot, "usr/share/inplace/old"))).To(BeTrue())
+			Expect(helpers.Exists(filepath.Join(fakeroot, "usr/share/inplace/new"))).To(BeFalse())
+			world := system.Database.World()
+			Expect(len(world)).To(Equal(1))
+			Expect(world[0].GetVersion()).To(Equal("1.0"))
+		})
+	})
+
+	Context("Slots", func() {
+		var fakeroot string
+		var inst Installer
+		var system *System
+
+		BeforeEach(func() {
+			repo := newTestRepository("test")
+			// The same version, built for two slots
+			for _, slot := range []string{"a", "b"} {
+				foo := testPackage("foo", "1.0")
+				foo.Slot = slot
+				repo.Add(foo, map[string]string{"usr/lib/foo-" + slot: slot})
+			}
+
+			inst = newTestInstaller(LuetInstallerOptions{Concurrency: 1}, repo.Generate())
+			system = newTestSystem()
+			fakeroot = system.Target
+		})
+
+		It("Installs and removes the same version in two slots", func() {
+			a := &pkg.DefaultPackage{Name: "foo", Category: "test", Version: "1.0", Slot: "a"}
+			b := &pkg.DefaultPackage{Name: "foo", Category: "test", Version: "1.0", Slot: "b"}
+			Expect(inst.Install([]pkg.Package{a, b}, system)).ToNot(HaveOccurred())
+
+			Expect(helpers.Read(filepath.Join(fakeroot, "usr/lib/foo-a"))).To(Equal("a"))
+			Expect(helpers.Read(filepath.Join(fakeroot, "usr/lib/foo-b"))).To(Equal("b"))
+			Expect(len(system.Database.World())).To(Equal(2))
+			for _, p := range []*pkg.DefaultPackage{a, b} {
+				installed, err := system.Database.FindPackage(p)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(installed.GetSlot()).To(Equal(p.GetSlot()))
+				files, err := system.Database.GetPackageFiles(p)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(files).To(Equal([]string{"usr/lib/foo-" + p.GetSlot()}))
+			}
+
+			Expect(inst.Uninstall(a, system)).ToNot(HaveOccurred())
+			Expect(helpers.Exists(filepath.Join(fakeroot, "usr/lib/foo-a"))).To(BeFalse())
+			Expect(helpers.Read(filepath.Join(fakeroot, "usr/lib/foo-b"))).To(Equal("b"))
+			world := system.Database.World()
+			Expect(len(world)).To(Equal(1))
+			Expect(world[0].GetSlot()).To(Equal("b"))
+		})
+	})
+
 })
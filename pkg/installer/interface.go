@@ -17,6 +17,7 @@ package installer
 
 import (
 	compiler "github.com/mudler/luet/pkg/compiler"
+	"github.com/mudler/luet/pkg/installer/client"
 	pkg "github.com/mudler/luet/pkg/package"
 	"github.com/mudler/luet/pkg/tree"
 	//"github.com/mudler/luet/pkg/solver"
@@ -26,6 +27,11 @@ type Installer interface {
 	Install([]pkg.Package, *System) error
 	Uninstall(pkg.Package, *System) error
 	Upgrade(s *System) error
+	UpgradePackages([]pkg.Package, *System) error
+	Reinstall([]pkg.Package, *System) error
+	Undo(id int, s *System) error
+	Lock([]pkg.Package, *System) (*Lockfile, error)
+	InstallLocked(*Lockfile, *System) error
 	Repositories([]Repository)
 	SyncRepositories(bool) (Repositories, error)
 }
@@ -35,6 +41,17 @@ type Client interface {
 	DownloadFile(string) (string, error)
 }
 
+// Uploader is a Client which is able to publish files to the repository.
+// UploadFile publishes the local file src as name, which is the one given later to DownloadFile
+type Uploader interface {
+	UploadFile(src, name string) error
+}
+
+// ProgressReporter is a Client which reports the progress of its downloads
+type ProgressReporter interface {
+	SetProgress(*client.Progress)
+}
+
 type Repositories []Repository
 
 type Repository interface {
@@ -44,10 +61,12 @@ type Repository interface {
 	SetUrls([]string)
 	AddUrl(string)
 	GetPriority() int
+	SetPriority(int)
 	GetIndex() compiler.ArtifactIndex
 	GetTree() tree.Builder
 	SetTree(tree.Builder)
 	Write(path string, resetRevision bool) error
+	Push(src, dst string) error
 	Sync(bool) (Repository, error)
 	GetTreePath() string
 	SetTreePath(string)
@@ -55,6 +74,7 @@ type Repository interface {
 	SetType(string)
 	SetAuthentication(map[string]string)
 	GetAuthentication() map[string]string
+	SetTimeouts(int, map[string]int)
 	GetRevision() int
 	IncrementRevision()
 	GetLastUpdate() string
@@ -62,6 +82,9 @@ type Repository interface {
 	Client() Client
 
 	GetTreeChecksums() compiler.Checksums
+	GetTreeIndex() map[string]string
+	GetFiles() (map[string][]string, error)
+	GetReplaces() pkg.ReplacesIndex
 	GetTreeCompressionType() compiler.CompressionImplementation
 	SetTreeCompressionType(c compiler.CompressionImplementation)
 	SetTreeChecksums(c compiler.Checksums)
@@ -0,0 +1,129 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mudler/luet/pkg/compiler"
+	"github.com/mudler/luet/pkg/config"
+	"github.com/mudler/luet/pkg/helpers"
+	pkg "github.com/mudler/luet/pkg/package"
+	tree "github.com/mudler/luet/pkg/tree"
+
+	"github.com/pkg/errors"
+)
+
+// LocalRepositoryPriority is the priority of the repositories of local artifacts, which is higher than
+// the one of any configured repository, so local packages are preferred.
+const LocalRepositoryPriority = math.MinInt32
+
+// IsArtifactFile returns true if f is the path of a package artifact
+func IsArtifactFile(f string) bool {
+	return strings.Contains(filepath.Base(f), ".package.tar") && helpers.Exists(f)
+}
+
+// readArtifactMetadata reads the metadata written by PackageArtifact.WriteYaml for the artifact file f,
+// which is looked up next to it
+func readArtifactMetadata(f string) (compiler.Artifact, error) {
+	dir, name := filepath.Split(f)
+	candidates := []string{filepath.Join(dir, name[:strings.Index(name, ".package.tar")]+".metadata.yaml")}
+	others, _ := filepath.Glob(filepath.Join(dir, "*.metadata.yaml"))
+	candidates = append(candidates, others...)
+
+	for _, m := range candidates {
+		if !helpers.Exists(m) {
+			continue
+		}
+		data, err := ioutil.ReadFile(m)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading file "+m)
+		}
+		a, err := compiler.NewPackageArtifactFromYaml(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading yaml "+m)
+		}
+		if path.Base(a.GetPath()) == name && a.GetCompileSpec() != nil && a.GetCompileSpec().GetPackage() != nil {
+			return a, nil
+		}
+	}
+	return nil, errors.New("No metadata found for " + f)
+}
+
+// ArtifactsRepository generates in dst a disk repository with the given artifact files, which are linked
+// or copied there along with their metadata. The packages of the artifacts are returned.
+func ArtifactsRepository(name string, files []string, dst string) (Repository, []pkg.Package, error) {
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return nil, nil, err
+	}
+
+	var packages []pkg.Package
+	index := compiler.ArtifactIndex{}
+	db := pkg.NewInMemoryDatabase(false)
+	for _, f := range files {
+		a, err := readArtifactMetadata(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		p := a.GetCompileSpec().GetPackage()
+		if _, err := db.CreatePackage(p); err != nil {
+			return nil, nil, errors.Wrap(err, "Failed adding "+p.HumanReadableString())
+		}
+
+		target := filepath.Join(dst, filepath.Base(f))
+		if err := os.Link(f, target); err != nil {
+			if err := helpers.CopyFile(f, target); err != nil {
+				return nil, nil, errors.Wrap(err, "Failed copying "+f)
+			}
+		}
+		if err := a.WriteYaml(dst); err != nil {
+			return nil, nil, errors.Wrap(err, "Failed writing metadata of "+f)
+		}
+		index = append(index, a)
+		packages = append(packages, p)
+	}
+
+	repo := NewLuetSystemRepository(config.NewLuetRepository(name, "disk", "", []string{dst}, LocalRepositoryPriority, true, false),
+		index, tree.NewInstallerRecipe(db))
+	if err := repo.Write(dst, true); err != nil {
+		return nil, nil, errors.Wrap(err, "Failed writing repository")
+	}
+
+	return NewSystemRepository(config.LuetRepository{Name: name, Type: "disk", Urls: []string{dst}, Priority: LocalRepositoryPriority, Enable: true}), packages, nil
+}
+
+// DirRepository returns a disk repository for dir. If dir is not a repository, as the output of a build,
+// one is generated in dst with the artifacts found in dir. Both have the LocalRepositoryPriority.
+func DirRepository(name, dir, dst string) (Repository, error) {
+	if helpers.Exists(filepath.Join(dir, REPOSITORY_SPECFILE)) {
+		return NewSystemRepository(config.LuetRepository{Name: name, Type: "disk", Urls: []string{dir}, Priority: LocalRepositoryPriority, Enable: true}), nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.package.tar*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, errors.New("No repository or artifacts found in " + dir)
+	}
+	repo, _, err := ArtifactsRepository(name, matches, dst)
+	return repo, err
+}
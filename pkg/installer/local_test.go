@@ -0,0 +1,114 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/mudler/luet/pkg/installer"
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Local artifacts", func() {
+	var build, repoDir, tmp, fakeroot string
+	var repo Repository
+	var system *System
+
+	BeforeEach(func() {
+		build = tempDir("build")
+		tmp = tempDir("local")
+		system = newTestSystem()
+		fakeroot = system.Target
+
+		// y is available from a repository, x is freshly built and requires it
+		generated := newTestRepository("repo")
+		generated.Add(testPackage("y", "1.0"), map[string]string{"y": "y"})
+		repoDir = generated.Dir
+		repo = generated.Generate()
+		repo.SetPriority(1)
+
+		x := testPackage("x", "1.0")
+		x.PackageRequires = []*pkg.DefaultPackage{testPackage("y", ">=1.0")}
+		writeArtifact(build, x, map[string]string{"x": "x"})
+	})
+
+	It("Installs artifact files solving their requirements from repositories", func() {
+		artifact := filepath.Join(build, "x-test-1.0.package.tar")
+		Expect(IsArtifactFile(artifact)).To(BeTrue())
+		Expect(IsArtifactFile(filepath.Join(build, "x-test-1.0.metadata.yaml"))).To(BeFalse())
+
+		local, packages, err := ArtifactsRepository("local", []string{artifact}, tmp)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(packages)).To(Equal(1))
+		Expect(packages[0].GetFingerPrint()).To(Equal("x-test-1.0"))
+
+		inst := newTestInstaller(LuetInstallerOptions{Concurrency: 1}, repo, local)
+		Expect(inst.Install(packages, system)).ToNot(HaveOccurred())
+		Expect(helpers.Exists(filepath.Join(fakeroot, "x"))).To(BeTrue())
+		Expect(helpers.Exists(filepath.Join(fakeroot, "y"))).To(BeTrue())
+	})
+
+	It("Prefers local artifacts over configured repositories", func() {
+		generated := newTestRepository("other")
+		generated.Add(testPackage("x", "1.0"), map[string]string{"remote": "x"})
+		other := generated.Generate()
+
+		local, err := DirRepository("build", build, tmp)
+		Expect(err).ToNot(HaveOccurred())
+		synced, err := local.Sync(false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(synced.GetPriority()).To(Equal(LocalRepositoryPriority))
+
+		inst := newTestInstaller(LuetInstallerOptions{Concurrency: 1}, other, repo, local)
+		Expect(inst.Install([]pkg.Package{testPackage("x", ">=0")}, system)).ToNot(HaveOccurred())
+		Expect(helpers.Exists(filepath.Join(fakeroot, "x"))).To(BeTrue())
+		Expect(helpers.Exists(filepath.Join(fakeroot, "remote"))).To(BeFalse())
+
+		// Repositories of build outputs keep the priority as well
+		local, err = DirRepository("repo", repoDir, tmp)
+		Expect(err).ToNot(HaveOccurred())
+		synced, err = local.Sync(false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(synced.GetPriority()).To(Equal(LocalRepositoryPriority))
+	})
+
+	It("Fails without metadata", func() {
+		Expect(os.Remove(filepath.Join(build, "x-test-1.0.metadata.yaml"))).ToNot(HaveOccurred())
+		_, _, err := ArtifactsRepository("local", []string{filepath.Join(build, "x-test-1.0.package.tar")}, tmp)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Uses build outputs as repositories", func() {
+		local, err := DirRepository("build", build, tmp)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(local.GetUrls()).To(Equal([]string{tmp}))
+
+		inst := newTestInstaller(LuetInstallerOptions{Concurrency: 1}, repo, local)
+		Expect(inst.Install([]pkg.Package{testPackage("x", ">=0")}, system)).ToNot(HaveOccurred())
+		Expect(helpers.Exists(filepath.Join(fakeroot, "x"))).To(BeTrue())
+
+		local, err = DirRepository("repo", repoDir, tmp)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(local.GetUrls()).To(Equal([]string{repoDir}))
+
+		_, err = DirRepository("empty", fakeroot, tmp)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,262 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"io/ioutil"
+	"sort"
+
+	compiler "github.com/mudler/luet/pkg/compiler"
+	. "github.com/mudler/luet/pkg/logger"
+	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/mudler/luet/pkg/solver"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// Lockfile pins an exact set of packages, with the repositories and the artifacts to install them from
+type Lockfile struct {
+	Packages []LockedPackage `json:"packages"`
+}
+
+// LockedPackage is a package pinned in a Lockfile. Its artifact must match the checksums,
+// and its definition the slot.
+// The revision of the repository is recorded for reference only: repositories are
+// bumped for unrelated changes too, so only the checksums pin the artifact.
+type LockedPackage struct {
+	Category   string             `json:"category"`
+	Name       string             `json:"name"`
+	Version    string             `json:"version"`
+	Slot       string             `json:"slot,omitempty"`
+	Repository string             `json:"repository"`
+	Revision   int                `json:"revision"`
+	Checksums  compiler.Checksums `json:"checksums"`
+}
+
+func (lp LockedPackage) Package() *pkg.DefaultPackage {
+	return &pkg.DefaultPackage{Category: lp.Category, Name: lp.Name, Version: lp.Version, Slot: lp.Slot}
+}
+
+// LoadLockfile reads the lockfile in path
+func LoadLockfile(path string) (*Lockfile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed reading lockfile "+path)
+	}
+	lock := &Lockfile{}
+	if err := yaml.Unmarshal(data, lock); err != nil {
+		return nil, errors.Wrap(err, "Failed parsing lockfile "+path)
+	}
+	return lock, nil
+}
+
+// Write stores the lockfile in path
+func (f *Lockfile) Write(path string) error {
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		return errors.Wrap(err, "Failed encoding lockfile")
+	}
+	return errors.Wrap(ioutil.WriteFile(path, data, 0644), "Failed writing lockfile "+path)
+}
+
+// Lock returns the lockfile of the packages installed in the system, or of the solution
+// of the installation of p in the system, if any package is given.
+// The packages are sorted so that they come after their requirements.
+func (l *LuetInstaller) Lock(p []pkg.Package, s *System) (*Lockfile, error) {
+	syncedRepos, err := l.SyncRepositories(true)
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []pkg.Package
+	if len(p) == 0 {
+		packages = s.Database.World()
+	} else {
+		allRepos := pkg.NewInMemoryDatabase(false)
+		syncedRepos.SyncDatabase(allRepos)
+		solv := solver.NewResolver(s.Database, allRepos, pkg.NewInMemoryDatabase(false), l.Options.SolverOptions.Resolver())
+		solution, err := solv.Install(syncedRepos.ResolveSelectors(p))
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed solving solution for package")
+		}
+		for _, a := range solution {
+			if a.Value {
+				packages = append(packages, a.Package)
+			}
+		}
+	}
+
+	var assertions solver.PackagesAssertions
+	for _, p := range packages {
+		assertions = append(assertions, solver.PackageAssert{Package: p.(*pkg.DefaultPackage), Value: true})
+	}
+	// Match against an empty system, to get the artifacts of the installed packages too
+	matches, err := l.matchArtifacts(syncedRepos, assertions, &System{Database: pkg.NewInMemoryDatabase(false)}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &Lockfile{}
+	for _, p := range orderByRequires(packages) {
+		m, ok := matches[p.GetFingerPrint()]
+		if !ok {
+			return nil, errors.New("No artifact available for " + p.HumanReadableString())
+		}
+		lock.Packages = append(lock.Packages, LockedPackage{
+			Category:   p.GetCategory(),
+			Name:       p.GetName(),
+			Version:    p.GetVersion(),
+			Slot:       p.GetSlot(),
+			Repository: m.Repository.GetName(),
+			Revision:   m.Repository.GetRevision(),
+			Checksums:  m.Artifact.GetChecksums(),
+		})
+	}
+	return lock, nil
+}
+
+// orderByRequires sorts the packages by fingerprint, moving the requirements found among them before the packages requiring them
+func orderByRequires(packages []pkg.Package) []pkg.Package {
+	sorted := append([]pkg.Package{}, packages...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].GetFingerPrint() < sorted[j].GetFingerPrint() })
+
+	var ordered []pkg.Package
+	visited := map[string]bool{}
+	var visit func(p pkg.Package)
+	visit = func(p pkg.Package) {
+		if visited[p.GetFingerPrint()] {
+			return
+		}
+		visited[p.GetFingerPrint()] = true
+		for _, r := range p.GetRequires() {
+			for _, q := range sorted {
+				if q.GetPackageName() == r.GetPackageName() {
+					visit(q)
+				}
+			}
+		}
+		ordered = append(ordered, p)
+	}
+	for _, p := range sorted {
+		visit(p)
+	}
+	return ordered
+}
+
+// sameChecksums returns true if the checksums are exactly the locked ones
+func sameChecksums(locked, c compiler.Checksums) bool {
+	if len(locked) == 0 || len(locked) != len(c) {
+		return false
+	}
+	for t, sum := range locked {
+		if c[t] != sum {
+			return false
+		}
+	}
+	return true
+}
+
+// InstallLocked installs exactly the packages of the lockfile, without solving, in its order.
+// It fails if any package, or its artifact with the locked checksums, isn't available in its repository.
+func (l *LuetInstaller) InstallLocked(lock *Lockfile, s *System) error {
+	return l.transact(s, "install", func(t *transaction) error {
+		syncedRepos, err := l.SyncRepositories(true)
+		if err != nil {
+			return err
+		}
+
+		toInstall := map[string]ArtifactMatch{}
+		var p []pkg.Package
+		var keys []string
+		for _, lp := range lock.Packages {
+			m, err := lockedArtifact(syncedRepos, lp)
+			if err != nil {
+				return err
+			}
+			if _, err := s.Database.FindPackage(m.Package); err == nil {
+				Info(m.Package.HumanReadableString(), "is already installed")
+				continue
+			}
+			if vers, _ := s.Database.FindPackageVersions(m.Package); len(vers) > 0 {
+				return errors.New("Other versions of " + m.Package.HumanReadableString() + " are installed in the same slot")
+			}
+			toInstall[m.Package.GetFingerPrint()] = m
+			p = append(p, m.Package)
+			keys = append(keys, m.Package.GetFingerPrint())
+		}
+		if len(p) == 0 {
+			Info("Nothing to install")
+			return nil
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			t.Add(toInstall[k].Package)
+		}
+
+		// The downloaded artifacts are verified against the checksums of the repositories, which match the locked ones
+		toInstall, err = l.download(toInstall)
+		if err != nil {
+			return errors.Wrap(err, "Failed downloading packages")
+		}
+		if err := l.unpack(toInstall, keys, s, t); err != nil {
+			return err
+		}
+
+		executedFinalizer := map[string]bool{}
+		for _, pack := range p {
+			if _, err := s.Database.CreatePackage(pack); err != nil {
+				return errors.Wrap(err, "Failed creating package")
+			}
+			if err := l.runFinalizer(toInstall[pack.GetFingerPrint()], s, executedFinalizer); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// lockedArtifact returns the artifact of the locked package in its repository.
+// A different revision of the repository is accepted, as long as the slot and the checksums match.
+func lockedArtifact(syncedRepos Repositories, lp LockedPackage) (ArtifactMatch, error) {
+	p := lp.Package()
+	for _, r := range syncedRepos {
+		if r.GetName() != lp.Repository {
+			continue
+		}
+		if r.GetRevision() != lp.Revision {
+			Debug("Repository", r.GetName(), "is at revision", r.GetRevision(), "instead of", lp.Revision)
+		}
+		def, err := r.GetTree().GetDatabase().FindPackage(p)
+		if err != nil {
+			return ArtifactMatch{}, errors.New("Locked package " + p.HumanReadableString() + " is not available in repository " + lp.Repository)
+		}
+		if def.GetSlot() != lp.Slot {
+			return ArtifactMatch{}, errors.New("Locked package " + p.HumanReadableString() + " is in slot '" + def.GetSlot() + "' instead of '" + lp.Slot + "' in repository " + lp.Repository)
+		}
+		for _, a := range r.GetIndex() {
+			if a.GetCompileSpec().GetPackage().GetFingerPrint() != p.GetFingerPrint() {
+				continue
+			}
+			if !sameChecksums(lp.Checksums, a.GetChecksums()) {
+				return ArtifactMatch{}, errors.New("Checksum mismatch for the artifact of locked package " + p.HumanReadableString())
+			}
+			return ArtifactMatch{Package: def, Artifact: a, Repository: r}, nil
+		}
+		return ArtifactMatch{}, errors.New("No artifact available for locked package " + p.HumanReadableString() + " in repository " + lp.Repository)
+	}
+	return ArtifactMatch{}, errors.New("Repository " + lp.Repository + " of locked package " + p.HumanReadableString() + " is not available")
+}
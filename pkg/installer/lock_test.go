@@ -0,0 +1,125 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/mudler/luet/pkg/installer"
+	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/mudler/luet/pkg/tree"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lockfile", func() {
+	var fakeroot string
+	var repo *testRepository
+	var inst Installer
+	var system *System
+
+	BeforeEach(func() {
+		repo = newTestRepository("lock")
+		app := testPackage("app", "1.0")
+		app.PackageRequires = []*pkg.DefaultPackage{testPackage("lib", ">=1.0")}
+		for _, p := range []*pkg.DefaultPackage{testPackage("lib", "1.0"), app} {
+			repo.Add(p, map[string]string{p.GetName(): "1.0"})
+		}
+
+		inst = newTestInstaller(LuetInstallerOptions{Concurrency: 1}, repo.Generate())
+		system = newTestSystem()
+		fakeroot = system.Target
+	})
+
+	It("Locks an install request and installs it", func() {
+		lock, err := inst.Lock([]pkg.Package{testPackage("app", ">=0")}, system)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(lock.Packages)).To(Equal(2))
+		Expect(lock.Packages[0].Package()).To(Equal(testPackage("lib", "1.0")))
+		Expect(lock.Packages[1].Name).To(Equal("app"))
+		Expect(lock.Packages[1].Repository).To(Equal("lock"))
+		Expect(lock.Packages[1].Revision).To(Equal(1))
+		Expect(lock.Packages[1].Checksums).To(HaveKey("sha256"))
+
+		path := filepath.Join(fakeroot, "luet.lock")
+		Expect(lock.Write(path)).ToNot(HaveOccurred())
+		loaded, err := LoadLockfile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(loaded).To(Equal(lock))
+
+		Expect(inst.InstallLocked(loaded, system)).ToNot(HaveOccurred())
+		Expect(helpers.Read(filepath.Join(fakeroot, "app"))).To(Equal("1.0"))
+		Expect(helpers.Read(filepath.Join(fakeroot, "lib"))).To(Equal("1.0"))
+		Expect(len(system.Database.World())).To(Equal(2))
+
+		// The lockfile of the system is the one it was installed from
+		installed, err := inst.Lock(nil, system)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(installed).To(Equal(lock))
+
+		// Installing it again is a no-op
+		Expect(inst.InstallLocked(loaded, system)).ToNot(HaveOccurred())
+		history, err := system.Database.GetTransactions()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(history)).To(Equal(1))
+	})
+
+	It("Fails if a checksum differs", func() {
+		lock, err := inst.Lock([]pkg.Package{testPackage("app", ">=0")}, system)
+		Expect(err).ToNot(HaveOccurred())
+		lock.Packages[0].Checksums["sha256"] = "0000"
+
+		Expect(inst.InstallLocked(lock, system)).To(HaveOccurred())
+		Expect(helpers.Exists(filepath.Join(fakeroot, "app"))).To(BeFalse())
+		Expect(system.Database.World()).To(BeEmpty())
+	})
+
+	It("Records the slot and fails if it differs", func() {
+		lib := testPackage("lib", "1.0")
+		Expect(os.RemoveAll(tree.DefinitionDir(repo.Tree, lib))).ToNot(HaveOccurred())
+		lib.Slot = "1"
+		repo.Add(lib, map[string]string{"lib": "1.0"})
+		repo.Generate()
+
+		lock, err := inst.Lock([]pkg.Package{testPackage("app", ">=0")}, system)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(lock.Packages[0].Package()).To(Equal(&pkg.DefaultPackage{Name: "lib", Category: "test", Version: "1.0", Slot: "1"}))
+		Expect(lock.Packages[1].Slot).To(BeEmpty())
+
+		lock.Packages[0].Slot = "2"
+		Expect(inst.InstallLocked(lock, system)).To(HaveOccurred())
+		Expect(system.Database.World()).To(BeEmpty())
+	})
+
+	It("Accepts a different revision of the repository", func() {
+		lock, err := inst.Lock([]pkg.Package{testPackage("app", ">=0")}, system)
+		Expect(err).ToNot(HaveOccurred())
+
+		synced, err := repo.Generate().Sync(false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(synced.GetRevision()).ToNot(Equal(lock.Packages[0].Revision))
+
+		Expect(inst.InstallLocked(lock, system)).ToNot(HaveOccurred())
+		Expect(len(system.Database.World())).To(Equal(2))
+	})
+
+	It("Fails if a repository isn't available", func() {
+		lock := &Lockfile{Packages: []LockedPackage{{Category: "test", Name: "app", Version: "1.0", Repository: "missing"}}}
+		Expect(inst.InstallLocked(lock, system)).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,185 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mudler/luet/pkg/compiler"
+	"github.com/mudler/luet/pkg/config"
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/mudler/luet/pkg/logger"
+	pkg "github.com/mudler/luet/pkg/package"
+
+	"github.com/pkg/errors"
+)
+
+// Mirror creates in dst a self-contained disk repository with the content of r.
+// If packages are given, only them (picking the best candidate of selectors) and their
+// requirements are mirrored. Artifacts already present in dst and matching their checksums are not
+// downloaded again, and the ones which are not part of the mirror anymore are removed.
+func Mirror(r Repository, dst string, packages []pkg.Package) (Repository, error) {
+	err := os.MkdirAll(dst, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	synced, err := r.Sync(false)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed syncing repository "+r.GetName())
+	}
+	db := synced.GetTree().GetDatabase()
+
+	if len(packages) > 0 {
+		wanted, err := requirementsClosure(db, packages)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range db.World() {
+			if _, ok := wanted[p.GetFingerPrint()]; !ok {
+				if err := db.RemovePackage(p); err != nil {
+					return nil, errors.Wrap(err, "Failed filtering "+p.HumanReadableString())
+				}
+			}
+		}
+	}
+
+	c := synced.Client()
+	if c == nil {
+		return nil, errors.New("No client could be generated from repository " + r.GetName())
+	}
+
+	index := compiler.ArtifactIndex{}
+	keep := map[string]bool{}
+	for _, a := range synced.GetIndex() {
+		if a.GetCompileSpec() == nil || a.GetCompileSpec().GetPackage() == nil {
+			continue
+		}
+		if _, err := db.FindPackage(a.GetCompileSpec().GetPackage()); err != nil {
+			continue
+		}
+
+		art, err := mirrorArtifact(c, a, dst)
+		if err != nil {
+			return nil, err
+		}
+		index = append(index, art)
+		keep[path.Base(art.GetPath())] = true
+		keep[art.GetCompileSpec().GetPackage().GetFingerPrint()+".metadata.yaml"] = true
+	}
+
+	// Remove the artifacts which are not part of the mirror anymore
+	files, err := ioutil.ReadDir(dst)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if keep[f.Name()] || !(strings.Contains(f.Name(), ".package.tar") || strings.HasSuffix(f.Name(), ".metadata.yaml")) {
+			continue
+		}
+		Info("Removing", f.Name(), "from the mirror")
+		if err := os.Remove(filepath.Join(dst, f.Name())); err != nil {
+			return nil, err
+		}
+	}
+
+	repo := NewLuetSystemRepository(
+		config.NewLuetRepository(synced.GetName(), "disk", synced.GetDescription(), []string{dst}, synced.GetPriority(), true, false),
+		index, synced.GetTree())
+	repo.SetTreeCompressionType(synced.GetTreeCompressionType())
+	repo.(*LuetSystemRepository).Files, err = buildFilesIndex(index, dst)
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.Write(dst, false); err != nil {
+		return nil, errors.Wrap(err, "Failed writing the mirror")
+	}
+	return repo, nil
+}
+
+// mirrorArtifact makes the artifact a available in dst, downloading it only if it is missing
+// or it doesn't match its checksums. It returns the artifact as stored in dst.
+func mirrorArtifact(c Client, a compiler.Artifact, dst string) (compiler.Artifact, error) {
+	name := path.Base(a.GetPath())
+	orig, ok := a.(*compiler.PackageArtifact)
+	if !ok {
+		return nil, errors.New("Unsupported artifact " + name)
+	}
+
+	local := *orig
+	local.Path = filepath.Join(dst, name)
+	if helpers.Exists(local.Path) && local.Verify() == nil {
+		Debug("Artifact", name, "is already up to date")
+	} else {
+		remote := *orig
+		remote.Path = name
+		downloaded, err := c.DownloadArtifact(&remote)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed downloading "+name)
+		}
+		if err := downloaded.Verify(); err != nil {
+			os.Remove(downloaded.GetPath())
+			return nil, errors.Wrap(err, "Artifact integrity check failure for "+name)
+		}
+		if err := helpers.CopyFile(downloaded.GetPath(), local.Path); err != nil {
+			return nil, errors.Wrap(err, "Failed copying "+name)
+		}
+		Info("Mirrored", name)
+	}
+
+	if err := local.WriteYaml(dst); err != nil {
+		return nil, err
+	}
+	return &local, nil
+}
+
+// requirementsClosure returns the fingerprints of the best candidates of packages,
+// along with the ones of all the packages which could satisfy their requirements
+func requirementsClosure(db pkg.PackageDatabase, packages []pkg.Package) (map[string]pkg.Package, error) {
+	res := map[string]pkg.Package{}
+
+	var queue []pkg.Package
+	for _, p := range packages {
+		c, err := db.FindPackageCandidate(p)
+		if err != nil {
+			return nil, errors.Wrap(err, "Package "+p.HumanReadableString()+" not found")
+		}
+		queue = append(queue, c)
+	}
+
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if _, ok := res[p.GetFingerPrint()]; ok {
+			continue
+		}
+		res[p.GetFingerPrint()] = p
+
+		for _, r := range p.GetRequires() {
+			if def, err := db.FindPackage(r); err == nil {
+				queue = append(queue, def)
+			}
+			if packages, err := db.FindPackages(r); err == nil {
+				queue = append(queue, packages...)
+			}
+		}
+	}
+	return res, nil
+}
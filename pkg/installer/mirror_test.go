@@ -0,0 +1,95 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	config "github.com/mudler/luet/pkg/config"
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/mudler/luet/pkg/installer"
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Mirror", func() {
+	var src, dst string
+	var source Repository
+
+	BeforeEach(func() {
+		repo := newTestRepository("source")
+		src = repo.Dir
+		dst = tempDir("mirror")
+
+		x := testPackage("x", "1.0")
+		x.PackageRequires = []*pkg.DefaultPackage{testPackage("y", ">=1.0")}
+		repo.Add(x, map[string]string{"x": "x"})
+		for _, name := range []string{"y", "z"} {
+			repo.Add(testPackage(name, "1.0"), map[string]string{name: name})
+		}
+		source = repo.Generate()
+	})
+
+	It("Creates a disk repository with all the artifacts", func() {
+		mirror, err := Mirror(source, dst, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mirror.GetType()).To(Equal("disk"))
+		Expect(len(mirror.GetIndex())).To(Equal(3))
+		for _, name := range []string{"x", "y", "z"} {
+			Expect(helpers.Exists(filepath.Join(dst, name+"-test-1.0.package.tar"))).To(BeTrue())
+		}
+
+		spec, err := mirror.(*LuetSystemRepository).ReadSpecFile(filepath.Join(dst, REPOSITORY_SPECFILE), false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(spec.GetType()).To(Equal("disk"))
+		Expect(spec.GetUrls()).To(Equal([]string{dst}))
+
+		// Artifacts already mirrored are not downloaded again
+		Expect(os.Remove(filepath.Join(src, "x-test-1.0.package.tar"))).ToNot(HaveOccurred())
+		_, err = Mirror(source, dst, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		inst := newTestInstaller(LuetInstallerOptions{Concurrency: 1},
+			NewSystemRepository(config.LuetRepository{Name: "mirror", Type: "disk", Urls: []string{dst}}))
+		system := newTestSystem()
+		Expect(inst.Install([]pkg.Package{testPackage("x", "1.0")}, system)).ToNot(HaveOccurred())
+		Expect(helpers.Exists(filepath.Join(system.Target, "x"))).To(BeTrue())
+		Expect(helpers.Exists(filepath.Join(system.Target, "y"))).To(BeTrue())
+	})
+
+	It("Mirrors only the given packages and their requirements", func() {
+		_, err := Mirror(source, dst, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		mirror, err := Mirror(source, dst, []pkg.Package{testPackage("x", ">=0")})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(mirror.GetIndex())).To(Equal(2))
+		Expect(len(mirror.GetTree().GetDatabase().World())).To(Equal(2))
+		Expect(helpers.Exists(filepath.Join(dst, "x-test-1.0.package.tar"))).To(BeTrue())
+		Expect(helpers.Exists(filepath.Join(dst, "y-test-1.0.package.tar"))).To(BeTrue())
+		Expect(helpers.Exists(filepath.Join(dst, "z-test-1.0.package.tar"))).To(BeFalse())
+		Expect(helpers.Exists(filepath.Join(dst, "z-test-1.0.metadata.yaml"))).To(BeFalse())
+	})
+
+	It("Fails on corrupted artifacts", func() {
+		Expect(ioutil.WriteFile(filepath.Join(src, "z-test-1.0.package.tar"), []byte("corrupted"), os.ModePerm)).ToNot(HaveOccurred())
+		_, err := Mirror(source, dst, nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
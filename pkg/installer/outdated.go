@@ -0,0 +1,77 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"sort"
+
+	pkg "github.com/mudler/luet/pkg/package"
+)
+
+// Update is an installed package with a newer version available in a repository
+type Update struct {
+	Installed pkg.Package
+	PackageMatch
+}
+
+// Outdated returns the installed packages which have a newer version, or a replacement, available
+// in the repositories. As for upgrades, versions are compared within the same slot and the
+// packages declaring to replace an installed one take precedence.
+func (re Repositories) Outdated(installed []pkg.Package) []Update {
+	sort.Sort(re)
+	var updates []Update
+
+	for _, p := range installed {
+		var candidates []PackageMatch
+		replacements := re.ReplacedBy(p)
+		if len(replacements) != 0 {
+			candidates = replacements
+		} else {
+			for _, r := range re {
+				for _, pack := range r.GetTree().GetDatabase().World() {
+					if pack.GetPackageName() == p.GetPackageName() && pack.GetSlot() == p.GetSlot() {
+						candidates = append(candidates, PackageMatch{Package: pack, Repo: r})
+					}
+				}
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+
+		var packages []pkg.Package
+		for _, c := range candidates {
+			packages = append(packages, c.Package)
+		}
+		best := pkg.Best(packages)
+		if len(replacements) == 0 && (best.GetVersion() == p.GetVersion() || !best.Bigger(p)) {
+			continue
+		}
+
+		// Repositories are sorted by priority, so the first one shipping it wins
+		for _, c := range candidates {
+			if c.Package.Matches(best) {
+				updates = append(updates, Update{Installed: p, PackageMatch: c})
+				break
+			}
+		}
+	}
+
+	sort.Slice(updates, func(i, j int) bool {
+		return updates[i].Installed.GetFingerPrint() < updates[j].Installed.GetFingerPrint()
+	})
+	return updates
+}
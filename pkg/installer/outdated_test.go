@@ -0,0 +1,84 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/mudler/luet/pkg/installer"
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Outdated packages", func() {
+	var fakeroot string
+	var inst Installer
+	var repos Repositories
+	var system *System
+
+	BeforeEach(func() {
+		repo := newTestRepository("outdated")
+		// lib 1.1 is required by app 1.1, tool is independent
+		app := testPackage("app", "1.1")
+		app.PackageRequires = []*pkg.DefaultPackage{testPackage("lib", ">=1.1")}
+		for _, p := range []*pkg.DefaultPackage{
+			testPackage("app", "1.0"), app,
+			testPackage("lib", "1.0"), testPackage("lib", "1.1"),
+			testPackage("tool", "1.0"), testPackage("tool", "2.0"),
+		} {
+			repo.Add(p, map[string]string{"outdated-" + p.GetName(): p.GetVersion()})
+		}
+
+		inst = newTestInstaller(LuetInstallerOptions{Concurrency: 1}, repo.Generate())
+		system = newTestSystem()
+		fakeroot = system.Target
+		Expect(inst.Install([]pkg.Package{
+			testPackage("app", "1.0"),
+			testPackage("lib", "1.0"),
+			testPackage("tool", "1.0"),
+		}, system)).ToNot(HaveOccurred())
+
+		var err error
+		repos, err = inst.SyncRepositories(false)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("Lists the installed packages with newer versions", func() {
+		updates := repos.Outdated(system.Database.World())
+		Expect(len(updates)).To(Equal(3))
+		Expect(updates[0].Installed.GetFingerPrint()).To(Equal("app-test-1.0"))
+		Expect(updates[0].Package.GetFingerPrint()).To(Equal("app-test-1.1"))
+		Expect(updates[0].Repo.GetName()).To(Equal("outdated"))
+		Expect(updates[2].Package.GetFingerPrint()).To(Equal("tool-test-2.0"))
+	})
+
+	It("Upgrades only the given packages and their requirements", func() {
+		Expect(inst.UpgradePackages([]pkg.Package{&pkg.DefaultPackage{Name: "app", Category: "test"}}, system)).ToNot(HaveOccurred())
+
+		Expect(helpers.Read(filepath.Join(fakeroot, "outdated-app"))).To(Equal("1.1"))
+		Expect(helpers.Read(filepath.Join(fakeroot, "outdated-lib"))).To(Equal("1.1"))
+		Expect(helpers.Read(filepath.Join(fakeroot, "outdated-tool"))).To(Equal("1.0"))
+		Expect(len(system.Database.World())).To(Equal(3))
+
+		updates := repos.Outdated(system.Database.World())
+		Expect(len(updates)).To(Equal(1))
+		Expect(updates[0].Installed.GetFingerPrint()).To(Equal("tool-test-1.0"))
+
+		Expect(inst.UpgradePackages([]pkg.Package{&pkg.DefaultPackage{Name: "missing", Category: "test"}}, system)).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,225 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/compiler"
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/mudler/luet/pkg/logger"
+	pkg "github.com/mudler/luet/pkg/package"
+
+	"github.com/pkg/errors"
+)
+
+// Promote copies the best candidates of packages, along with their artifacts, from the repository from
+// to the repository to, and publishes a new revision of it.
+// disk repositories are updated in place: the artifacts are copied first and the tree and repository.yaml
+// are replaced with renames, so clients see either the old or the new revision.
+// docker repositories are updated by pushing the new artifacts and the new metadata.
+func Promote(from, to Repository, packages []pkg.Package) (Repository, error) {
+	source, err := from.Sync(false)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed syncing repository "+from.GetName())
+	}
+	synced, err := to.Sync(false)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed syncing repository "+to.GetName())
+	}
+	target, ok := synced.(*LuetSystemRepository)
+	if !ok {
+		return nil, errors.New("Unsupported repository " + to.GetName())
+	}
+
+	sourceClient := source.Client()
+	if sourceClient == nil {
+		return nil, errors.New("No client could be generated from repository " + from.GetName())
+	}
+	targetClient := target.Client()
+
+	staging, err := ioutil.TempDir(os.TempDir(), "promote")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error met while creating tempdir for promotion")
+	}
+	defer os.RemoveAll(staging)
+
+	// The files index of disk repositories is kept up to date
+	if to.GetType() == "disk" && len(to.GetUrls()) > 0 {
+		if err := target.loadFilesIndex(to.GetUrls()[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	var promoted []compiler.Artifact
+	for _, p := range packages {
+		candidate, err := source.GetTree().GetDatabase().FindPackageCandidate(p)
+		if err != nil {
+			return nil, errors.Wrap(err, "Package "+p.HumanReadableString()+" not found in "+from.GetName())
+		}
+		artifact := findArtifact(source.GetIndex(), candidate)
+		if artifact == nil {
+			return nil, errors.New("No artifact for " + candidate.HumanReadableString() + " in " + from.GetName())
+		}
+
+		local, err := mirrorArtifact(sourceClient, artifact, staging)
+		if err != nil {
+			return nil, err
+		}
+		promoted = append(promoted, local)
+		if target.Files != nil {
+			target.Files[candidate.GetFingerPrint()], err = artifactFiles(local, staging)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Replace the definition and the artifact of the same package, if any
+		target.GetTree().GetDatabase().RemovePackage(candidate)
+		if _, err := target.GetTree().GetDatabase().CreatePackage(candidate); err != nil {
+			return nil, errors.Wrap(err, "Failed adding "+candidate.HumanReadableString())
+		}
+		index := compiler.ArtifactIndex{}
+		for _, a := range target.GetIndex() {
+			if a.GetCompileSpec() == nil || a.GetCompileSpec().GetPackage() == nil ||
+				!a.GetCompileSpec().GetPackage().Matches(candidate) {
+				index = append(index, a)
+			}
+		}
+		target.Index = append(index, local)
+		Info("Promoting", candidate.HumanReadableString(), "from", from.GetName(), "to", to.GetName())
+	}
+
+	for _, p := range promoted {
+		for _, r := range p.GetCompileSpec().GetPackage().GetRequires() {
+			if _, err := target.GetTree().GetDatabase().FindPackage(r); err != nil {
+				if packages, err := target.GetTree().GetDatabase().FindPackages(r); err != nil || len(packages) == 0 {
+					Warning(p.GetCompileSpec().GetPackage().HumanReadableString(), "requires", r.HumanReadableString(), "which is missing in", to.GetName())
+				}
+			}
+		}
+	}
+
+	// Tree path and credentials are set by Sync for the local use, they must not be published
+	target.SetTreePath("")
+	target.SetAuthentication(nil)
+
+	switch to.GetType() {
+	case "disk":
+		if len(to.GetUrls()) == 0 {
+			return nil, errors.New("Repository " + to.GetName() + " has no urls")
+		}
+		err = promoteToDir(target, staging, promoted, to.GetUrls()[0])
+	case "docker", "oci":
+		uploader, ok := targetClient.(Uploader)
+		if !ok {
+			return nil, errors.New("Repository type " + to.GetType() + " doesn't support pushing")
+		}
+		err = target.Write(staging, false)
+		if err == nil {
+			err = pushArtifacts(uploader, staging, promoted)
+		}
+		if err == nil {
+			err = target.pushMetadata(uploader, staging)
+		}
+	default:
+		return nil, errors.New("Promotion to " + to.GetType() + " repositories is not supported")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed promoting to "+to.GetName())
+	}
+
+	target.SetAuthentication(to.GetAuthentication())
+	return target, nil
+}
+
+// findArtifact returns the artifact of p in the index
+func findArtifact(index compiler.ArtifactIndex, p pkg.Package) compiler.Artifact {
+	for _, a := range index {
+		if a.GetCompileSpec() != nil && a.GetCompileSpec().GetPackage() != nil &&
+			a.GetCompileSpec().GetPackage().Matches(p) {
+			return a
+		}
+	}
+	return nil
+}
+
+// promoteToDir updates the disk repository in dst with repo, whose promoted artifacts are in staging
+func promoteToDir(repo *LuetSystemRepository, staging string, promoted []compiler.Artifact, dst string) error {
+	for _, a := range promoted {
+		name := path.Base(a.GetPath())
+		for _, f := range []string{name, a.GetCompileSpec().GetPackage().GetFingerPrint() + ".metadata.yaml"} {
+			if err := atomicCopy(filepath.Join(staging, f), filepath.Join(dst, f)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return publishDir(repo, dst)
+}
+
+// publishDir writes repo to the disk repository in dst. The new tree files are added first,
+// then the tree and repository.yaml are replaced with renames, so clients see either the old or the new revision.
+func publishDir(repo *LuetSystemRepository, dst string) error {
+	tmp, err := ioutil.TempDir(dst, ".publish")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	// Write bumps the revision of the repository, as there is no spec in tmp yet
+	if err := repo.Write(tmp, false); err != nil {
+		return err
+	}
+
+	// Tree files are named after their content, adding the new ones doesn't affect the current revision
+	if err := os.MkdirAll(filepath.Join(dst, TREE_FILES_DIR), os.ModePerm); err != nil {
+		return err
+	}
+	for _, sum := range repo.GetTreeIndex() {
+		if helpers.Exists(filepath.Join(dst, TREE_FILES_DIR, sum)) {
+			continue
+		}
+		if err := atomicCopy(filepath.Join(tmp, TREE_FILES_DIR, sum), filepath.Join(dst, TREE_FILES_DIR, sum)); err != nil {
+			return err
+		}
+	}
+
+	files := []string{repo.GetTreePath(), REPOSITORY_SPECFILE}
+	if repo.FilesIndex != "" && helpers.Exists(filepath.Join(tmp, repo.FilesIndex)) {
+		files = []string{repo.GetTreePath(), repo.FilesIndex, REPOSITORY_SPECFILE}
+	}
+	for _, f := range files {
+		if err := os.Rename(filepath.Join(tmp, f), filepath.Join(dst, f)); err != nil {
+			return err
+		}
+	}
+
+	// Drop the tree files of the previous revision
+	return writeTreeFiles(tmp, filepath.Join(dst, TREE_FILES_DIR), repo.GetTreeIndex())
+}
+
+// atomicCopy copies src to dst through a temporary file in the same directory of dst
+func atomicCopy(src, dst string) error {
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp")
+	if err := helpers.CopyFile(src, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
@@ -0,0 +1,87 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/mudler/luet/pkg/installer"
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Promote", func() {
+	var stableDir string
+	var staging, stable Repository
+
+	BeforeEach(func() {
+		x := testPackage("x", "1.0")
+		x.PackageRequires = []*pkg.DefaultPackage{testPackage("y", ">=1.0")}
+		y := testPackage("y", "1.0")
+
+		stagingRepo := newTestRepository("staging")
+		stagingRepo.Add(x, map[string]string{"x": "x"})
+		stagingRepo.Add(y, map[string]string{"y": "y"})
+		staging = stagingRepo.Generate()
+
+		stableRepo := newTestRepository("stable")
+		stableRepo.Add(y, map[string]string{"y": "y"})
+		stableDir = stableRepo.Dir
+		stable = stableRepo.Generate()
+	})
+
+	It("Copies packages to the target repository", func() {
+		promoted, err := Promote(staging, stable, []pkg.Package{testPackage("x", ">=0")})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(promoted.GetRevision()).To(Equal(2))
+		Expect(len(promoted.GetIndex())).To(Equal(2))
+		Expect(helpers.Exists(filepath.Join(stableDir, "x-test-1.0.package.tar"))).To(BeTrue())
+		Expect(helpers.Exists(filepath.Join(stableDir, "x-test-1.0.metadata.yaml"))).To(BeTrue())
+
+		synced, err := stable.Sync(false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(synced.GetRevision()).To(Equal(2))
+		Expect(synced.GetTreePath()).ToNot(Equal(""))
+		_, err = synced.GetTree().GetDatabase().FindPackage(testPackage("x", "1.0"))
+		Expect(err).ToNot(HaveOccurred())
+		index, err := synced.GetFiles()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(index).To(HaveKeyWithValue("x-test-1.0", []string{"/x"}))
+
+		files, err := ioutil.ReadDir(stableDir)
+		Expect(err).ToNot(HaveOccurred())
+		for _, f := range files {
+			Expect(f.Name()).ToNot(HavePrefix("."))
+		}
+
+		inst := newTestInstaller(LuetInstallerOptions{Concurrency: 1}, stable)
+		system := newTestSystem()
+		Expect(inst.Install([]pkg.Package{testPackage("x", "1.0")}, system)).ToNot(HaveOccurred())
+		Expect(helpers.Exists(filepath.Join(system.Target, "x"))).To(BeTrue())
+	})
+
+	It("Leaves the target untouched on failures", func() {
+		_, err := Promote(staging, stable, []pkg.Package{testPackage("missing", ">=0")})
+		Expect(err).To(HaveOccurred())
+
+		synced, err := stable.Sync(false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(synced.GetRevision()).To(Equal(1))
+	})
+})
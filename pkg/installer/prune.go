@@ -0,0 +1,168 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/mudler/luet/pkg/compiler"
+	. "github.com/mudler/luet/pkg/logger"
+	pkg "github.com/mudler/luet/pkg/package"
+
+	"github.com/pkg/errors"
+)
+
+// PrunePolicy defines which artifacts of a repository are retained.
+// An artifact is kept if it matches any of the enabled rules.
+type PrunePolicy struct {
+	// KeepVersions keeps the last N versions of each package, in each slot. 0 disables it
+	KeepVersions int
+	// KeepNewerThan keeps the artifacts built after the given time, as recorded in their metadata.
+	// Artifacts without a build time are always kept. The zero time disables it
+	KeepNewerThan time.Time
+	// KeepDependencies keeps also the packages which can satisfy the requirements of the kept ones
+	KeepDependencies bool
+}
+
+// Prune removes from the disk repository r the artifacts which are not retained by the policy,
+// along with their metadata and definitions, and publishes a new revision of it.
+// With dryRun nothing is removed. The pruned artifacts are returned in both cases.
+func Prune(r Repository, policy PrunePolicy, dryRun bool) ([]compiler.Artifact, error) {
+	if policy.KeepVersions <= 0 && policy.KeepNewerThan.IsZero() {
+		return nil, errors.New("No retention policy given")
+	}
+	if r.GetType() != "disk" || len(r.GetUrls()) == 0 {
+		return nil, errors.New("Only disk repositories can be pruned")
+	}
+	dir := r.GetUrls()[0]
+
+	synced, err := r.Sync(false)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed syncing repository "+r.GetName())
+	}
+	repo, ok := synced.(*LuetSystemRepository)
+	if !ok {
+		return nil, errors.New("Unsupported repository " + r.GetName())
+	}
+	db := repo.GetTree().GetDatabase()
+
+	kept := map[string]pkg.Package{}
+	groups := map[string][]pkg.Package{}
+	for _, a := range repo.GetIndex() {
+		if a.GetCompileSpec() == nil || a.GetCompileSpec().GetPackage() == nil {
+			continue
+		}
+		p := a.GetCompileSpec().GetPackage()
+		groups[p.GetPackageName()+":"+p.GetSlot()] = append(groups[p.GetPackageName()+":"+p.GetSlot()], p)
+
+		if !policy.KeepNewerThan.IsZero() {
+			// The file times aren't reliable, as copying artifacts between repositories resets them
+			built := a.GetBuildTime()
+			if built.IsZero() {
+				Warning("No build time recorded for", p.HumanReadableString()+", keeping it")
+				kept[p.GetFingerPrint()] = p
+			} else if built.After(policy.KeepNewerThan) {
+				kept[p.GetFingerPrint()] = p
+			}
+		}
+	}
+
+	if policy.KeepVersions > 0 {
+		for _, versions := range groups {
+			for i := 0; i < policy.KeepVersions && len(versions) > 0; i++ {
+				best := pkg.Best(versions)
+				kept[best.GetFingerPrint()] = best
+				var rest []pkg.Package
+				for _, v := range versions {
+					if v.GetFingerPrint() != best.GetFingerPrint() {
+						rest = append(rest, v)
+					}
+				}
+				versions = rest
+			}
+		}
+	}
+
+	if policy.KeepDependencies {
+		var roots []pkg.Package
+		for _, p := range kept {
+			roots = append(roots, p)
+		}
+		deps, err := requirementsClosure(db, roots)
+		if err != nil {
+			return nil, err
+		}
+		for k, p := range deps {
+			kept[k] = p
+		}
+	}
+
+	var pruned []compiler.Artifact
+	index := compiler.ArtifactIndex{}
+	for _, a := range repo.GetIndex() {
+		if a.GetCompileSpec() != nil && a.GetCompileSpec().GetPackage() != nil {
+			if _, ok := kept[a.GetCompileSpec().GetPackage().GetFingerPrint()]; !ok {
+				pruned = append(pruned, a)
+				continue
+			}
+		}
+		index = append(index, a)
+	}
+	sort.SliceStable(pruned, func(i, j int) bool {
+		return pruned[i].GetCompileSpec().GetPackage().GetFingerPrint() < pruned[j].GetCompileSpec().GetPackage().GetFingerPrint()
+	})
+
+	if dryRun || len(pruned) == 0 {
+		return pruned, nil
+	}
+
+	if err := repo.loadFilesIndex(dir); err != nil {
+		return nil, err
+	}
+	for _, a := range pruned {
+		p := a.GetCompileSpec().GetPackage()
+		if err := db.RemovePackage(p); err != nil {
+			return nil, errors.Wrap(err, "Failed removing "+p.HumanReadableString())
+		}
+		if repo.Files != nil {
+			delete(repo.Files, p.GetFingerPrint())
+		}
+	}
+
+	// Publish the new revision before removing the files, so clients never see missing artifacts
+	repo.Index = index
+	repo.SetTreePath("")
+	repo.SetAuthentication(nil)
+	if err := publishDir(repo, dir); err != nil {
+		return nil, errors.Wrap(err, "Failed writing repository")
+	}
+
+	for _, a := range pruned {
+		p := a.GetCompileSpec().GetPackage()
+		for _, f := range []string{path.Base(a.GetPath()), p.GetFingerPrint() + ".metadata.yaml"} {
+			if err := os.Remove(filepath.Join(dir, f)); err != nil && !os.IsNotExist(err) {
+				return nil, errors.Wrap(err, "Failed removing "+f)
+			}
+		}
+		Info("Pruned", p.HumanReadableString())
+	}
+
+	return pruned, nil
+}
@@ -0,0 +1,126 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mudler/luet/pkg/compiler"
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/mudler/luet/pkg/installer"
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func fingerprints(artifacts []compiler.Artifact) []string {
+	var res []string
+	for _, a := range artifacts {
+		res = append(res, a.GetCompileSpec().GetPackage().GetFingerPrint())
+	}
+	return res
+}
+
+// setBuildTime rewrites the metadata of the artifact of fingerprint in dir with the given build time
+func setBuildTime(dir, fingerprint string, t time.Time) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, fingerprint+".metadata.yaml"))
+	if err != nil {
+		return err
+	}
+	a, err := compiler.NewPackageArtifactFromYaml(data)
+	if err != nil {
+		return err
+	}
+	a.SetPath(filepath.Join(dir, filepath.Base(a.GetPath())))
+	a.SetBuildTime(t)
+	return a.WriteYaml(dir)
+}
+
+var _ = Describe("Prune", func() {
+	var dir string
+	var generated *testRepository
+	var repo Repository
+
+	BeforeEach(func() {
+		generated = newTestRepository("prune")
+		dir = generated.Dir
+
+		x := testPackage("x", "1.2")
+		x.PackageRequires = []*pkg.DefaultPackage{testPackage("y", "1.0")}
+		for _, p := range []*pkg.DefaultPackage{
+			testPackage("x", "1.0"), testPackage("x", "1.1"), x,
+			testPackage("y", "1.0"), testPackage("y", "2.0"),
+		} {
+			generated.Add(p, map[string]string{p.GetName(): p.GetVersion()})
+		}
+		repo = generated.Generate()
+	})
+
+	It("Requires a policy", func() {
+		_, err := Prune(repo, PrunePolicy{}, true)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Lists what would be pruned with a dry run", func() {
+		pruned, err := Prune(repo, PrunePolicy{KeepVersions: 1}, true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fingerprints(pruned)).To(Equal([]string{"x-test-1.0", "x-test-1.1", "y-test-1.0"}))
+		Expect(helpers.Exists(filepath.Join(dir, "x-test-1.0.package.tar"))).To(BeTrue())
+
+		synced, err := repo.Sync(false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(synced.GetRevision()).To(Equal(1))
+	})
+
+	It("Keeps the last versions and their dependencies", func() {
+		pruned, err := Prune(repo, PrunePolicy{KeepVersions: 1, KeepDependencies: true}, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fingerprints(pruned)).To(Equal([]string{"x-test-1.0", "x-test-1.1"}))
+
+		Expect(helpers.Exists(filepath.Join(dir, "x-test-1.0.package.tar"))).To(BeFalse())
+		Expect(helpers.Exists(filepath.Join(dir, "x-test-1.0.metadata.yaml"))).To(BeFalse())
+		Expect(helpers.Exists(filepath.Join(dir, "y-test-1.0.package.tar"))).To(BeTrue())
+
+		synced, err := repo.Sync(false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(synced.GetRevision()).To(Equal(2))
+		Expect(len(synced.GetIndex())).To(Equal(3))
+		Expect(len(synced.GetTree().GetDatabase().World())).To(Equal(3))
+
+		files, err := synced.GetFiles()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(HaveKey("x-test-1.2"))
+		Expect(files).ToNot(HaveKey("x-test-1.0"))
+	})
+
+	It("Keeps recent artifacts", func() {
+		old := time.Now().Add(-48 * time.Hour)
+		for _, f := range []string{"x-test-1.1", "x-test-1.2", "y-test-1.0", "y-test-2.0"} {
+			Expect(setBuildTime(dir, f, old)).ToNot(HaveOccurred())
+		}
+		generated.Generate()
+
+		// File times are not taken into account
+		Expect(os.Chtimes(filepath.Join(dir, "x-test-1.0.package.tar"), old, old)).ToNot(HaveOccurred())
+
+		pruned, err := Prune(repo, PrunePolicy{KeepNewerThan: time.Now().Add(-24 * time.Hour)}, true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fingerprints(pruned)).To(Equal([]string{"x-test-1.1", "x-test-1.2", "y-test-1.0", "y-test-2.0"}))
+	})
+})
@@ -16,11 +16,14 @@
 package installer
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
@@ -43,6 +46,8 @@ import (
 const (
 	REPOSITORY_SPECFILE = "repository.yaml"
 	TREE_TARBALL        = "tree.tar"
+	// TREE_FILES_DIR holds a copy of each tree file, named after its sha256, for incremental syncs
+	TREE_FILES_DIR = "treefiles"
 )
 
 type LuetSystemRepository struct {
@@ -53,6 +58,15 @@ type LuetSystemRepository struct {
 	TreePath            string                             `json:"treepath"`
 	TreeCompressionType compiler.CompressionImplementation `json:"treecompressiontype"`
 	TreeChecksums       compiler.Checksums                 `json:"treechecksums"`
+	// TreeIndex maps each file of the tree to its sha256
+	TreeIndex map[string]string `json:"treeindex,omitempty"`
+	// FilesIndex is the compressed index of the files shipped by each package, and FilesChecksum its sha256
+	FilesIndex    string `json:"filesindex,omitempty"`
+	FilesChecksum string `json:"fileschecksum,omitempty"`
+	// Files maps the fingerprint of each package to its files, it is loaded on demand from the FilesIndex
+	Files map[string][]string `json:"-"`
+	// replaces indexes the replacements declared in the tree, it is built on demand
+	replaces pkg.ReplacesIndex
 }
 
 type LuetSystemRepositorySerialized struct {
@@ -67,6 +81,9 @@ type LuetSystemRepositorySerialized struct {
 	TreePath            string                             `json:"treepath"`
 	TreeCompressionType compiler.CompressionImplementation `json:"treecompressiontype"`
 	TreeChecksums       compiler.Checksums                 `json:"treechecksums"`
+	TreeIndex           map[string]string                  `json:"treeindex,omitempty"`
+	FilesIndex          string                             `json:"filesindex,omitempty"`
+	FilesChecksum       string                             `json:"fileschecksum,omitempty"`
 }
 
 func GenerateRepository(name, descr, t string, urls []string, priority int, src, treeDir string, db pkg.PackageDatabase) (Repository, error) {
@@ -81,9 +98,16 @@ func GenerateRepository(name, descr, t string, urls []string, priority int, src,
 		return nil, err
 	}
 
-	return NewLuetSystemRepository(
+	files, err := buildFilesIndex(art, src)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := NewLuetSystemRepository(
 		config.NewLuetRepository(name, t, descr, urls, priority, true, false),
-		art, tr), nil
+		art, tr).(*LuetSystemRepository)
+	repo.Files = files
+	return repo, nil
 }
 
 func NewSystemRepository(repo config.LuetRepository) Repository {
@@ -119,6 +143,9 @@ func NewLuetSystemRepositoryFromYaml(data []byte, db pkg.PackageDatabase) (Repos
 		TreeCompressionType: p.TreeCompressionType,
 		TreeChecksums:       p.TreeChecksums,
 		TreePath:            p.TreePath,
+		TreeIndex:           p.TreeIndex,
+		FilesIndex:          p.FilesIndex,
+		FilesChecksum:       p.FilesChecksum,
 	}
 	if p.Revision > 0 {
 		r.Revision = p.Revision
@@ -186,6 +213,10 @@ func (r *LuetSystemRepository) GetTreeChecksums() compiler.Checksums {
 	return r.TreeChecksums
 }
 
+func (r *LuetSystemRepository) GetTreeIndex() map[string]string {
+	return r.TreeIndex
+}
+
 func (r *LuetSystemRepository) SetTreeCompressionType(c compiler.CompressionImplementation) {
 	r.TreeCompressionType = c
 }
@@ -212,6 +243,9 @@ func (r *LuetSystemRepository) SetUrls(urls []string) {
 func (r *LuetSystemRepository) GetPriority() int {
 	return r.LuetRepository.Priority
 }
+func (r *LuetSystemRepository) SetPriority(p int) {
+	r.LuetRepository.Priority = p
+}
 func (r *LuetSystemRepository) GetTreePath() string {
 	return r.TreePath
 }
@@ -220,6 +254,16 @@ func (r *LuetSystemRepository) SetTreePath(p string) {
 }
 func (r *LuetSystemRepository) SetTree(b tree.Builder) {
 	r.Tree = b
+	r.replaces = nil
+}
+
+// GetReplaces returns the index of the replacements declared by the packages of the tree.
+// It is built on the first call, once the tree is synced.
+func (r *LuetSystemRepository) GetReplaces() pkg.ReplacesIndex {
+	if r.replaces == nil {
+		r.replaces = pkg.NewReplacesIndex(r.GetTree().GetDatabase().World())
+	}
+	return r.replaces
 }
 func (r *LuetSystemRepository) GetIndex() compiler.ArtifactIndex {
 	return r.Index
@@ -244,6 +288,20 @@ func (r *LuetSystemRepository) SetAuthentication(auth map[string]string) {
 	r.LuetRepository.Authentication = auth
 }
 
+func (r *LuetSystemRepository) SetTimeouts(timeout int, urlTimeouts map[string]int) {
+	r.LuetRepository.Timeout = timeout
+	r.LuetRepository.UrlTimeouts = urlTimeouts
+}
+
+// timeouts returns the download timeout of each url of the repository
+func (r *LuetSystemRepository) timeouts() map[string]time.Duration {
+	timeouts := map[string]time.Duration{}
+	for _, u := range r.GetUrls() {
+		timeouts[u] = r.GetTimeout(u)
+	}
+	return timeouts
+}
+
 func (r *LuetSystemRepository) ReadSpecFile(file string, removeFile bool) (Repository, error) {
 	dat, err := ioutil.ReadFile(file)
 	if err != nil {
@@ -283,7 +341,7 @@ func (r *LuetSystemRepository) Write(dst string, resetRevision bool) error {
 			r.Revision = spec.GetRevision()
 		}
 	}
-	r.Revision++
+	r.IncrementRevision()
 
 	Info(fmt.Sprintf(
 		"For repository %s creating revision %d and last update %s...",
@@ -318,6 +376,27 @@ func (r *LuetSystemRepository) Write(dst string, resetRevision bool) error {
 	}
 	r.TreeChecksums = a.GetChecksums()
 
+	r.TreeIndex, err = TreeIndex(archive)
+	if err != nil {
+		return errors.Wrap(err, "Failed generating the tree index")
+	}
+	err = writeTreeFiles(archive, filepath.Join(dst, TREE_FILES_DIR), r.TreeIndex)
+	if err != nil {
+		return errors.Wrap(err, "Failed writing tree files")
+	}
+
+	if r.Files != nil {
+		r.FilesIndex = FILES_INDEX
+		r.FilesChecksum, err = writeFilesIndex(r.Files, filepath.Join(dst, FILES_INDEX))
+		if err != nil {
+			return errors.Wrap(err, "Failed writing the files index")
+		}
+	} else if r.FilesIndex != "" && !helpers.Exists(filepath.Join(dst, r.FilesIndex)) {
+		// Never reference a files index which is not part of the repository
+		r.FilesIndex = ""
+		r.FilesChecksum = ""
+	}
+
 	data, err := yaml.Marshal(r)
 	if err != nil {
 		return err
@@ -330,6 +409,74 @@ func (r *LuetSystemRepository) Write(dst string, resetRevision bool) error {
 	return nil
 }
 
+// TreeIndex returns the files under dir with their sha256
+func TreeIndex(dir string) (map[string]string, error) {
+	index := map[string]string{}
+	err := filepath.Walk(dir, func(currentpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, currentpath)
+		if err != nil {
+			return err
+		}
+		sum, err := fileSha256(currentpath)
+		if err != nil {
+			return err
+		}
+		index[filepath.ToSlash(rel)] = sum
+		return nil
+	})
+	return index, err
+}
+
+func fileSha256(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// writeTreeFiles copies the files of the tree in dst, named after their sha256.
+// Files not referenced anymore by the index are removed.
+func writeTreeFiles(tree, dst string, index map[string]string) error {
+	err := os.MkdirAll(dst, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	wanted := map[string]bool{}
+	for rel, sum := range index {
+		wanted[sum] = true
+		if helpers.Exists(filepath.Join(dst, sum)) {
+			continue
+		}
+		err := helpers.CopyFile(filepath.Join(tree, filepath.FromSlash(rel)), filepath.Join(dst, sum))
+		if err != nil {
+			return err
+		}
+	}
+
+	current, err := ioutil.ReadDir(dst)
+	if err != nil {
+		return err
+	}
+	for _, f := range current {
+		if !wanted[f.Name()] {
+			os.RemoveAll(filepath.Join(dst, f.Name()))
+		}
+	}
+	return nil
+}
+
 func (r *LuetSystemRepository) Client() Client {
 	switch r.GetType() {
 	case "disk":
@@ -339,11 +486,109 @@ func (r *LuetSystemRepository) Client() Client {
 			client.RepoData{
 				Urls:           r.GetUrls(),
 				Authentication: r.GetAuthentication(),
+				Timeouts:       r.timeouts(),
+			})
+	case "docker", "oci":
+		return client.NewDockerClient(
+			client.RepoData{
+				Urls:           r.GetUrls(),
+				Authentication: r.GetAuthentication(),
+				Timeouts:       r.timeouts(),
 			})
 	}
 
 	return nil
 }
+
+// DetectRepositoryType guesses the type of a repository from its url
+func DetectRepositoryType(url string) string {
+	switch {
+	case strings.HasPrefix(url, "http://"), strings.HasPrefix(url, "https://"):
+		return "http"
+	case strings.HasPrefix(url, "file://"), filepath.IsAbs(url), strings.HasPrefix(url, "."):
+		return "disk"
+	}
+	return "docker"
+}
+
+// Probe downloads the repository spec file, checking that the repository is reachable
+// and valid without syncing its tree
+func (r *LuetSystemRepository) Probe() (Repository, error) {
+	c := r.Client()
+	if c == nil {
+		return nil, errors.New("Unsupported repository type " + r.GetType())
+	}
+
+	file, err := c.DownloadFile(REPOSITORY_SPECFILE)
+	if err != nil {
+		return nil, errors.Wrap(err, "While downloading "+REPOSITORY_SPECFILE)
+	}
+	return r.ReadSpecFile(file, true)
+}
+
+// Push uploads the repository to its urls: the artifacts of the index are taken from src,
+// and the tree and the repository spec generated by Write from dst.
+// The spec is uploaded last, so clients never see a revision referencing missing files.
+func (r *LuetSystemRepository) Push(src, dst string) error {
+	c, ok := r.Client().(Uploader)
+	if !ok {
+		return errors.New("Repository type " + r.GetType() + " doesn't support pushing")
+	}
+
+	err := pushArtifacts(c, src, r.GetIndex())
+	if err != nil {
+		return err
+	}
+	return r.pushMetadata(c, dst)
+}
+
+func pushArtifacts(c Uploader, src string, artifacts []compiler.Artifact) error {
+	for _, a := range artifacts {
+		name := path.Base(a.GetPath())
+		err := c.UploadFile(filepath.Join(src, name), name)
+		if err != nil {
+			return errors.Wrap(err, "Failed pushing artifact")
+		}
+	}
+	return nil
+}
+
+// pushMetadata uploads the tree and the repository spec generated by Write in dst
+func (r *LuetSystemRepository) pushMetadata(c Uploader, dst string) error {
+	tpath := r.GetTreePath()
+	if tpath == "" {
+		tpath = TREE_TARBALL
+	}
+	err := c.UploadFile(filepath.Join(dst, tpath), tpath)
+	if err != nil {
+		return errors.Wrap(err, "Failed pushing tree")
+	}
+
+	pushed := map[string]bool{}
+	for _, sum := range r.GetTreeIndex() {
+		if pushed[sum] {
+			continue
+		}
+		pushed[sum] = true
+		err := c.UploadFile(filepath.Join(dst, TREE_FILES_DIR, sum), path.Join(TREE_FILES_DIR, sum))
+		if err != nil {
+			return errors.Wrap(err, "Failed pushing tree files")
+		}
+	}
+
+	if r.FilesIndex != "" {
+		err = c.UploadFile(filepath.Join(dst, r.FilesIndex), r.FilesIndex)
+		if err != nil {
+			return errors.Wrap(err, "Failed pushing the files index")
+		}
+	}
+
+	err = c.UploadFile(filepath.Join(dst, REPOSITORY_SPECFILE), REPOSITORY_SPECFILE)
+	if err != nil {
+		return errors.Wrap(err, "Failed pushing "+REPOSITORY_SPECFILE)
+	}
+	return nil
+}
 func (r *LuetSystemRepository) Sync(force bool) (Repository, error) {
 	var repoUpdated bool = false
 	var treefs string
@@ -392,7 +637,21 @@ func (r *LuetSystemRepository) Sync(force bool) (Repository, error) {
 		}
 	}
 
-	if !repoUpdated {
+	synced := false
+	if !repoUpdated && r.Cached && !force {
+		err = r.syncTreeFiles(c, repo, treefs, filepath.Join(repobasedir, REPOSITORY_SPECFILE))
+		if err == nil {
+			err = helpers.CopyFile(file, filepath.Join(repobasedir, REPOSITORY_SPECFILE))
+			if err != nil {
+				return nil, errors.Wrap(err, "Error on update "+REPOSITORY_SPECFILE)
+			}
+			synced = true
+		} else {
+			Debug("Incremental sync of the repository", r.GetName(), "not possible, downloading the whole tree:", err.Error())
+		}
+	}
+
+	if !repoUpdated && !synced {
 		tpath := repo.GetTreePath()
 		if tpath == "" {
 			tpath = TREE_TARBALL
@@ -430,7 +689,9 @@ func (r *LuetSystemRepository) Sync(force bool) (Repository, error) {
 		if err != nil {
 			return nil, errors.Wrap(err, "Error met while unpacking tree")
 		}
+	}
 
+	if !repoUpdated {
 		tsec, _ := strconv.ParseInt(repo.GetLastUpdate(), 10, 64)
 
 		InfoC(
@@ -453,10 +714,100 @@ func (r *LuetSystemRepository) Sync(force bool) (Repository, error) {
 	repo.SetTreePath(treefs)
 	repo.SetUrls(r.GetUrls())
 	repo.SetAuthentication(r.GetAuthentication())
+	repo.SetTimeouts(r.Timeout, r.UrlTimeouts)
+	// A priority set locally takes precedence over the one advertised by the repository
+	if r.GetPriority() != 0 {
+		repo.SetPriority(r.GetPriority())
+	}
 
 	return repo, nil
 }
 
+// syncTreeFiles updates the cached tree in treefs to the tree of repo, downloading only the files
+// which changed since the local revision. Any inconsistency is reported as an error,
+// and the caller is expected to fall back to a full sync.
+func (r *LuetSystemRepository) syncTreeFiles(c Client, repo Repository, treefs, localSpec string) error {
+	if !helpers.Exists(localSpec) || !helpers.Exists(treefs) {
+		return errors.New("No local tree available")
+	}
+	localRepo, err := r.ReadSpecFile(localSpec, false)
+	if err != nil {
+		return err
+	}
+	local := localRepo.GetTreeIndex()
+	remote := repo.GetTreeIndex()
+	if len(local) == 0 || len(remote) == 0 {
+		return errors.New("No tree index available")
+	}
+
+	current, err := TreeIndex(treefs)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(current, local) {
+		return errors.New("Local tree doesn't match its index")
+	}
+
+	// Download all the changed files before touching the tree
+	changed := map[string]string{}
+	for rel, sum := range remote {
+		if local[rel] == sum {
+			continue
+		}
+		f, err := c.DownloadFile(path.Join(TREE_FILES_DIR, sum))
+		if err != nil {
+			return errors.Wrap(err, "While downloading "+rel)
+		}
+		defer os.Remove(f)
+		got, err := fileSha256(f)
+		if err != nil {
+			return err
+		}
+		if got != sum {
+			return errors.New("Checksum mismatch for " + rel)
+		}
+		changed[rel] = f
+	}
+
+	removed := 0
+	for rel := range local {
+		if _, ok := remote[rel]; ok {
+			continue
+		}
+		target := filepath.Join(treefs, filepath.FromSlash(rel))
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+		removed++
+		// Drop the directories left empty
+		for dir := filepath.Dir(target); dir != treefs && strings.HasPrefix(dir, treefs); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	for rel, f := range changed {
+		target := filepath.Join(treefs, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
+		if err := helpers.CopyFile(f, target); err != nil {
+			return err
+		}
+	}
+
+	current, err = TreeIndex(treefs)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(current, remote) {
+		return errors.New("Tree doesn't match the repository index after the update")
+	}
+
+	Info("Repository", r.GetName(), "tree updated:", len(changed), "files changed,", removed, "removed")
+	return nil
+}
+
 func (r Repositories) Len() int      { return len(r) }
 func (r Repositories) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
 func (r Repositories) Less(i, j int) bool {
@@ -547,6 +898,20 @@ PACKAGE:
 
 }
 
+// ReplacedBy returns the packages of the repositories which declare to replace p
+func (re Repositories) ReplacedBy(p pkg.Package) []PackageMatch {
+	sort.Sort(re)
+	var matches []PackageMatch
+
+	for _, r := range re {
+		for _, pack := range r.GetReplaces().ReplacementsOf(p) {
+			matches = append(matches, PackageMatch{Package: pack, Repo: r})
+		}
+	}
+
+	return matches
+}
+
 func (re Repositories) Search(s string) []PackageMatch {
 	sort.Sort(re)
 	var term = regexp.MustCompile(s)
@@ -20,6 +20,8 @@ import (
 	//	. "github.com/mudler/luet/pkg/installer"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/mudler/luet/pkg/compiler"
 	backend "github.com/mudler/luet/pkg/compiler/backend"
@@ -94,6 +96,113 @@ var _ = Describe("Repository", func() {
 			Expect(helpers.Exists(spec.Rel(TREE_TARBALL))).To(BeTrue())
 		})
 	})
+	Context("Incremental sync", func() {
+		It("Downloads only the changed tree files", func() {
+			tmpdir, err := ioutil.TempDir("", "tree")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir) // clean up
+
+			repobasedir := config.LuetCfg.GetSystem().GetRepoDatabaseDirPath("incremental")
+			os.RemoveAll(repobasedir)
+			defer os.RemoveAll(repobasedir)
+
+			repo, err := GenerateRepository("incremental", "description", "disk", []string{tmpdir}, 1, tmpdir, "../../tests/fixtures/buildable", pkg.NewInMemoryDatabase(false))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(repo.Write(tmpdir, false)).ToNot(HaveOccurred())
+			Expect(repo.GetTreeIndex()).To(HaveKey("test/b/1.0/definition.yaml"))
+			Expect(helpers.Exists(filepath.Join(tmpdir, TREE_FILES_DIR, repo.GetTreeIndex()["test/b/1.0/definition.yaml"]))).To(BeTrue())
+
+			local := NewSystemRepository(config.LuetRepository{Name: "incremental", Type: "disk", Urls: []string{tmpdir}, Cached: true})
+			synced, err := local.Sync(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(synced.GetTree().GetDatabase().World())).To(Equal(3))
+
+			_, err = repo.GetTree().GetDatabase().CreatePackage(&pkg.DefaultPackage{Name: "new", Category: "test", Version: "1.0"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(repo.Write(tmpdir, false)).ToNot(HaveOccurred())
+
+			// Without the tarball only an incremental sync can succeed
+			Expect(os.Remove(filepath.Join(tmpdir, TREE_TARBALL))).ToNot(HaveOccurred())
+			synced, err = local.Sync(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(synced.GetRevision()).To(Equal(2))
+			Expect(len(synced.GetTree().GetDatabase().World())).To(Equal(4))
+			_, err = synced.GetTree().GetDatabase().FindPackage(&pkg.DefaultPackage{Name: "new", Category: "test", Version: "1.0"})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Falls back to a full sync when the local tree is inconsistent", func() {
+			tmpdir, err := ioutil.TempDir("", "tree")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir) // clean up
+
+			repobasedir := config.LuetCfg.GetSystem().GetRepoDatabaseDirPath("inconsistent")
+			os.RemoveAll(repobasedir)
+			defer os.RemoveAll(repobasedir)
+
+			repo, err := GenerateRepository("inconsistent", "description", "disk", []string{tmpdir}, 1, tmpdir, "../../tests/fixtures/buildable", pkg.NewInMemoryDatabase(false))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(repo.Write(tmpdir, false)).ToNot(HaveOccurred())
+
+			local := NewSystemRepository(config.LuetRepository{Name: "inconsistent", Type: "disk", Urls: []string{tmpdir}, Cached: true})
+			_, err = local.Sync(false)
+			Expect(err).ToNot(HaveOccurred())
+
+			// Tamper the cached tree and remove the tree files from the repository
+			Expect(ioutil.WriteFile(filepath.Join(repobasedir, "treefs", "test", "b", "1.0", "definition.yaml"), []byte("name: foo"), os.ModePerm)).ToNot(HaveOccurred())
+			_, err = repo.GetTree().GetDatabase().CreatePackage(&pkg.DefaultPackage{Name: "new", Category: "test", Version: "1.0"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(repo.Write(tmpdir, false)).ToNot(HaveOccurred())
+			Expect(os.RemoveAll(filepath.Join(tmpdir, TREE_FILES_DIR))).ToNot(HaveOccurred())
+
+			synced, err := local.Sync(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(synced.GetTree().GetDatabase().World())).To(Equal(4))
+			_, err = synced.GetTree().GetDatabase().FindPackage(&pkg.DefaultPackage{Name: "b", Category: "test", Version: "1.0"})
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Context("Probing", func() {
+		It("Detects the repository type from the url", func() {
+			Expect(DetectRepositoryType("https://example.com/repo")).To(Equal("http"))
+			Expect(DetectRepositoryType("/srv/repo")).To(Equal("disk"))
+			Expect(DetectRepositoryType("file:///srv/repo")).To(Equal("disk"))
+			Expect(DetectRepositoryType("quay.io/org/repo")).To(Equal("docker"))
+		})
+
+		It("Reads the spec of the repository", func() {
+			tmpdir, err := ioutil.TempDir("", "tree")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir) // clean up
+
+			repo, err := GenerateRepository("probe", "description", "disk", []string{tmpdir}, 1, tmpdir, "../../tests/fixtures/buildable", pkg.NewInMemoryDatabase(false))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(repo.Write(tmpdir, false)).ToNot(HaveOccurred())
+
+			spec, err := NewSystemRepository(config.LuetRepository{Name: "probe", Type: "disk", Urls: []string{tmpdir}}).(*LuetSystemRepository).Probe()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(spec.GetRevision()).To(Equal(1))
+
+			_, err = NewSystemRepository(config.LuetRepository{Name: "probe", Type: "disk", Urls: []string{filepath.Join(tmpdir, "missing")}}).(*LuetSystemRepository).Probe()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Timeouts", func() {
+		It("Defaults the timeout of each url to the repository and download ones", func() {
+			defer func(t int) { config.LuetCfg.GetDownload().Timeout = t }(config.LuetCfg.GetDownload().Timeout)
+			config.LuetCfg.GetDownload().Timeout = 30
+
+			repo := NewSystemRepository(config.LuetRepository{Name: "mirrors", Type: "http", Urls: []string{"http://a", "http://b"}})
+			Expect(repo.(*LuetSystemRepository).GetTimeout("http://a")).To(Equal(30 * time.Second))
+
+			repo.SetTimeouts(10, map[string]int{"http://b": 2})
+			Expect(repo.(*LuetSystemRepository).GetTimeout("http://a")).To(Equal(10 * time.Second))
+			Expect(repo.(*LuetSystemRepository).GetTimeout("http://b")).To(Equal(2 * time.Second))
+		})
+	})
+
 	Context("Matching packages", func() {
 		It("Matches packages in different repositories by priority", func() {
 			package1 := &pkg.DefaultPackage{Name: "Test"}
@@ -0,0 +1,378 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mudler/luet/pkg/compiler"
+	"github.com/mudler/luet/pkg/config"
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/mudler/luet/pkg/logger"
+	pkg "github.com/mudler/luet/pkg/package"
+	tree "github.com/mudler/luet/pkg/tree"
+
+	"github.com/pkg/errors"
+)
+
+// RepositoryServerOptions configures a RepositoryServer
+type RepositoryServerOptions struct {
+	// Dir is the folder of the served repository
+	Dir string
+
+	// Token is accepted with the "token" and "Bearer" authorization schemes
+	Token string
+	// Username and Password are accepted with the basic authentication
+	Username string
+	Password string
+	// AuthenticatedReads requires the credentials also for downloads
+	AuthenticatedReads bool
+	// MaxUploadSize is the maximum size in bytes of an uploaded artifact, DefaultMaxUploadSize if 0
+	MaxUploadSize int64
+
+	// Name, Description, Urls and TreeCompression are used when the repository
+	// is created by the first upload
+	Name            string
+	Description     string
+	Urls            []string
+	TreeCompression compiler.CompressionImplementation
+}
+
+const (
+	DefaultMaxUploadSize = 4 << 30
+	// maxMetadataSize bounds the uploads of metadata and finalizer files
+	maxMetadataSize = 16 << 20
+
+	finalizerSuffix = ".finalize.yaml"
+)
+
+// RepositoryServer serves a disk repository over http.
+// When credentials are configured, artifacts can be uploaded with PUT requests: the package
+// tarball first, then its finalizer (<fingerprint>.finalize.yaml), if any, and its metadata.
+// Each metadata upload publishes a new revision of the repository.
+type RepositoryServer struct {
+	RepositoryServerOptions
+
+	sync.Mutex
+	files http.Handler
+}
+
+func NewRepositoryServer(opts RepositoryServerOptions) *RepositoryServer {
+	return &RepositoryServer{
+		RepositoryServerOptions: opts,
+		files:                   http.FileServer(http.Dir(opts.Dir)),
+	}
+}
+
+func (s *RepositoryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+
+	// Temporary files of uploads and publications are never exposed
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if s.AuthenticatedReads && !s.authorized(r) {
+			s.unauthorized(w)
+			return
+		}
+		w.Header().Set("Cache-Control", cacheControl(name))
+		s.files.ServeHTTP(w, r)
+	case http.MethodPut:
+		if s.Token == "" && s.Username == "" {
+			http.Error(w, "Uploads are disabled", http.StatusMethodNotAllowed)
+			return
+		}
+		if !s.authorized(r) {
+			s.unauthorized(w)
+			return
+		}
+		s.upload(w, r, name)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *RepositoryServer) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if s.Token != "" {
+		for _, scheme := range []string{"token ", "Bearer "} {
+			if strings.HasPrefix(auth, scheme) &&
+				subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, scheme)), []byte(s.Token)) == 1 {
+				return true
+			}
+		}
+	}
+	if s.Username != "" {
+		if user, password, ok := r.BasicAuth(); ok &&
+			subtle.ConstantTimeCompare([]byte(user), []byte(s.Username)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(password), []byte(s.Password)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *RepositoryServer) unauthorized(w http.ResponseWriter) {
+	if s.Username != "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="luet"`)
+	}
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// cacheControl returns the caching policy of a file of the repository: tree files are named after
+// their content and never change, the repository spec and the tree must always be revalidated
+func cacheControl(name string) string {
+	switch {
+	case strings.HasPrefix(name, TREE_FILES_DIR+"/"):
+		return "public, max-age=31536000, immutable"
+	case strings.Contains(name, ".package.tar"):
+		return "public, max-age=3600"
+	}
+	return "no-cache"
+}
+
+func (s *RepositoryServer) upload(w http.ResponseWriter, r *http.Request, name string) {
+	var limit int64
+	switch {
+	case strings.Contains(name, "/"):
+	case strings.HasSuffix(name, ".metadata.yaml"):
+		limit = maxMetadataSize
+	case strings.HasSuffix(name, finalizerSuffix):
+		limit = maxMetadataSize
+	case strings.Contains(name, ".package.tar"):
+		limit = s.MaxUploadSize
+		if limit <= 0 {
+			limit = DefaultMaxUploadSize
+		}
+	}
+	if limit == 0 {
+		http.Error(w, "Only package artifacts, their finalizers and metadata can be uploaded", http.StatusForbidden)
+		return
+	}
+	if r.ContentLength > limit {
+		http.Error(w, name+" is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if strings.HasSuffix(name, finalizerSuffix) && !s.validFinalizer(w, r, name) {
+		return
+	}
+
+	if err := s.store(http.MaxBytesReader(w, r.Body, limit), name); err != nil {
+		Error("Failed storing", name, ":", err.Error())
+		http.Error(w, "Failed storing "+name, http.StatusInternalServerError)
+		return
+	}
+	Info("Received", name)
+
+	if strings.HasSuffix(name, ".metadata.yaml") {
+		if err := s.Publish(name); err != nil {
+			Error("Failed publishing", name, ":", err.Error())
+			os.Remove(filepath.Join(s.Dir, name))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// validFinalizer reads the finalizer uploaded with r, checking that it can be parsed.
+// The request body is replaced so it can be stored afterwards.
+func (s *RepositoryServer) validFinalizer(w http.ResponseWriter, r *http.Request, name string) bool {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxMetadataSize))
+	if err != nil {
+		http.Error(w, "Failed reading "+name, http.StatusBadRequest)
+		return false
+	}
+	if _, err := NewLuetFinalizerFromYaml(data); err != nil {
+		http.Error(w, "Invalid finalizer "+name+": "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return true
+}
+
+// store writes the content of body in the file name of the repository, through a temporary file
+func (s *RepositoryServer) store(body io.Reader, name string) error {
+	tmp, err := ioutil.TempFile(s.Dir, "."+name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.Dir, name))
+}
+
+// Publish adds the artifact described by the metadata file to the repository, after verifying it,
+// and writes a new revision of the repository.yaml and of the tree
+func (s *RepositoryServer) Publish(metadata string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	data, err := ioutil.ReadFile(filepath.Join(s.Dir, metadata))
+	if err != nil {
+		return errors.Wrap(err, "Error reading file "+metadata)
+	}
+	artifact, err := compiler.NewPackageArtifactFromYaml(data)
+	if err != nil {
+		return errors.Wrap(err, "Error reading yaml "+metadata)
+	}
+	if artifact.GetCompileSpec() == nil || artifact.GetCompileSpec().GetPackage() == nil {
+		return errors.New("No package in " + metadata)
+	}
+	p := artifact.GetCompileSpec().GetPackage()
+	if err := validPackagePath(p); err != nil {
+		return err
+	}
+	// The path comes from the upload, the definition is placed in the tree below
+	p.SetPath("")
+	for _, a := range artifact.GetCompileSpec().GetSourceAssertion() {
+		a.Package.SetPath("")
+	}
+
+	artifact.SetPath(filepath.Join(s.Dir, path.Base(artifact.GetPath())))
+	if err := artifact.Verify(); err != nil {
+		return errors.Wrap(err, "Artifact of "+p.HumanReadableString()+" is missing or corrupted")
+	}
+
+	treefs, err := ioutil.TempDir(os.TempDir(), "treefs")
+	if err != nil {
+		return errors.Wrap(err, "Error met while creating tempdir for the tree")
+	}
+	defer os.RemoveAll(treefs)
+
+	repo, err := s.load(treefs)
+	if err != nil {
+		return err
+	}
+	if repo.Files != nil {
+		repo.Files[p.GetFingerPrint()], err = artifactFiles(artifact, s.Dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Replace the definition and the artifact of the same package, if any.
+	// An uploaded finalizer takes precedence over the one of the replaced definition.
+	db := repo.GetTree().GetDatabase()
+	finalizer := filepath.Join(s.Dir, p.GetFingerPrint()+finalizerSuffix)
+	if helpers.Exists(finalizer) {
+		dir := tree.DefinitionDir(treefs, p)
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+		if err := helpers.CopyFile(finalizer, filepath.Join(dir, tree.FinalizerFile)); err != nil {
+			return errors.Wrap(err, "Failed adding the finalizer of "+p.HumanReadableString())
+		}
+		p.SetPath(dir)
+	} else if old, err := db.FindPackage(p); err == nil && old.GetPath() != "" &&
+		helpers.Exists(old.Rel(tree.FinalizerFile)) {
+		p.SetPath(old.GetPath())
+	}
+	db.RemovePackage(p)
+	if _, err := db.CreatePackage(p); err != nil {
+		return errors.Wrap(err, "Failed adding "+p.HumanReadableString())
+	}
+	index := compiler.ArtifactIndex{}
+	for _, a := range repo.GetIndex() {
+		if a.GetCompileSpec() == nil || a.GetCompileSpec().GetPackage() == nil ||
+			!a.GetCompileSpec().GetPackage().Matches(p) {
+			index = append(index, a)
+		}
+	}
+	repo.Index = append(index, artifact)
+
+	repo.SetTreePath("")
+	if err := publishDir(repo, s.Dir); err != nil {
+		return errors.Wrap(err, "Failed writing repository")
+	}
+	Info("Published", p.HumanReadableString())
+	return nil
+}
+
+// validPackagePath checks that the package can be stored in a tree without escaping it
+func validPackagePath(p pkg.Package) error {
+	if p.GetName() == "" {
+		return errors.New("Invalid package without a name")
+	}
+	for _, part := range []string{p.GetCategory(), p.GetName(), p.GetVersion()} {
+		if part == "." || part == ".." || strings.ContainsAny(part, `/\`) {
+			return errors.New("Invalid package " + p.HumanReadableString())
+		}
+	}
+	return nil
+}
+
+// load reads the served repository, unpacking its tree in treefs.
+// A new repository is returned if there is none yet.
+func (s *RepositoryServer) load(treefs string) (*LuetSystemRepository, error) {
+	spec := filepath.Join(s.Dir, REPOSITORY_SPECFILE)
+	if !helpers.Exists(spec) {
+		repo := NewLuetSystemRepository(
+			config.NewLuetRepository(s.Name, "http", s.Description, s.Urls, 1, true, false),
+			compiler.ArtifactIndex{}, tree.NewInstallerRecipe(pkg.NewInMemoryDatabase(false))).(*LuetSystemRepository)
+		repo.SetTreeCompressionType(s.TreeCompression)
+		repo.Files = map[string][]string{}
+		return repo, nil
+	}
+
+	data, err := ioutil.ReadFile(spec)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading file "+spec)
+	}
+	r, err := NewLuetSystemRepositoryFromYaml(data, pkg.NewInMemoryDatabase(false))
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading repository from file "+spec)
+	}
+	repo := r.(*LuetSystemRepository)
+
+	a := compiler.NewPackageArtifact(filepath.Join(s.Dir, repo.GetTreePath()))
+	a.SetCompressionType(repo.GetTreeCompressionType())
+	if err := a.Unpack(treefs, true); err != nil {
+		return nil, errors.Wrap(err, "Error met while unpacking tree")
+	}
+	if err := repo.GetTree().Load(treefs); err != nil {
+		return nil, errors.Wrap(err, "Error met while loading tree")
+	}
+	if err := repo.loadFilesIndex(s.Dir); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
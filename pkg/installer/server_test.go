@@ -0,0 +1,233 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	config "github.com/mudler/luet/pkg/config"
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/mudler/luet/pkg/installer"
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Repository server", func() {
+	var dir, src string
+	var server *httptest.Server
+
+	put := func(name string, auth func(*http.Request)) int {
+		f, err := os.Open(filepath.Join(src, name))
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+		req, err := http.NewRequest(http.MethodPut, server.URL+"/"+name, f)
+		Expect(err).ToNot(HaveOccurred())
+		if auth != nil {
+			auth(req)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	withToken := func(req *http.Request) { req.Header.Set("Authorization", "Bearer secret") }
+
+	BeforeEach(func() {
+		dir = tempDir("serve")
+		src = tempDir("artifacts")
+		writeArtifact(src, testPackage("x", "1.0"), map[string]string{"x": "x"})
+		writeArtifact(src, testPackage("z", "1.0"), map[string]string{"z": "z"})
+
+		server = httptest.NewServer(NewRepositoryServer(RepositoryServerOptions{
+			Dir:                dir,
+			Token:              "secret",
+			Username:           "user",
+			Password:           "pass",
+			AuthenticatedReads: true,
+			Name:               "served",
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("Requires credentials", func() {
+		Expect(put("x-test-1.0.package.tar", nil)).To(Equal(http.StatusUnauthorized))
+		Expect(put("x-test-1.0.package.tar", func(req *http.Request) { req.Header.Set("Authorization", "token wrong") })).To(Equal(http.StatusUnauthorized))
+		Expect(put("x-test-1.0.package.tar", func(req *http.Request) { req.SetBasicAuth("user", "wrong") })).To(Equal(http.StatusUnauthorized))
+		Expect(helpers.Exists(filepath.Join(dir, "x-test-1.0.package.tar"))).To(BeFalse())
+
+		Expect(put("x-test-1.0.package.tar", func(req *http.Request) { req.SetBasicAuth("user", "pass") })).To(Equal(http.StatusCreated))
+		Expect(helpers.Exists(filepath.Join(dir, "x-test-1.0.package.tar"))).To(BeTrue())
+
+		resp, err := http.Get(server.URL + "/x-test-1.0.package.tar")
+		Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("Publishes uploaded artifacts", func() {
+		Expect(put("x-test-1.0.package.tar", withToken)).To(Equal(http.StatusCreated))
+		Expect(put("x-test-1.0.metadata.yaml", withToken)).To(Equal(http.StatusCreated))
+
+		repo := NewSystemRepository(config.LuetRepository{
+			Name: "served", Type: "http", Urls: []string{server.URL},
+			Authentication: map[string]string{"token": "secret"},
+		})
+		synced, err := repo.Sync(false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(synced.GetRevision()).To(Equal(1))
+		Expect(len(synced.GetIndex())).To(Equal(1))
+
+		Expect(put("z-test-1.0.package.tar", withToken)).To(Equal(http.StatusCreated))
+		Expect(put("z-test-1.0.metadata.yaml", withToken)).To(Equal(http.StatusCreated))
+
+		synced, err = repo.Sync(false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(synced.GetRevision()).To(Equal(2))
+		Expect(len(synced.GetIndex())).To(Equal(2))
+		Expect(len(synced.GetTree().GetDatabase().World())).To(Equal(2))
+
+		matches, err := Repositories{synced}.SearchFiles("/z")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(matches)).To(Equal(1))
+		Expect(matches[0].Package.GetName()).To(Equal("z"))
+
+		inst := newTestInstaller(LuetInstallerOptions{Concurrency: 1}, repo)
+		system := newTestSystem()
+		Expect(inst.Install([]pkg.Package{testPackage("z", "1.0")}, system)).ToNot(HaveOccurred())
+		Expect(helpers.Exists(filepath.Join(system.Target, "z"))).To(BeTrue())
+	})
+
+	It("Rejects metadata of missing or corrupted artifacts", func() {
+		Expect(put("x-test-1.0.metadata.yaml", withToken)).To(Equal(http.StatusBadRequest))
+		Expect(ioutil.WriteFile(filepath.Join(src, "x-test-1.0.package.tar"), []byte("corrupted"), os.ModePerm)).ToNot(HaveOccurred())
+		Expect(put("x-test-1.0.package.tar", withToken)).To(Equal(http.StatusCreated))
+		Expect(put("x-test-1.0.metadata.yaml", withToken)).To(Equal(http.StatusBadRequest))
+
+		Expect(helpers.Exists(filepath.Join(dir, REPOSITORY_SPECFILE))).To(BeFalse())
+		Expect(helpers.Exists(filepath.Join(dir, "x-test-1.0.metadata.yaml"))).To(BeFalse())
+	})
+
+	It("Publishes uploaded finalizers", func() {
+		Expect(ioutil.WriteFile(filepath.Join(src, "z-test-1.0.finalize.yaml"), []byte("install:\n- echo z\n"), os.ModePerm)).ToNot(HaveOccurred())
+		Expect(put("z-test-1.0.package.tar", withToken)).To(Equal(http.StatusCreated))
+		Expect(put("z-test-1.0.finalize.yaml", withToken)).To(Equal(http.StatusCreated))
+		Expect(put("z-test-1.0.metadata.yaml", withToken)).To(Equal(http.StatusCreated))
+
+		repo := NewSystemRepository(config.LuetRepository{
+			Name: "served", Type: "http", Urls: []string{server.URL},
+			Authentication: map[string]string{"token": "secret"},
+		})
+		synced, err := repo.Sync(false)
+		Expect(err).ToNot(HaveOccurred())
+		z, err := synced.GetTree().GetDatabase().FindPackage(testPackage("z", "1.0"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(helpers.Read(z.Rel("finalize.yaml"))).To(Equal("install:\n- echo z\n"))
+
+		// Publishing again the same package keeps its finalizer
+		Expect(os.Remove(filepath.Join(dir, "z-test-1.0.finalize.yaml"))).ToNot(HaveOccurred())
+		Expect(put("z-test-1.0.metadata.yaml", withToken)).To(Equal(http.StatusCreated))
+		synced, err = repo.Sync(false)
+		Expect(err).ToNot(HaveOccurred())
+		z, err = synced.GetTree().GetDatabase().FindPackage(testPackage("z", "1.0"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(helpers.Exists(z.Rel("finalize.yaml"))).To(BeTrue())
+	})
+
+	It("Rejects invalid finalizers", func() {
+		Expect(ioutil.WriteFile(filepath.Join(src, "z-test-1.0.finalize.yaml"), []byte("install: ["), os.ModePerm)).ToNot(HaveOccurred())
+		Expect(put("z-test-1.0.finalize.yaml", withToken)).To(Equal(http.StatusBadRequest))
+		Expect(helpers.Exists(filepath.Join(dir, "z-test-1.0.finalize.yaml"))).To(BeFalse())
+	})
+
+	It("Ignores the path of the uploaded packages", func() {
+		outside := tempDir("outside")
+		Expect(ioutil.WriteFile(filepath.Join(outside, "finalize.yaml"), []byte("install:\n- echo evil\n"), os.ModePerm)).ToNot(HaveOccurred())
+
+		data, err := ioutil.ReadFile(filepath.Join(src, "x-test-1.0.metadata.yaml"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ioutil.WriteFile(filepath.Join(src, "x-test-1.0.metadata.yaml"),
+			[]byte(strings.Replace(string(data), "path: \"\"", "path: "+outside, 1)), os.ModePerm)).ToNot(HaveOccurred())
+
+		Expect(put("x-test-1.0.package.tar", withToken)).To(Equal(http.StatusCreated))
+		Expect(put("x-test-1.0.metadata.yaml", withToken)).To(Equal(http.StatusCreated))
+
+		repo := NewSystemRepository(config.LuetRepository{
+			Name: "served", Type: "http", Urls: []string{server.URL},
+			Authentication: map[string]string{"token": "secret"},
+		})
+		synced, err := repo.Sync(false)
+		Expect(err).ToNot(HaveOccurred())
+		x, err := synced.GetTree().GetDatabase().FindPackage(testPackage("x", "1.0"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(helpers.Exists(x.Rel("finalize.yaml"))).To(BeFalse())
+	})
+
+	It("Limits the size of the uploads", func() {
+		small := httptest.NewServer(NewRepositoryServer(RepositoryServerOptions{Dir: dir, Token: "secret", MaxUploadSize: 4}))
+		defer small.Close()
+
+		req, err := http.NewRequest(http.MethodPut, small.URL+"/x-test-1.0.package.tar", strings.NewReader("too large"))
+		Expect(err).ToNot(HaveOccurred())
+		withToken(req)
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(helpers.Exists(filepath.Join(dir, "x-test-1.0.package.tar"))).To(BeFalse())
+	})
+
+	It("Refuses to overwrite the repository metadata", func() {
+		Expect(ioutil.WriteFile(filepath.Join(src, REPOSITORY_SPECFILE), []byte("name: evil"), os.ModePerm)).ToNot(HaveOccurred())
+		Expect(put(REPOSITORY_SPECFILE, withToken)).To(Equal(http.StatusForbidden))
+	})
+
+	It("Sets caching headers", func() {
+		Expect(put("x-test-1.0.package.tar", withToken)).To(Equal(http.StatusCreated))
+		Expect(put("x-test-1.0.metadata.yaml", withToken)).To(Equal(http.StatusCreated))
+
+		get := func(name string) *http.Response {
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/"+name, nil)
+			Expect(err).ToNot(HaveOccurred())
+			withToken(req)
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			return resp
+		}
+
+		Expect(get(REPOSITORY_SPECFILE).Header.Get("Cache-Control")).To(Equal("no-cache"))
+		Expect(get("x-test-1.0.package.tar").Header.Get("Cache-Control")).To(HavePrefix("public"))
+
+		files, err := ioutil.ReadDir(filepath.Join(dir, TREE_FILES_DIR))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).ToNot(BeEmpty())
+		Expect(get(TREE_FILES_DIR + "/" + files[0].Name()).Header.Get("Cache-Control")).To(ContainSubstring("immutable"))
+
+		for _, f := range files {
+			Expect(strings.HasPrefix(f.Name(), ".")).To(BeFalse())
+		}
+	})
+})
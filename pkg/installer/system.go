@@ -1,7 +1,13 @@
 package installer
 
 import (
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/helpers"
 	pkg "github.com/mudler/luet/pkg/package"
+
+	"github.com/pkg/errors"
 )
 
 type System struct {
@@ -12,3 +18,17 @@ type System struct {
 func (s *System) World() ([]pkg.Package, error) {
 	return s.Database.World(), nil
 }
+
+// BootstrapSystem initializes a new system in target, with its database in dbPath inside target.
+// It fails if target has already a system database.
+func BootstrapSystem(target, dbPath string) (*System, error) {
+	dir := filepath.Join(target, dbPath)
+	db := filepath.Join(dir, "luet.db")
+	if helpers.Exists(db) {
+		return nil, errors.New("The system in " + target + " is already bootstrapped: " + db + " exists")
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "Failed creating the system database directory")
+	}
+	return &System{Database: pkg.NewBoltDatabase(db), Target: target}, nil
+}
@@ -0,0 +1,131 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/mudler/luet/pkg/logger"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// LuetTrigger is executed once at the end of a transaction which installed or removed
+// files matching one of its globs (or files inside directories matching them), e.g. to run
+// ldconfig or depmod once instead of in the finalizer of every package.
+// The commands run on the host, with the system target in LUET_ROOTFS, unless Chroot is set to run
+// them inside the target as the finalizers.
+type LuetTrigger struct {
+	Name     string   `json:"-"`
+	Files    []string `json:"files"`
+	Commands []string `json:"commands"`
+	Chroot   bool     `json:"chroot,omitempty"`
+}
+
+// LoadTriggers reads the triggers defined in the yaml files of dir, sorted by file name
+func LoadTriggers(dir string) ([]*LuetTrigger, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "Failed reading triggers directory "+dir)
+	}
+
+	var triggers []*LuetTrigger
+	for _, e := range entries {
+		if e.IsDir() || !(strings.HasSuffix(e.Name(), ".yaml") || strings.HasSuffix(e.Name(), ".yml")) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed reading trigger "+e.Name())
+		}
+		t := &LuetTrigger{}
+		if err := yaml.Unmarshal(data, t); err != nil {
+			return nil, errors.Wrap(err, "Failed parsing trigger "+e.Name())
+		}
+		t.Name = e.Name()
+		triggers = append(triggers, t)
+	}
+	sort.Slice(triggers, func(i, j int) bool { return triggers[i].Name < triggers[j].Name })
+	return triggers, nil
+}
+
+// Match returns the files matching the globs of the trigger
+func (t *LuetTrigger) Match(files []string) []string {
+	var matched []string
+	for _, f := range files {
+		for _, glob := range t.Files {
+			if matchFile(glob, f) {
+				matched = append(matched, f)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// Run executes the commands of the trigger, giving them the matched files, one per line, on the standard input.
+// target is the root of the system changed by the transaction.
+func (t *LuetTrigger) Run(files []string, target string) error {
+	for _, c := range t.Commands {
+		cmd, err := shellCommand(c, target, !t.Chroot)
+		if err != nil {
+			return err
+		}
+		Debug("trigger:", t.Name, cmd.Args)
+		cmd.Stdin = strings.NewReader(strings.Join(files, "\n") + "\n")
+		cmd.Env = append(cmd.Env, "LUET_TRIGGER="+t.Name)
+		stdoutStderr, err := cmd.CombinedOutput()
+		if err != nil {
+			return errors.Wrap(err, "Failed running command: "+string(stdoutStderr))
+		}
+		Info(string(stdoutStderr))
+	}
+	return nil
+}
+
+// runTriggers executes once the triggers matching the files touched by the transaction.
+// The triggers are read from the system target, at the end of the transaction, so the ones
+// shipped by the packages just installed are executed too. A failing trigger doesn't prevent running the others.
+func (l *LuetInstaller) runTriggers(t *transaction, s *System) error {
+	if l.Options.TriggersDir == "" {
+		return nil
+	}
+	triggers, err := LoadTriggers(filepath.Join(s.Target, l.Options.TriggersDir))
+	if err != nil {
+		return err
+	}
+
+	touched := t.touchedFiles()
+	for _, tr := range triggers {
+		matched := tr.Match(touched)
+		if len(matched) == 0 {
+			continue
+		}
+		Info("Executing trigger", tr.Name)
+		if err := tr.Run(matched, s.Target); err != nil {
+			Warning("Failed executing trigger", tr.Name+":", err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package installer_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/luet/pkg/helpers"
+	. "github.com/mudler/luet/pkg/installer"
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Triggers", func() {
+	var fakeroot string
+	var inst Installer
+	var system *System
+
+	// ldconfig ships the trigger, which logs the libraries it's executed for
+	trigger := "files: [\"/usr/lib/*.so\"]\ncommands: [\"cat >> $LUET_ROOTFS/ldconfig.log\", \"echo run >> $LUET_ROOTFS/ldconfig.runs\"]\n"
+	packages := map[string]map[string]string{
+		"ldconfig": {"etc/luet/triggers.d/ldconfig.yaml": trigger},
+		"liba":     {"usr/lib/liba.so": "a"},
+		"libb":     {"usr/lib/libb.so": "b"},
+		"tool":     {"usr/bin/tool": "tool"},
+		"chrooted": {"etc/luet/triggers.d/chrooted.yaml": "files: [\"/usr/bin\"]\nchroot: true\ncommands: [\"test -e /usr/bin/tool && echo $LUET_ROOTFS > /chrooted\"]\n"},
+		"broken":   {"etc/luet/triggers.d/broken.yaml": "files: [\"/usr/bin\"]\ncommands: [\"exit 1\"]\n"},
+	}
+
+	BeforeEach(func() {
+		repo := newTestRepository("triggers")
+		for name, files := range packages {
+			repo.Add(testPackage(name, "1.0"), files)
+		}
+
+		inst = newTestInstaller(LuetInstallerOptions{Concurrency: 2, TriggersDir: "/etc/luet/triggers.d"}, repo.Generate())
+		system = newTestSystem()
+		fakeroot = system.Target
+	})
+
+	It("Matches files and their directories", func() {
+		t := &LuetTrigger{Files: []string{"/usr/lib/*.so", "/lib/modules"}}
+		Expect(t.Match([]string{"usr/lib/liba.so", "usr/bin/tool", "lib/modules/5.4/kernel/a.ko"})).To(Equal([]string{"usr/lib/liba.so", "lib/modules/5.4/kernel/a.ko"}))
+	})
+
+	It("Runs the triggers once per transaction", func() {
+		Expect(inst.Install([]pkg.Package{
+			testPackage("ldconfig", "1.0"),
+			testPackage("liba", "1.0"),
+			testPackage("libb", "1.0"),
+		}, system)).ToNot(HaveOccurred())
+		Expect(helpers.Read(filepath.Join(fakeroot, "ldconfig.runs"))).To(Equal("run\n"))
+		Expect(helpers.Read(filepath.Join(fakeroot, "ldconfig.log"))).To(Equal("usr/lib/liba.so\nusr/lib/libb.so\n"))
+
+		// Not executed if no matching file is touched
+		Expect(inst.Install([]pkg.Package{testPackage("tool", "1.0")}, system)).ToNot(HaveOccurred())
+		Expect(helpers.Read(filepath.Join(fakeroot, "ldconfig.runs"))).To(Equal("run\n"))
+
+		Expect(inst.Uninstall(testPackage("liba", "1.0"), system)).ToNot(HaveOccurred())
+		Expect(helpers.Read(filepath.Join(fakeroot, "ldconfig.runs"))).To(Equal("run\nrun\n"))
+		Expect(helpers.Read(filepath.Join(fakeroot, "ldconfig.log"))).To(HaveSuffix("usr/lib/libb.so\nusr/lib/liba.so\n"))
+	})
+
+	It("Runs the triggers chrooted into the target when asked", func() {
+		if os.Geteuid() != 0 {
+			Skip("chroot requires root")
+		}
+		copyShell(fakeroot)
+		Expect(inst.Install([]pkg.Package{
+			testPackage("chrooted", "1.0"),
+			testPackage("tool", "1.0"),
+		}, system)).ToNot(HaveOccurred())
+		Expect(helpers.Read(filepath.Join(fakeroot, "chrooted"))).To(Equal(fakeroot + "\n"))
+	})
+
+	It("Doesn't fail the transactions on failing triggers", func() {
+		Expect(inst.Install([]pkg.Package{
+			testPackage("broken", "1.0"),
+			testPackage("ldconfig", "1.0"),
+		}, system)).ToNot(HaveOccurred())
+		Expect(inst.Install([]pkg.Package{
+			testPackage("liba", "1.0"),
+			testPackage("tool", "1.0"),
+		}, system)).ToNot(HaveOccurred())
+		Expect(helpers.Read(filepath.Join(fakeroot, "ldconfig.runs"))).To(Equal("run\n"))
+
+		history, err := system.Database.GetTransactions()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(history[1].Succeeded()).To(BeTrue())
+		Expect(inst.Undo(history[1].ID, system)).ToNot(HaveOccurred())
+		Expect(helpers.Exists(filepath.Join(fakeroot, "usr/bin/tool"))).To(BeFalse())
+	})
+})
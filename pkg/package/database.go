@@ -41,9 +41,17 @@ type PackageSet interface {
 	GetPackageFiles(Package) ([]string, error)
 	SetPackageFiles(*PackageFile) error
 	RemovePackageFiles(Package) error
+	// ReplacePackage swaps the package old and its files with new and files in a single step
+	ReplacePackage(old Package, new Package, files *PackageFile) error
 	FindPackageVersions(p Package) ([]Package, error)
 	World() []Package
 
+	// AddTransaction appends t to the history, setting its ID
+	AddTransaction(t *Transaction) error
+	// GetTransactions returns the history, from the oldest transaction
+	GetTransactions() ([]*Transaction, error)
+	GetTransaction(id int) (*Transaction, error)
+
 	FindPackageCandidate(p Package) (Package, error)
 }
 
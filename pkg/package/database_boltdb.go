@@ -97,7 +97,7 @@ func (db *BoltDatabase) FindPackage(tofind Package) (Package, error) {
 	}
 	defer bolt.Close()
 
-	err = bolt.Select(q.Eq("Name", tofind.GetName()), q.Eq("Category", tofind.GetCategory()), q.Eq("Version", tofind.GetVersion())).Limit(1).First(p)
+	err = bolt.Select(q.Eq("Name", tofind.GetName()), q.Eq("Category", tofind.GetCategory()), q.Eq("Version", tofind.GetVersion()), q.Eq("Slot", tofind.GetSlot())).Limit(1).First(p)
 	if err != nil {
 		return nil, err
 	}
@@ -299,6 +299,98 @@ func (db *BoltDatabase) RemovePackageFiles(p Package) error {
 	return files.DeleteStruct(&pf)
 }
 
+func (db *BoltDatabase) AddTransaction(t *Transaction) error {
+	bolt, err := storm.Open(db.Path, storm.BoltOptions(0600, &bbolt.Options{Timeout: 30 * time.Second}))
+	if err != nil {
+		return errors.Wrap(err, "Error opening boltdb "+db.Path)
+	}
+	defer bolt.Close()
+
+	return bolt.From("history").Save(t)
+}
+
+func (db *BoltDatabase) GetTransactions() ([]*Transaction, error) {
+	bolt, err := storm.Open(db.Path, storm.BoltOptions(0600, &bbolt.Options{Timeout: 30 * time.Second}))
+	if err != nil {
+		return nil, errors.Wrap(err, "Error opening boltdb "+db.Path)
+	}
+	defer bolt.Close()
+
+	var history []*Transaction
+	if err := bolt.From("history").All(&history); err != nil {
+		return nil, errors.Wrap(err, "While reading the history")
+	}
+	return history, nil
+}
+
+func (db *BoltDatabase) GetTransaction(id int) (*Transaction, error) {
+	bolt, err := storm.Open(db.Path, storm.BoltOptions(0600, &bbolt.Options{Timeout: 30 * time.Second}))
+	if err != nil {
+		return nil, errors.Wrap(err, "Error opening boltdb "+db.Path)
+	}
+	defer bolt.Close()
+
+	var t Transaction
+	if err := bolt.From("history").One("ID", id, &t); err != nil {
+		return nil, errors.Wrap(err, "While finding transaction "+strconv.Itoa(id))
+	}
+	return &t, nil
+}
+
+func (db *BoltDatabase) ReplacePackage(old Package, new Package, files *PackageFile) error {
+	dp, ok := new.(*DefaultPackage)
+	if !ok {
+		return errors.New("Bolt DB support only DefaultPackage type for now")
+	}
+
+	bolt, err := storm.Open(db.Path, storm.BoltOptions(0600, &bbolt.Options{Timeout: 30 * time.Second}))
+	if err != nil {
+		return errors.Wrap(err, "Error opening boltdb "+db.Path)
+	}
+	defer bolt.Close()
+
+	tx, err := bolt.Begin(true)
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction on "+db.Path)
+	}
+	defer tx.Rollback()
+
+	var found DefaultPackage
+	err = tx.Select(q.Eq("Name", old.GetName()), q.Eq("Category", old.GetCategory()), q.Eq("Version", old.GetVersion()), q.Eq("Slot", old.GetSlot())).Limit(1).Delete(&found)
+	if err != nil {
+		return errors.Wrap(err, "No package found to replace")
+	}
+	fileNode := tx.From("files")
+	var pf PackageFile
+	if err := fileNode.One("PackageFingerprint", old.GetFingerPrint(), &pf); err == nil {
+		if err := fileNode.DeleteStruct(&pf); err != nil {
+			return errors.Wrap(err, "Error removing files of "+old.GetFingerPrint())
+		}
+	}
+
+	dp.ID = 0
+	if err := tx.Save(dp); err != nil {
+		return errors.Wrap(err, "Error saving package to "+db.Path)
+	}
+	if err := fileNode.Save(files); err != nil {
+		return errors.Wrap(err, "Error saving files of "+dp.GetFingerPrint())
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "Error committing transaction on "+db.Path)
+	}
+
+	db.Lock()
+	defer db.Unlock()
+	uncacheProvides(db.ProvidesDatabase, old)
+	for _, provide := range dp.Provides {
+		if _, ok := db.ProvidesDatabase[provide.GetPackageName()]; !ok {
+			db.ProvidesDatabase[provide.GetPackageName()] = make(map[string]Package)
+		}
+		db.ProvidesDatabase[provide.GetPackageName()][provide.GetVersion()] = dp
+	}
+	return nil
+}
+
 func (db *BoltDatabase) RemovePackage(p Package) error {
 	bolt, err := storm.Open(db.Path, storm.BoltOptions(0600, &bbolt.Options{Timeout: 30 * time.Second}))
 	if err != nil {
@@ -306,7 +398,7 @@ func (db *BoltDatabase) RemovePackage(p Package) error {
 	}
 	defer bolt.Close()
 	var found DefaultPackage
-	err = bolt.Select(q.Eq("Name", p.GetName()), q.Eq("Category", p.GetCategory()), q.Eq("Version", p.GetVersion())).Limit(1).Delete(&found)
+	err = bolt.Select(q.Eq("Name", p.GetName()), q.Eq("Category", p.GetCategory()), q.Eq("Version", p.GetVersion()), q.Eq("Slot", p.GetSlot())).Limit(1).Delete(&found)
 	if err != nil {
 		return errors.Wrap(err, "No package found to delete")
 	}
@@ -360,6 +452,10 @@ func (db *BoltDatabase) FindPackages(p Package) ([]Package, error) {
 		if w.GetName() != p.GetName() || w.GetCategory() != p.GetCategory() {
 			continue
 		}
+		// Restrict to the requested slot, if any
+		if p.GetSlot() != "" && w.GetSlot() != p.GetSlot() {
+			continue
+		}
 
 		match, err := p.SelectorMatchVersion(w.GetVersion())
 		if err != nil {
@@ -372,11 +468,11 @@ func (db *BoltDatabase) FindPackages(p Package) ([]Package, error) {
 	return versionsInWorld, nil
 }
 
-// FindPackageVersions return the list of the packages beloging to cat/name
+// FindPackageVersions return the list of the packages beloging to cat/name in the same slot
 func (db *BoltDatabase) FindPackageVersions(p Package) ([]Package, error) {
 	var versionsInWorld []Package
 	for _, w := range db.World() {
-		if w.GetName() != p.GetName() || w.GetCategory() != p.GetCategory() {
+		if w.GetName() != p.GetName() || w.GetCategory() != p.GetCategory() || w.GetSlot() != p.GetSlot() {
 			continue
 		}
 
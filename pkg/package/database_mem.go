@@ -27,7 +27,7 @@ var DBInMemoryInstance = &InMemoryDatabase{
 	Mutex:            &sync.Mutex{},
 	FileDatabase:     map[string][]string{},
 	Database:         map[string]string{},
-	CacheNoVersion:   map[string]map[string]interface{}{},
+	CacheNoVersion:   map[string]map[string][]string{},
 	ProvidesDatabase: map[string]map[string]Package{},
 }
 
@@ -35,8 +35,9 @@ type InMemoryDatabase struct {
 	*sync.Mutex
 	Database         map[string]string
 	FileDatabase     map[string][]string
-	CacheNoVersion   map[string]map[string]interface{}
+	CacheNoVersion   map[string]map[string][]string // cat/name -> versions -> slots
 	ProvidesDatabase map[string]map[string]Package
+	History          []*Transaction
 }
 
 func NewInMemoryDatabase(singleton bool) PackageDatabase {
@@ -46,7 +47,7 @@ func NewInMemoryDatabase(singleton bool) PackageDatabase {
 			Mutex:            &sync.Mutex{},
 			FileDatabase:     map[string][]string{},
 			Database:         map[string]string{},
-			CacheNoVersion:   map[string]map[string]interface{}{},
+			CacheNoVersion:   map[string]map[string][]string{},
 			ProvidesDatabase: map[string]map[string]Package{},
 		}
 	}
@@ -144,9 +145,15 @@ func (db *InMemoryDatabase) CreatePackage(p Package) (string, error) {
 	// Create extra cache between package -> []versions
 	db.Lock()
 	defer db.Unlock()
+	db.cachePackage(pd)
 
+	return ID, nil
+}
+
+// cachePackage updates the caches of the database with p. The caller must hold the lock
+func (db *InMemoryDatabase) cachePackage(p *DefaultPackage) {
 	// Provides: Store package provides, we will reuse this when walking deps
-	for _, provide := range pd.Provides {
+	for _, provide := range p.Provides {
 		if _, ok := db.ProvidesDatabase[provide.GetPackageName()]; !ok {
 			db.ProvidesDatabase[provide.GetPackageName()] = make(map[string]Package)
 
@@ -155,13 +162,50 @@ func (db *InMemoryDatabase) CreatePackage(p Package) (string, error) {
 		db.ProvidesDatabase[provide.GetPackageName()][provide.GetVersion()] = p
 	}
 
-	_, ok = db.CacheNoVersion[p.GetPackageName()]
+	_, ok := db.CacheNoVersion[p.GetPackageName()]
 	if !ok {
-		db.CacheNoVersion[p.GetPackageName()] = make(map[string]interface{})
+		db.CacheNoVersion[p.GetPackageName()] = make(map[string][]string)
+	}
+	for _, slot := range db.CacheNoVersion[p.GetPackageName()][p.GetVersion()] {
+		if slot == p.GetSlot() {
+			return
+		}
 	}
-	db.CacheNoVersion[p.GetPackageName()][p.GetVersion()] = nil
+	db.CacheNoVersion[p.GetPackageName()][p.GetVersion()] = append(db.CacheNoVersion[p.GetPackageName()][p.GetVersion()], p.GetSlot())
+}
 
-	return ID, nil
+func (db *InMemoryDatabase) uncachePackage(p Package) {
+	uncacheProvides(db.ProvidesDatabase, p)
+	if versions, ok := db.CacheNoVersion[p.GetPackageName()]; ok {
+		var slots []string
+		for _, slot := range versions[p.GetVersion()] {
+			if slot != p.GetSlot() {
+				slots = append(slots, slot)
+			}
+		}
+		if len(slots) == 0 {
+			delete(versions, p.GetVersion())
+		} else {
+			versions[p.GetVersion()] = slots
+		}
+		if len(versions) == 0 {
+			delete(db.CacheNoVersion, p.GetPackageName())
+		}
+	}
+}
+
+// uncacheProvides drops from provides every entry resolving to p
+func uncacheProvides(provides map[string]map[string]Package, p Package) {
+	for name, versions := range provides {
+		for version, provider := range versions {
+			if provider.GetFingerPrint() == p.GetFingerPrint() {
+				delete(versions, version)
+			}
+		}
+		if len(versions) == 0 {
+			delete(provides, name)
+		}
+	}
 }
 
 func (db *InMemoryDatabase) getProvide(p Package) (Package, error) {
@@ -221,19 +265,24 @@ func (db *InMemoryDatabase) FindPackage(p Package) (Package, error) {
 	return db.GetPackage(p.GetFingerPrint())
 }
 
-// FindPackages return the list of the packages beloging to cat/name
+// FindPackageVersions return the list of the packages beloging to cat/name in the same slot
 func (db *InMemoryDatabase) FindPackageVersions(p Package) ([]Package, error) {
 	versions, ok := db.CacheNoVersion[p.GetPackageName()]
 	if !ok {
 		return nil, errors.New("No versions found for package")
 	}
 	var versionsInWorld []Package
-	for ve, _ := range versions {
-		w, err := db.FindPackage(&DefaultPackage{Name: p.GetName(), Category: p.GetCategory(), Version: ve})
-		if err != nil {
-			return nil, errors.Wrap(err, "Cache mismatch - this shouldn't happen")
+	for ve, slots := range versions {
+		for _, slot := range slots {
+			if slot != p.GetSlot() {
+				continue
+			}
+			w, err := db.FindPackage(&DefaultPackage{Name: p.GetName(), Category: p.GetCategory(), Version: ve, Slot: slot})
+			if err != nil {
+				return nil, errors.Wrap(err, "Cache mismatch - this shouldn't happen")
+			}
+			versionsInWorld = append(versionsInWorld, w)
 		}
-		versionsInWorld = append(versionsInWorld, w)
 	}
 	return versionsInWorld, nil
 }
@@ -250,18 +299,24 @@ func (db *InMemoryDatabase) FindPackages(p Package) ([]Package, error) {
 		return nil, errors.New("No versions found for package")
 	}
 	var versionsInWorld []Package
-	for ve, _ := range versions {
+	for ve, slots := range versions {
 		match, err := p.SelectorMatchVersion(ve)
 		if err != nil {
 			return nil, errors.Wrap(err, "Error on match selector")
 		}
 
 		if match {
-			w, err := db.FindPackage(&DefaultPackage{Name: p.GetName(), Category: p.GetCategory(), Version: ve})
-			if err != nil {
-				return nil, errors.Wrap(err, "Cache mismatch - this shouldn't happen")
+			for _, slot := range slots {
+				// Restrict to the requested slot, if any
+				if p.GetSlot() != "" && slot != p.GetSlot() {
+					continue
+				}
+				w, err := db.FindPackage(&DefaultPackage{Name: p.GetName(), Category: p.GetCategory(), Version: ve, Slot: slot})
+				if err != nil {
+					return nil, errors.Wrap(err, "Cache mismatch - this shouldn't happen")
+				}
+				versionsInWorld = append(versionsInWorld, w)
 			}
-			versionsInWorld = append(versionsInWorld, w)
 		}
 	}
 	return versionsInWorld, nil
@@ -319,11 +374,59 @@ func (db *InMemoryDatabase) RemovePackageFiles(p Package) error {
 	return nil
 }
 
+func (db *InMemoryDatabase) ReplacePackage(old Package, new Package, files *PackageFile) error {
+	pd, ok := new.(*DefaultPackage)
+	if !ok {
+		return errors.New("InMemoryDatabase suports only DefaultPackage")
+	}
+	res, err := pd.JSON()
+	if err != nil {
+		return err
+	}
+
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.Database[old.GetFingerPrint()]; !ok {
+		return errors.New("No package found to replace")
+	}
+	delete(db.Database, old.GetFingerPrint())
+	delete(db.FileDatabase, old.GetFingerPrint())
+	db.uncachePackage(old)
+	db.Database[pd.GetFingerPrint()] = base64.StdEncoding.EncodeToString(res)
+	db.FileDatabase[files.PackageFingerprint] = files.Files
+	db.cachePackage(pd)
+	return nil
+}
+
+func (db *InMemoryDatabase) AddTransaction(t *Transaction) error {
+	db.Lock()
+	defer db.Unlock()
+	t.ID = len(db.History) + 1
+	db.History = append(db.History, t)
+	return nil
+}
+
+func (db *InMemoryDatabase) GetTransactions() ([]*Transaction, error) {
+	db.Lock()
+	defer db.Unlock()
+	return append([]*Transaction{}, db.History...), nil
+}
+
+func (db *InMemoryDatabase) GetTransaction(id int) (*Transaction, error) {
+	db.Lock()
+	defer db.Unlock()
+	if id < 1 || id > len(db.History) {
+		return nil, errors.New("No transaction found with that id")
+	}
+	return db.History[id-1], nil
+}
+
 func (db *InMemoryDatabase) RemovePackage(p Package) error {
 	db.Lock()
 	defer db.Unlock()
 
 	delete(db.Database, p.GetFingerPrint())
+	db.uncachePackage(p)
 	return nil
 }
 func (db *InMemoryDatabase) World() []Package {
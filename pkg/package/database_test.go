@@ -16,6 +16,10 @@
 package pkg_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
 	. "github.com/mudler/luet/pkg/package"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -122,6 +126,166 @@ var _ = Describe("Database", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(pack).To(Equal(a3))
 		})
+
+		It("Groups versions by slot", func() {
+			db := NewInMemoryDatabase(false)
+			a := NewPackage("A", "3.7", []*DefaultPackage{}, []*DefaultPackage{})
+			a.SetSlot("3.7")
+			a1 := NewPackage("A", "3.7.1", []*DefaultPackage{}, []*DefaultPackage{})
+			a1.SetSlot("3.7")
+			a2 := NewPackage("A", "3.8", []*DefaultPackage{}, []*DefaultPackage{})
+			a2.SetSlot("3.8")
+
+			for _, p := range []Package{a, a1, a2} {
+				_, err := db.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			versions, err := db.FindPackageVersions(a)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(versions).To(ConsistOf(a, a1))
+
+			versions, err = db.FindPackageVersions(a2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(versions).To(ConsistOf(a2))
+
+			s := NewPackage("A", ">=0", []*DefaultPackage{}, []*DefaultPackage{})
+			all, err := db.FindPackages(s)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(all).To(ConsistOf(a, a1, a2))
+
+			s.SetSlot("3.7")
+			pack, err := db.FindPackageCandidate(s)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pack).To(Equal(a1))
+		})
+	})
+
+	Context("Replacing packages", func() {
+		for _, engine := range []string{"memory", "boltdb"} {
+			engine := engine
+			It("Swaps packages and files with "+engine, func() {
+				var db PackageDatabase
+				if engine == "boltdb" {
+					tmpdir, err := ioutil.TempDir("", "db")
+					Expect(err).ToNot(HaveOccurred())
+					defer os.RemoveAll(tmpdir)
+					db = NewBoltDatabase(filepath.Join(tmpdir, "luet.db"))
+				} else {
+					db = NewInMemoryDatabase(false)
+				}
+
+				a := &DefaultPackage{Name: "A", Category: "test", Version: "1.0"}
+				a1 := &DefaultPackage{Name: "A", Category: "test", Version: "1.1"}
+				b := &DefaultPackage{Name: "B", Category: "test", Version: "1.0"}
+				for _, p := range []Package{a, b} {
+					_, err := db.CreatePackage(p)
+					Expect(err).ToNot(HaveOccurred())
+				}
+				Expect(db.SetPackageFiles(&PackageFile{PackageFingerprint: a.GetFingerPrint(), Files: []string{"a", "old"}})).ToNot(HaveOccurred())
+
+				Expect(db.ReplacePackage(a, a1, &PackageFile{PackageFingerprint: a1.GetFingerPrint(), Files: []string{"a", "new"}})).ToNot(HaveOccurred())
+
+				_, err := db.FindPackage(a)
+				Expect(err).To(HaveOccurred())
+				_, err = db.GetPackageFiles(a)
+				Expect(err).To(HaveOccurred())
+				pack, err := db.FindPackage(a1)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(pack.GetVersion()).To(Equal("1.1"))
+				files, err := db.GetPackageFiles(a1)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(files).To(Equal([]string{"a", "new"}))
+				Expect(len(db.World())).To(Equal(2))
+				versions, err := db.FindPackageVersions(a)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(len(versions)).To(Equal(1))
+
+				Expect(db.ReplacePackage(a, a1, &PackageFile{PackageFingerprint: a1.GetFingerPrint()})).ToNot(Succeed())
+			})
+
+			It("Drops the provides of the replaced package with "+engine, func() {
+				var db PackageDatabase
+				if engine == "boltdb" {
+					tmpdir, err := ioutil.TempDir("", "db")
+					Expect(err).ToNot(HaveOccurred())
+					defer os.RemoveAll(tmpdir)
+					db = NewBoltDatabase(filepath.Join(tmpdir, "luet.db"))
+				} else {
+					db = NewInMemoryDatabase(false)
+				}
+
+				virtual := &DefaultPackage{Name: "virtual", Category: "test", Version: "1.0"}
+				other := &DefaultPackage{Name: "other", Category: "test", Version: "1.0"}
+				a := &DefaultPackage{Name: "A", Category: "test", Version: "1.0", Provides: []*DefaultPackage{virtual}}
+				// Rebuilt in place, providing something else
+				rebuilt := &DefaultPackage{Name: "A", Category: "test", Version: "1.0", Provides: []*DefaultPackage{other}}
+				_, err := db.CreatePackage(a)
+				Expect(err).ToNot(HaveOccurred())
+				pack, err := db.FindPackage(virtual)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(pack.GetFingerPrint()).To(Equal(a.GetFingerPrint()))
+
+				Expect(db.ReplacePackage(a, rebuilt, &PackageFile{PackageFingerprint: rebuilt.GetFingerPrint()})).ToNot(HaveOccurred())
+
+				_, err = db.FindPackage(virtual)
+				Expect(err).To(HaveOccurred())
+				pack, err = db.FindPackage(other)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(pack.GetFingerPrint()).To(Equal(a.GetFingerPrint()))
+				Expect(pack.GetProvides()).To(Equal([]*DefaultPackage{other}))
+			})
+		}
+	})
+
+	Context("History", func() {
+		for _, engine := range []string{"memory", "boltdb"} {
+			engine := engine
+			It("Appends transactions with "+engine, func() {
+				var db PackageDatabase
+				if engine == "boltdb" {
+					tmpdir, err := ioutil.TempDir("", "db")
+					Expect(err).ToNot(HaveOccurred())
+					defer os.RemoveAll(tmpdir)
+					db = NewBoltDatabase(filepath.Join(tmpdir, "luet.db"))
+				} else {
+					db = NewInMemoryDatabase(false)
+				}
+
+				history, err := db.GetTransactions()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(history).To(BeEmpty())
+
+				a := &DefaultPackage{Name: "A", Category: "test", Version: "1.0", PackageRequires: []*DefaultPackage{{Name: "B"}}}
+				a1 := &DefaultPackage{Name: "A", Category: "test", Version: "1.1"}
+				for i := 0; i < 11; i++ {
+					t := &Transaction{Command: "luet install"}
+					t.Add(a)
+					if i%2 == 1 {
+						t.Replace(a, a1)
+						t.Error = "failed"
+					}
+					Expect(t.Empty()).To(BeFalse())
+					Expect(db.AddTransaction(t)).ToNot(HaveOccurred())
+					Expect(t.ID).To(Equal(i + 1))
+				}
+
+				history, err = db.GetTransactions()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(len(history)).To(Equal(11))
+				Expect(history[10].ID).To(Equal(11))
+
+				t, err := db.GetTransaction(2)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(t.Succeeded()).To(BeFalse())
+				Expect(t.Command).To(Equal("luet install"))
+				Expect(t.Added).To(Equal([]*DefaultPackage{{Name: "A", Category: "test", Version: "1.0"}}))
+				Expect(t.Replaced[0].To.GetVersion()).To(Equal("1.1"))
+
+				_, err = db.GetTransaction(12)
+				Expect(err).To(HaveOccurred())
+			})
+		}
 	})
 
 })
@@ -38,6 +38,7 @@ type Package interface {
 	Encode(PackageDatabase) (string, error)
 
 	BuildFormula(PackageDatabase, PackageDatabase) ([]bf.Formula, error)
+	FormulaVariables(PackageDatabase) []Package
 	IsFlagged(bool) Package
 	Flagged() bool
 	GetFingerPrint() string
@@ -45,17 +46,27 @@ type Package interface {
 	Requires([]*DefaultPackage) Package
 	Conflicts([]*DefaultPackage) Package
 	Revdeps(PackageDatabase) []Package
+	RequiredBy(Package) bool
+	RequirementChains(PackageDatabase, int) [][]Package
 
 	GetProvides() []*DefaultPackage
 	SetProvides([]*DefaultPackage) Package
 
 	GetRequires() []*DefaultPackage
 	GetConflicts() []*DefaultPackage
+	GetReplaces() []*DefaultPackage
+	GetObsoletes() []*DefaultPackage
+	Replaces([]*DefaultPackage) Package
+	Obsoletes([]*DefaultPackage) Package
+	IsReplacing(Package) bool
+	IsObsoleting(Package) bool
 	Expand(PackageDatabase) ([]Package, error)
 	SetCategory(string)
+	SetSlot(string)
 
 	GetName() string
 	GetCategory() string
+	GetSlot() string
 
 	GetVersion() string
 	RequiresContains(PackageDatabase, Package) (bool, error)
@@ -87,6 +98,7 @@ type Package interface {
 	SelectorMatchVersion(string) (bool, error)
 
 	String() string
+	HumanReadableString() string
 }
 
 type Tree interface {
@@ -134,6 +146,7 @@ type DefaultPackage struct {
 	Name             string   `json:"name"`                    // Affects YAML field names too.
 	Version          string   `json:"version"`                 // Affects YAML field names too.
 	Category         string   `json:"category"`                // Affects YAML field names too.
+	Slot             string   `json:"slot,omitempty"`          // Versions in different slots can be installed side by side
 	UseFlags         []string `json:"use_flags"`               // Affects YAML field names too.
 	State            State
 	PackageRequires  []*DefaultPackage `json:"requires"`  // Affects YAML field names too.
@@ -141,6 +154,12 @@ type DefaultPackage struct {
 	IsSet            bool              `json:"set"`       // Affects YAML field names too.
 	Provides         []*DefaultPackage `json:"provides"`  // Affects YAML field names too.
 
+	// Replaces lists the packages superseded by this one (e.g. after a rename or a category move):
+	// upgrades swap them with this package. Obsoletes lists packages which are removed
+	// when this one gets installed, without pulling it in.
+	PackageReplaces  []*DefaultPackage `json:"replaces,omitempty"`
+	PackageObsoletes []*DefaultPackage `json:"obsoletes,omitempty"`
+
 	// TODO: Annotations?
 
 	// Path is set only internally when tree is loaded from disk
@@ -167,9 +186,18 @@ func (p *DefaultPackage) String() string {
 	return fmt.Sprintf("%s", string(b))
 }
 
+// HumanReadableString returns the package in the category/name-version form
+func (p *DefaultPackage) HumanReadableString() string {
+	return fmt.Sprintf("%s/%s-%s", p.Category, p.Name, p.Version)
+}
+
 // GetFingerPrint returns a UUID of the package.
+// The slot is part of it, so the same version can be in more slots.
 // FIXME: this needs to be unique, now just name is generalized
 func (p *DefaultPackage) GetFingerPrint() string {
+	if p.Slot != "" {
+		return fmt.Sprintf("%s-%s-%s__%s", p.Name, p.Category, p.Version, p.Slot)
+	}
 	return fmt.Sprintf("%s-%s-%s", p.Name, p.Category, p.Version)
 }
 
@@ -227,6 +255,7 @@ func (p *DefaultPackage) RemoveUse(use string) {
 
 // Encode encodes the package to string.
 // It returns an ID which can be used to retrieve the package later on.
+// Packages in different slots are encoded to different IDs.
 func (p *DefaultPackage) Encode(db PackageDatabase) (string, error) {
 	return db.CreatePackage(p)
 }
@@ -285,6 +314,12 @@ func (p *DefaultPackage) GetCategory() string {
 func (p *DefaultPackage) SetCategory(s string) {
 	p.Category = s
 }
+func (p *DefaultPackage) GetSlot() string {
+	return p.Slot
+}
+func (p *DefaultPackage) SetSlot(s string) {
+	p.Slot = s
+}
 func (p *DefaultPackage) GetUses() []string {
 	return p.UseFlags
 }
@@ -301,6 +336,82 @@ func (p *DefaultPackage) GetRequires() []*DefaultPackage {
 func (p *DefaultPackage) GetConflicts() []*DefaultPackage {
 	return p.PackageConflicts
 }
+func (p *DefaultPackage) GetReplaces() []*DefaultPackage {
+	return p.PackageReplaces
+}
+func (p *DefaultPackage) GetObsoletes() []*DefaultPackage {
+	return p.PackageObsoletes
+}
+func (p *DefaultPackage) Replaces(req []*DefaultPackage) Package {
+	p.PackageReplaces = req
+	return p
+}
+func (p *DefaultPackage) Obsoletes(req []*DefaultPackage) Package {
+	p.PackageObsoletes = req
+	return p
+}
+
+// IsReplacing returns true if the package declares to replace m
+func (p *DefaultPackage) IsReplacing(m Package) bool {
+	for _, re := range p.GetReplaces() {
+		if re.RequirementMatches(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsObsoleting returns true if the package declares m as obsolete
+func (p *DefaultPackage) IsObsoleting(m Package) bool {
+	for _, re := range p.GetObsoletes() {
+		if re.RequirementMatches(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplacesIndex maps the names of the replaced packages to the packages which declare to replace them,
+// so the replacements of a package are found without scanning all the definitions.
+type ReplacesIndex map[string][]Package
+
+// NewReplacesIndex returns the ReplacesIndex of the given packages
+func NewReplacesIndex(world []Package) ReplacesIndex {
+	index := ReplacesIndex{}
+	for _, w := range world {
+		for _, re := range w.GetReplaces() {
+			index[re.GetPackageName()] = append(index[re.GetPackageName()], w)
+		}
+	}
+	return index
+}
+
+// ReplacementsOf returns the packages of the index which declare to replace p, p excluded
+func (index ReplacesIndex) ReplacementsOf(p Package) []Package {
+	var res []Package
+	names := []string{p.GetPackageName()}
+	for _, provide := range p.GetProvides() {
+		names = append(names, provide.GetPackageName())
+	}
+	for _, name := range names {
+		for _, w := range index[name] {
+			if !w.Matches(p) && w.IsReplacing(p) && !containsPackage(res, w) {
+				res = append(res, w)
+			}
+		}
+	}
+	return res
+}
+
+func containsPackage(ls []Package, p Package) bool {
+	for _, l := range ls {
+		if l.Matches(p) {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *DefaultPackage) Requires(req []*DefaultPackage) Package {
 	p.PackageRequires = req
 	return p
@@ -365,6 +476,99 @@ func (p *DefaultPackage) Revdeps(definitiondb PackageDatabase) []Package {
 	return versionsInWorld
 }
 
+// RequiredBy returns true if m requires the package, either directly or with a
+// selector matching its version. Requirements satisfied by provides of the
+// package are considered as well.
+func (p *DefaultPackage) RequiredBy(m Package) bool {
+	for _, re := range m.GetRequires() {
+		if re.RequirementMatches(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirementMatches returns true if the requirement (or conflict) is satisfied by p, either directly
+// or with a selector matching its version, or by one of the provides of p.
+func (re *DefaultPackage) RequirementMatches(p Package) bool {
+	if re.matchesCandidate(p) {
+		return true
+	}
+	for _, provide := range p.GetProvides() {
+		if re.matchesCandidate(provide) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesCandidate checks the requirement against a single package, matching
+// selectors against the candidate's own version.
+func (re *DefaultPackage) matchesCandidate(c Package) bool {
+	if re.Matches(c) {
+		return true
+	}
+	if re.GetName() != c.GetName() || re.GetCategory() != c.GetCategory() {
+		return false
+	}
+	if re.IsSelector() {
+		if match, err := re.SelectorMatchVersion(c.GetVersion()); err == nil && match {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirementChains returns the requirement chains from the root packages of the database
+// (the ones which aren't required by any other package) down to the package, up to max chains.
+// Each chain starts with a root package and ends with the package itself.
+func (p *DefaultPackage) RequirementChains(definitiondb PackageDatabase, max int) [][]Package {
+	world := definitiondb.World()
+	var chains [][]Package
+
+	// The packages requiring each package are looked up in the world once
+	requiredBy := map[string][]Package{}
+	parents := func(current Package) []Package {
+		if res, ok := requiredBy[current.GetFingerPrint()]; ok {
+			return res
+		}
+		var res []Package
+		for _, w := range world {
+			if !w.Matches(current) && current.RequiredBy(w) {
+				res = append(res, w)
+			}
+		}
+		requiredBy[current.GetFingerPrint()] = res
+		return res
+	}
+
+	var walk func(current Package, path []Package)
+	walk = func(current Package, path []Package) {
+		if len(chains) >= max {
+			return
+		}
+		path = append([]Package{current}, path...)
+		root := true
+	PARENTS:
+		for _, w := range parents(current) {
+			// Skip cycles
+			for _, seen := range path {
+				if seen.Matches(w) {
+					continue PARENTS
+				}
+			}
+			root = false
+			walk(w, path)
+		}
+		if root {
+			chains = append(chains, path)
+		}
+	}
+	walk(p, []Package{})
+
+	return chains
+}
+
 func DecodePackage(ID string, db PackageDatabase) (Package, error) {
 	return db.GetPackage(ID)
 }
@@ -441,6 +645,48 @@ func Best(set []Package) Package {
 	return versionsMap[versions[len(versions)-1].Original()]
 }
 
+// expandRelation returns the definitions matching the requirement or conflict r, along with the definition
+// of r used to look for the candidate among them. If none matches, r itself is returned.
+func expandRelation(definitiondb PackageDatabase, r *DefaultPackage) (*DefaultPackage, []Package) {
+	required, err := definitiondb.FindPackage(r)
+	if err == nil && !r.IsSelector() {
+		return r, []Package{required}
+	}
+	if err == nil {
+		r = required.(*DefaultPackage)
+	}
+	packages, err := definitiondb.FindPackages(r)
+	if err != nil || len(packages) == 0 {
+		return r, []Package{r}
+	}
+	return r, packages
+}
+
+// FormulaVariables returns the packages which are referenced by the formula of the package
+// (see BuildFormula), without encoding it.
+func (pack *DefaultPackage) FormulaVariables(definitiondb PackageDatabase) []Package {
+	var p Package = pack
+	if def, err := definitiondb.FindPackage(pack); err == nil {
+		p = def
+	}
+
+	var res []Package
+	for _, r := range p.GetRequires() {
+		_, packages := expandRelation(definitiondb, r)
+		// Only a single requirement is bound to the package, see BuildFormula
+		if len(packages) == 1 {
+			res = append(res, p)
+		}
+		res = append(res, packages...)
+	}
+	for _, r := range p.GetConflicts() {
+		_, packages := expandRelation(definitiondb, r)
+		res = append(res, p)
+		res = append(res, packages...)
+	}
+	return res
+}
+
 func (pack *DefaultPackage) BuildFormula(definitiondb PackageDatabase, db PackageDatabase) ([]bf.Formula, error) {
 	p, err := definitiondb.FindPackage(pack)
 	if err != nil {
@@ -454,75 +700,64 @@ func (pack *DefaultPackage) BuildFormula(definitiondb PackageDatabase, db Packag
 	A := bf.Var(encodedA)
 
 	var formulas []bf.Formula
-	for _, requiredDef := range p.GetRequires() {
-		required, err := definitiondb.FindPackage(requiredDef)
-		if err != nil || requiredDef.IsSelector() {
-			if err == nil {
-				requiredDef = required.(*DefaultPackage)
+	for _, r := range p.GetRequires() {
+		requiredDef, packages := expandRelation(definitiondb, r)
+		if len(packages) > 1 {
+			var ALO, priorityConstraints, priorityALO []bf.Formula
+
+			// Try to prio best match
+			// Force the solver to consider first our candidate (if does exists).
+			// Then builds ALO and AMO for the requires.
+			c, candidateErr := definitiondb.FindPackageCandidate(requiredDef)
+			var C bf.Formula
+			if candidateErr == nil {
+				// We have a desired candidate, try to look a solution with that included first
+				for _, o := range packages {
+					encodedB, err := o.Encode(db)
+					if err != nil {
+						return nil, err
+					}
+					B := bf.Var(encodedB)
+					if !o.Matches(c) {
+						priorityConstraints = append(priorityConstraints, bf.Not(B))
+						priorityALO = append(priorityALO, B)
+					}
+				}
+				encodedC, err := c.Encode(db)
+				if err != nil {
+					return nil, err
+				}
+				C = bf.Var(encodedC)
+				// Or the Candidate is true, or all the others might be not true
+				// This forces the CDCL sat implementation to look first at a solution with C=true
+				formulas = append(formulas, bf.Or(bf.Or(C, bf.Or(priorityConstraints...)), bf.Or(bf.Not(C), bf.Or(priorityALO...))))
 			}
 
-			packages, err := definitiondb.FindPackages(requiredDef)
-			if err != nil || len(packages) == 0 {
-				required = requiredDef
-			} else {
-				if len(packages) == 1 {
-					required = packages[0]
-				} else {
-					var ALO, priorityConstraints, priorityALO []bf.Formula
-
-					// Try to prio best match
-					// Force the solver to consider first our candidate (if does exists).
-					// Then builds ALO and AMO for the requires.
-					c, candidateErr := definitiondb.FindPackageCandidate(requiredDef)
-					var C bf.Formula
-					if candidateErr == nil {
-						// We have a desired candidate, try to look a solution with that included first
-						for _, o := range packages {
-							encodedB, err := o.Encode(db)
-							if err != nil {
-								return nil, err
-							}
-							B := bf.Var(encodedB)
-							if !o.Matches(c) {
-								priorityConstraints = append(priorityConstraints, bf.Not(B))
-								priorityALO = append(priorityALO, B)
-							}
-						}
-						encodedC, err := c.Encode(db)
-						if err != nil {
-							return nil, err
-						}
-						C = bf.Var(encodedC)
-						// Or the Candidate is true, or all the others might be not true
-						// This forces the CDCL sat implementation to look first at a solution with C=true
-						formulas = append(formulas, bf.Or(bf.Or(C, bf.Or(priorityConstraints...)), bf.Or(bf.Not(C), bf.Or(priorityALO...))))
+			// AMO - At most one
+			for _, o := range packages {
+				encodedB, err := o.Encode(db)
+				if err != nil {
+					return nil, err
+				}
+				B := bf.Var(encodedB)
+				ALO = append(ALO, B)
+				for _, i := range packages {
+					encodedI, err := i.Encode(db)
+					if err != nil {
+						return nil, err
 					}
-
-					// AMO - At most one
-					for _, o := range packages {
-						encodedB, err := o.Encode(db)
-						if err != nil {
-							return nil, err
-						}
-						B := bf.Var(encodedB)
-						ALO = append(ALO, B)
-						for _, i := range packages {
-							encodedI, err := i.Encode(db)
-							if err != nil {
-								return nil, err
-							}
-							I := bf.Var(encodedI)
-							if !o.Matches(i) {
-								formulas = append(formulas, bf.Or(bf.Not(I), bf.Not(B)))
-							}
-						}
+					I := bf.Var(encodedI)
+					// Versions in different slots can coexist
+					if !o.Matches(i) && o.GetSlot() == i.GetSlot() {
+						formulas = append(formulas, bf.Or(bf.Not(I), bf.Not(B)))
 					}
-					formulas = append(formulas, bf.Or(ALO...)) // ALO - At least one
-					continue
 				}
 			}
+			formulas = append(formulas, bf.Or(ALO...)) // ALO - At least one
+			continue
 		}
 
+		required := packages[0]
 		encodedB, err := required.Encode(db)
 		if err != nil {
 			return nil, err
@@ -538,39 +773,28 @@ func (pack *DefaultPackage) BuildFormula(definitiondb PackageDatabase, db Packag
 
 	}
 
-	for _, requiredDef := range p.GetConflicts() {
-		required, err := definitiondb.FindPackage(requiredDef)
-		if err != nil || requiredDef.IsSelector() {
-			if err == nil {
-				requiredDef = required.(*DefaultPackage)
-			}
-			packages, err := definitiondb.FindPackages(requiredDef)
-			if err != nil || len(packages) == 0 {
-				required = requiredDef
-			} else {
-				if len(packages) == 1 {
-					required = packages[0]
-				} else {
-					for _, p := range packages {
-						encodedB, err := p.Encode(db)
-						if err != nil {
-							return nil, err
-						}
-						B := bf.Var(encodedB)
-						formulas = append(formulas, bf.Or(bf.Not(A),
-							bf.Not(B)))
-
-						f, err := p.BuildFormula(definitiondb, db)
-						if err != nil {
-							return nil, err
-						}
-						formulas = append(formulas, f...)
-					}
-					continue
+	for _, r := range p.GetConflicts() {
+		_, packages := expandRelation(definitiondb, r)
+		if len(packages) > 1 {
+			for _, p := range packages {
+				encodedB, err := p.Encode(db)
+				if err != nil {
+					return nil, err
+				}
+				B := bf.Var(encodedB)
+				formulas = append(formulas, bf.Or(bf.Not(A),
+					bf.Not(B)))
+
+				f, err := p.BuildFormula(definitiondb, db)
+				if err != nil {
+					return nil, err
 				}
+				formulas = append(formulas, f...)
 			}
+			continue
 		}
 
+		required := packages[0]
 		encodedB, err := required.Encode(db)
 		if err != nil {
 			return nil, err
@@ -588,7 +812,6 @@ func (pack *DefaultPackage) BuildFormula(definitiondb PackageDatabase, db Packag
 	}
 	return formulas, nil
 }
-
 func (p *DefaultPackage) Explain() {
 
 	fmt.Println("====================")
@@ -122,6 +122,99 @@ var _ = Describe("Package", func() {
 		})
 	})
 
+	Context("Requirements satisfied by provides", func() {
+		It("Matches selectors against the provided version", func() {
+			a := NewPackage("A", "1.0", []*DefaultPackage{}, []*DefaultPackage{})
+			a.SetProvides([]*DefaultPackage{&DefaultPackage{Name: "virtual", Version: "2.0"}})
+			b := NewPackage("B", "1.0", []*DefaultPackage{&DefaultPackage{Name: "virtual", Version: ">=2.0"}}, []*DefaultPackage{})
+			c := NewPackage("C", "1.0", []*DefaultPackage{&DefaultPackage{Name: "virtual", Version: "<2.0"}}, []*DefaultPackage{})
+
+			Expect(a.RequiredBy(b)).To(BeTrue())
+			Expect(a.RequiredBy(c)).To(BeFalse())
+		})
+	})
+
+	Context("Requirement chains", func() {
+		a := NewPackage("A", "1.0", []*DefaultPackage{}, []*DefaultPackage{})
+		b := NewPackage("B", "1.0", []*DefaultPackage{a}, []*DefaultPackage{})
+		c := NewPackage("C", "1.1", []*DefaultPackage{b}, []*DefaultPackage{})
+		d := NewPackage("D", "0.1", []*DefaultPackage{&DefaultPackage{Name: "A", Version: ">=1.0"}}, []*DefaultPackage{})
+		e := NewPackage("E", "0.1", []*DefaultPackage{}, []*DefaultPackage{})
+
+		It("Computes correctly", func() {
+			definitions := NewInMemoryDatabase(false)
+			for _, p := range []Package{a, b, c, d, e} {
+				_, err := definitions.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(a.RequiredBy(b)).To(BeTrue())
+			Expect(a.RequiredBy(d)).To(BeTrue())
+			Expect(a.RequiredBy(c)).To(BeFalse())
+			Expect(b.RequiredBy(a)).To(BeFalse())
+
+			chains := a.RequirementChains(definitions, 10)
+			Expect(len(chains)).To(Equal(2))
+			Expect(chains).To(ContainElement([]Package{c, b, a}))
+			Expect(chains).To(ContainElement([]Package{d, a}))
+
+			chains = e.RequirementChains(definitions, 10)
+			Expect(chains).To(Equal([][]Package{[]Package{e}}))
+		})
+
+		It("Stops at the maximum number of chains", func() {
+			definitions := NewInMemoryDatabase(false)
+			for _, p := range []Package{a, b, c, d, e} {
+				_, err := definitions.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			chains := a.RequirementChains(definitions, 1)
+			Expect(len(chains)).To(Equal(1))
+			Expect(chains[0][len(chains[0])-1]).To(Equal(a))
+		})
+	})
+
+	Context("Replaces and obsoletes", func() {
+		It("Decodes and matches correctly", func() {
+			p, err := DefaultPackageFromYaml([]byte(`
+name: "foo"
+category: "net"
+version: "1.1"
+replaces:
+- name: "foo"
+  category: "sys"
+  version: "<1.1"
+obsoletes:
+- name: "foo-compat"
+  category: "sys"
+  version: "1.0"
+`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(p.GetReplaces())).To(Equal(1))
+			Expect(len(p.GetObsoletes())).To(Equal(1))
+
+			old := &DefaultPackage{Name: "foo", Category: "sys", Version: "1.0"}
+			newer := &DefaultPackage{Name: "foo", Category: "sys", Version: "1.2"}
+			compat := &DefaultPackage{Name: "foo-compat", Category: "sys", Version: "1.0"}
+			Expect(p.IsReplacing(old)).To(BeTrue())
+			Expect(p.IsReplacing(newer)).To(BeFalse())
+			Expect(p.IsObsoleting(compat)).To(BeTrue())
+			Expect(p.IsObsoleting(old)).To(BeFalse())
+		})
+
+		It("Indexes the replacements", func() {
+			old := &DefaultPackage{Name: "foo", Category: "sys", Version: "1.0"}
+			renamed := &DefaultPackage{Name: "bar", Category: "sys", Version: "2.0"}
+			renamed.Replaces([]*DefaultPackage{{Name: "foo", Category: "sys", Version: "<2.0"}})
+			other := &DefaultPackage{Name: "baz", Category: "sys", Version: "1.0"}
+			other.Replaces([]*DefaultPackage{{Name: "qux", Category: "sys", Version: ">=0"}})
+
+			index := NewReplacesIndex([]Package{old, renamed, other})
+			Expect(index.ReplacementsOf(old)).To(Equal([]Package{renamed}))
+			Expect(index.ReplacementsOf(&DefaultPackage{Name: "foo", Category: "sys", Version: "3.0"})).To(BeEmpty())
+			Expect(index.ReplacementsOf(renamed)).To(BeEmpty())
+		})
+	})
+
 	Context("RequiresContains", func() {
 		a := NewPackage("A", ">=1.0", []*DefaultPackage{}, []*DefaultPackage{})
 		a1 := NewPackage("A", "1.0", []*DefaultPackage{a}, []*DefaultPackage{})
@@ -0,0 +1,65 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package pkg
+
+import "time"
+
+// Transaction is a record of the system history: the packages added, removed and replaced
+// by an operation, along with its outcome
+type Transaction struct {
+	ID        int       `storm:"id,increment" json:"id"` // primary key with auto increment
+	Timestamp time.Time `json:"timestamp"`
+	Command   string    `json:"command"`
+	// Operation is the kind of transaction, e.g. install, uninstall or upgrade
+	Operation string                `json:"operation"`
+	Added     []*DefaultPackage     `json:"added"`
+	Removed   []*DefaultPackage     `json:"removed"`
+	Replaced  []*PackageReplacement `json:"replaced"`
+	// Error is empty if the transaction succeeded
+	Error string `json:"error,omitempty"`
+}
+
+// PackageReplacement is a package replaced by another one, or by itself when it's installed again
+type PackageReplacement struct {
+	From *DefaultPackage `json:"from"`
+	To   *DefaultPackage `json:"to"`
+}
+
+// transactionPackage returns the identity of p, as stored in the transactions
+func transactionPackage(p Package) *DefaultPackage {
+	return &DefaultPackage{Name: p.GetName(), Category: p.GetCategory(), Version: p.GetVersion(), Slot: p.GetSlot()}
+}
+
+func (t *Transaction) Add(p Package) {
+	t.Added = append(t.Added, transactionPackage(p))
+}
+
+func (t *Transaction) Remove(p Package) {
+	t.Removed = append(t.Removed, transactionPackage(p))
+}
+
+func (t *Transaction) Replace(from, to Package) {
+	t.Replaced = append(t.Replaced, &PackageReplacement{From: transactionPackage(from), To: transactionPackage(to)})
+}
+
+// Empty returns true if the transaction didn't change any package
+func (t *Transaction) Empty() bool {
+	return len(t.Added) == 0 && len(t.Removed) == 0 && len(t.Replaced) == 0
+}
+
+func (t *Transaction) Succeeded() bool {
+	return t.Error == ""
+}
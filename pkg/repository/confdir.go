@@ -0,0 +1,113 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package repository
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+
+	. "github.com/mudler/luet/pkg/config"
+)
+
+var validRepoName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// FindRepositoryFile returns the file of the repositories configuration directories
+// which defines the repository name, or an empty string if there is none.
+func FindRepositoryFile(c *LuetConfig, name string) (string, *LuetRepository, error) {
+	var regexRepo = regexp.MustCompile(`.yml$`)
+
+	for _, rdir := range c.RepositoriesConfDir {
+		files, err := ioutil.ReadDir(rdir)
+		if err != nil {
+			continue
+		}
+
+		for _, file := range files {
+			if file.IsDir() || !regexRepo.MatchString(file.Name()) {
+				continue
+			}
+
+			content, err := ioutil.ReadFile(filepath.Join(rdir, file.Name()))
+			if err != nil {
+				return "", nil, errors.Wrap(err, "Error reading file "+file.Name())
+			}
+			r, err := LoadRepository(content)
+			if err != nil {
+				continue
+			}
+			if r.Name == name {
+				return filepath.Join(rdir, file.Name()), r, nil
+			}
+		}
+	}
+	return "", nil, nil
+}
+
+// SaveRepository persists the repository r. A repository already defined in the
+// repositories configuration directories is updated in place, a new one is written
+// to <name>.yml in the first directory.
+func SaveRepository(c *LuetConfig, r *LuetRepository) error {
+	if !validRepoName.MatchString(r.Name) {
+		return errors.New("Invalid repository name " + r.Name)
+	}
+	if len(c.RepositoriesConfDir) == 0 {
+		return errors.New("No repositories configuration directory (repos_confdir) configured")
+	}
+
+	file, _, err := FindRepositoryFile(c, r.Name)
+	if err != nil {
+		return err
+	}
+	if file == "" {
+		if err := os.MkdirAll(c.RepositoriesConfDir[0], os.ModePerm); err != nil {
+			return errors.Wrap(err, "Error creating directory "+c.RepositoriesConfDir[0])
+		}
+		file = filepath.Join(c.RepositoriesConfDir[0], r.Name+".yml")
+	}
+
+	// Serialized only options are not part of the configuration
+	repo := *r
+	repo.Revision = 0
+	repo.LastUpdate = ""
+	data, err := yaml.Marshal(&repo)
+	if err != nil {
+		return errors.Wrap(err, "Error serializing repository "+r.Name)
+	}
+
+	tmp := filepath.Join(filepath.Dir(file), "."+filepath.Base(file)+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Wrap(err, "Error writing file "+tmp)
+	}
+	return os.Rename(tmp, file)
+}
+
+// RemoveRepository deletes the file defining the repository name from the
+// repositories configuration directories.
+func RemoveRepository(c *LuetConfig, name string) error {
+	file, _, err := FindRepositoryFile(c, name)
+	if err != nil {
+		return err
+	}
+	if file == "" {
+		return errors.New("Repository " + name + " is not defined in the repositories configuration directories")
+	}
+	return os.Remove(file)
+}
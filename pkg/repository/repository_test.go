@@ -17,6 +17,10 @@
 package repository_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
 	. "github.com/mudler/luet/pkg/config"
 	. "github.com/mudler/luet/pkg/repository"
 
@@ -43,4 +47,64 @@ var _ = Describe("Repository", func() {
 			Expect(cfg.SystemRepositories[0].Urls[0]).Should(Equal("tests/repos/test1"))
 		})
 	})
+
+	Context("Edit repositories configuration", func() {
+		var cfg *LuetConfig
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "repos.conf.d")
+			Expect(err).ToNot(HaveOccurred())
+			cfg = NewLuetConfig(viper.New())
+			cfg.RepositoriesConfDir = []string{filepath.Join(dir, "first"), filepath.Join(dir, "second")}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("Writes new repositories in the first directory", func() {
+			r := NewLuetRepository("foo", "http", "", []string{"https://example.com"}, 10, true, true)
+			Expect(SaveRepository(cfg, r)).Should(BeNil())
+			Expect(filepath.Join(dir, "first", "foo.yml")).Should(BeAnExistingFile())
+
+			Expect(LoadRepositories(cfg)).Should(BeNil())
+			Expect(len(cfg.SystemRepositories)).Should(Equal(1))
+			Expect(cfg.SystemRepositories[0].Name).Should(Equal("foo"))
+			Expect(cfg.SystemRepositories[0].Priority).Should(Equal(10))
+			Expect(cfg.SystemRepositories[0].Enable).Should(BeTrue())
+			Expect(cfg.SystemRepositories[0].Urls).Should(Equal([]string{"https://example.com"}))
+		})
+
+		It("Updates and removes existing definitions", func() {
+			Expect(os.MkdirAll(filepath.Join(dir, "second"), os.ModePerm)).Should(BeNil())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "second", "custom.yml"), []byte(`
+name: "bar"
+type: "disk"
+urls:
+  - "/srv/bar"
+enable: true
+`), os.ModePerm)).Should(BeNil())
+
+			file, r, err := FindRepositoryFile(cfg, "bar")
+			Expect(err).Should(BeNil())
+			Expect(file).Should(Equal(filepath.Join(dir, "second", "custom.yml")))
+
+			r.Enable = false
+			Expect(SaveRepository(cfg, r)).Should(BeNil())
+			Expect(filepath.Join(dir, "first", "bar.yml")).ShouldNot(BeAnExistingFile())
+			_, r, err = FindRepositoryFile(cfg, "bar")
+			Expect(err).Should(BeNil())
+			Expect(r.Enable).Should(BeFalse())
+
+			Expect(RemoveRepository(cfg, "bar")).Should(BeNil())
+			Expect(file).ShouldNot(BeAnExistingFile())
+			Expect(RemoveRepository(cfg, "bar")).ShouldNot(BeNil())
+		})
+
+		It("Rejects invalid names", func() {
+			Expect(SaveRepository(cfg, NewLuetRepository("../foo", "disk", "", []string{"/srv"}, 1, true, false))).ShouldNot(BeNil())
+		})
+	})
 })
@@ -0,0 +1,115 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package solver_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/crillab/gophersat/bf"
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/mudler/luet/pkg/solver"
+)
+
+const (
+	benchChains      = 200
+	benchChainLength = 10
+)
+
+// benchWorld returns a definition database composed by independent chains of packages,
+// where each package requires the next one of its chain, and the head of the first chain.
+func benchWorld() (pkg.PackageDatabase, pkg.Package) {
+	definitions := pkg.NewInMemoryDatabase(false)
+	var head pkg.Package
+
+	for c := 0; c < benchChains; c++ {
+		var next *pkg.DefaultPackage
+		for l := benchChainLength; l > 0; l-- {
+			requires := []*pkg.DefaultPackage{}
+			if next != nil {
+				requires = append(requires, &pkg.DefaultPackage{Name: next.GetName(), Category: "bench", Version: ">=1.0"})
+			}
+			p := pkg.NewPackage(fmt.Sprintf("chain%d-%d", c, l), "1.0", requires, []*pkg.DefaultPackage{})
+			p.SetCategory("bench")
+			definitions.CreatePackage(p)
+			next = p
+		}
+		if c == 0 {
+			head = next
+		}
+	}
+	return definitions, head
+}
+
+func benchSolver(definitions pkg.PackageDatabase, head pkg.Package) *Solver {
+	s := NewSolver(pkg.NewInMemoryDatabase(false), definitions, pkg.NewInMemoryDatabase(false)).(*Solver)
+	s.Wanted = []pkg.Package{head}
+	return s
+}
+
+func BenchmarkBuildWorld(b *testing.B) {
+	definitions, head := benchWorld()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := benchSolver(definitions, head).BuildWorld(false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBuildPartialWorld(b *testing.B) {
+	definitions, head := benchWorld()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := benchSolver(definitions, head).BuildPartialWorld(false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSolveWorld solves the formula built over the whole world, as the solver did before scoping
+func BenchmarkSolveWorld(b *testing.B) {
+	definitions, head := benchWorld()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := benchSolver(definitions, head)
+		r, err := s.BuildWorld(false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		encoded, err := head.Encode(s.SolverDatabase)
+		if err != nil {
+			b.Fatal(err)
+		}
+		model := bf.Solve(bf.And(r, bf.Var(encoded)))
+		if model == nil {
+			b.Fatal("unsolvable")
+		}
+		if _, err := DecodeModel(model, s.SolverDatabase); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInstall(b *testing.B) {
+	definitions, head := benchWorld()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := benchSolver(definitions, head).Install([]pkg.Package{head}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
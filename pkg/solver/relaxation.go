@@ -0,0 +1,339 @@
+// Copyright © 2020 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package solver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/crillab/gophersat/bf"
+	gsolver "github.com/crillab/gophersat/solver"
+	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/pkg/errors"
+)
+
+// DroppedPackage is a wanted package which was left out by the MinimalRelaxationResolver
+type DroppedPackage struct {
+	Package pkg.Package
+	// ConflictsWith is the minimal set of kept wanted packages (or installed ones)
+	// which prevents the package to be installed
+	ConflictsWith []pkg.Package
+	// Installed is true when the conflict is with the installed packages
+	Installed bool
+}
+
+func (d DroppedPackage) String() string {
+	if len(d.ConflictsWith) == 0 {
+		return fmt.Sprintf("%s: requirements can't be satisfied", d.Package.HumanReadableString())
+	}
+	var names []string
+	for _, c := range d.ConflictsWith {
+		names = append(names, c.HumanReadableString())
+	}
+	if d.Installed {
+		return fmt.Sprintf("%s: conflicts with the installed %s", d.Package.HumanReadableString(), strings.Join(names, ", "))
+	}
+	return fmt.Sprintf("%s: conflicts with %s", d.Package.HumanReadableString(), strings.Join(names, ", "))
+}
+
+// MinimalRelaxationResolver is a deterministic PackageResolver which, in case the wanted packages
+// can't be installed together, looks for a maximal satisfiable subset of them.
+//
+// The formula of the wanted and installed packages is converted to CNF once, and each wanted
+// package is then checked as an assumption over it. While the assumed set is unsatisfiable, a
+// minimal unsatisfiable core is extracted by deletion and one of its packages is dropped; the
+// dropped packages are then given a chance to be added back, so no package is left out if it
+// could be installed with the kept ones.
+//
+// MaxAttempts bounds the number of SAT calls of the core extraction. When it is exceeded the
+// resolver falls back to add the wanted packages one by one in fingerprint order, which costs
+// a single call for each of them.
+type MinimalRelaxationResolver struct {
+	MaxAttempts int
+
+	attempts int
+	problem  *cnfProblem
+	solver   *Solver
+	dropped  []DroppedPackage
+}
+
+func NewMinimalRelaxationResolver(MaxAttempts int) PackageResolver {
+	if MaxAttempts <= 0 {
+		MaxAttempts = DefaultMaxAttempts
+	}
+	return &MinimalRelaxationResolver{MaxAttempts: MaxAttempts}
+}
+
+// Dropped returns the wanted packages which were left out by the last resolution, along with the reason
+func (resolver *MinimalRelaxationResolver) Dropped() []DroppedPackage {
+	return resolver.dropped
+}
+
+func (resolver *MinimalRelaxationResolver) Solve(f bf.Formula, s PackageSolver) (PackagesAssertions, error) {
+	var ok bool
+	resolver.solver, ok = s.(*Solver)
+	if !ok {
+		return nil, errors.New("Minimal relaxation resolver supports only the default solver")
+	}
+	resolver.attempts = 0
+	resolver.dropped = []DroppedPackage{}
+
+	s.SetResolver(&DummyPackageResolver{}) // Set dummy, otherwise the final solve would run the resolver again.
+	defer s.SetResolver(resolver)
+
+	targets := append([]pkg.Package{}, resolver.solver.Wanted...)
+	sort.SliceStable(targets, func(i, j int) bool {
+		return targets[i].GetFingerPrint() < targets[j].GetFingerPrint()
+	})
+
+	var err error
+	resolver.problem, err = resolver.buildProblem(targets)
+	if err != nil {
+		return nil, err
+	}
+
+	// Targets which can't be installed alone are dropped regardless
+	var candidates []pkg.Package
+	for _, t := range targets {
+		if resolver.satisfiable([]pkg.Package{t}) {
+			candidates = append(candidates, t)
+			continue
+		}
+		d := DroppedPackage{Package: t, Installed: true}
+		if blockers, err := resolver.solver.ConflictingPackages(t, resolver.solver.Installed()); err == nil {
+			d.ConflictsWith = blockers
+		}
+		resolver.dropped = append(resolver.dropped, d)
+	}
+
+	kept := resolver.maximalSubset(candidates)
+	for _, c := range candidates {
+		if containsPackage(kept, c) {
+			continue
+		}
+		resolver.dropped = append(resolver.dropped, DroppedPackage{Package: c, ConflictsWith: resolver.core(kept, []pkg.Package{c})})
+	}
+
+	if len(kept) == 0 {
+		var reasons []string
+		for _, d := range resolver.dropped {
+			reasons = append(reasons, d.String())
+		}
+		return nil, errors.New("Could not satisfy the constraints: " + strings.Join(reasons, "; "))
+	}
+
+	resolver.solver.Wanted = kept
+	return resolver.solver.Solve()
+}
+
+// buildProblem returns the CNF of the formula of the targets and the installed packages, with the
+// installed ones asserted. The targets are left free, as they are assumed by each check.
+func (resolver *MinimalRelaxationResolver) buildProblem(targets []pkg.Package) (*cnfProblem, error) {
+	s := resolver.solver
+	formulas := []bf.Formula{}
+
+	roots := append([]pkg.Package{}, targets...)
+	roots = append(roots, s.Installed()...)
+	world, err := s.buildScopedWorld(roots)
+	if err != nil {
+		return nil, err
+	}
+	formulas = append(formulas, world)
+
+	for _, i := range s.Installed() {
+		encodedI, err := i.Encode(s.SolverDatabase)
+		if err != nil {
+			return nil, err
+		}
+		formulas = append(formulas, bf.Var(encodedI))
+	}
+
+	problem, err := newCNFProblem(bf.And(formulas...))
+	if err != nil {
+		return nil, err
+	}
+
+	problem.assumptions = map[string]int{}
+	for _, t := range targets {
+		encodedT, err := t.Encode(s.SolverDatabase)
+		if err != nil {
+			return nil, err
+		}
+		problem.assumptions[t.GetFingerPrint()] = problem.vars[encodedT]
+	}
+	return problem, nil
+}
+
+func (resolver *MinimalRelaxationResolver) satisfiable(set []pkg.Package) bool {
+	resolver.attempts++
+	var assumptions []int
+	for _, p := range set {
+		// Packages which don't appear in the formula are unconstrained
+		if v := resolver.problem.assumptions[p.GetFingerPrint()]; v != 0 {
+			assumptions = append(assumptions, v)
+		}
+	}
+	return resolver.problem.Solve(assumptions)
+}
+
+// maximalSubset returns a subset of candidates which can be installed together and that can't
+// be extended with any of the remaining candidates.
+func (resolver *MinimalRelaxationResolver) maximalSubset(candidates []pkg.Package) []pkg.Package {
+	kept := append([]pkg.Package{}, candidates...)
+	var removed []pkg.Package
+	// Number of cores each candidate was found in, the most conflicting ones are dropped first
+	conflicts := map[string]int{}
+
+	for !resolver.satisfiable(kept) {
+		if resolver.attempts >= resolver.MaxAttempts {
+			return resolver.greedySubset(candidates)
+		}
+
+		core := resolver.core(kept, []pkg.Package{})
+		if len(core) == 0 {
+			return []pkg.Package{}
+		}
+		drop := core[0]
+		for _, c := range core {
+			conflicts[c.GetFingerPrint()]++
+		}
+		for _, c := range core[1:] {
+			if conflicts[c.GetFingerPrint()] > conflicts[drop.GetFingerPrint()] {
+				drop = c
+			}
+		}
+
+		removed = append(removed, drop)
+		kept = withoutPackage(kept, drop)
+	}
+
+	// Give the dropped packages a chance to be added back, as removing the
+	// following ones might have solved their conflicts as well
+	for _, r := range removed {
+		if resolver.satisfiable(append(append([]pkg.Package{}, kept...), r)) {
+			kept = append(kept, r)
+		}
+	}
+
+	sort.SliceStable(kept, func(i, j int) bool {
+		return kept[i].GetFingerPrint() < kept[j].GetFingerPrint()
+	})
+	return kept
+}
+
+// greedySubset adds the candidates one by one, keeping the ones which don't break satisfiability.
+// It is the bounded fallback of maximalSubset: it needs one call for each candidate, but which
+// candidates are kept depends only on their order.
+func (resolver *MinimalRelaxationResolver) greedySubset(candidates []pkg.Package) []pkg.Package {
+	kept := []pkg.Package{}
+	for _, c := range candidates {
+		if resolver.satisfiable(append(append([]pkg.Package{}, kept...), c)) {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// core returns a minimal subset of set which, assumed along with required, is unsatisfiable.
+// Each package is removed in turn, and put back only if the rest becomes satisfiable without it.
+func (resolver *MinimalRelaxationResolver) core(set, required []pkg.Package) []pkg.Package {
+	core := append([]pkg.Package{}, set...)
+	for _, p := range set {
+		candidate := withoutPackage(core, p)
+		if !resolver.satisfiable(append(append([]pkg.Package{}, required...), candidate...)) {
+			core = candidate
+		}
+	}
+	return core
+}
+
+func withoutPackage(set []pkg.Package, p pkg.Package) []pkg.Package {
+	var res []pkg.Package
+	for _, s := range set {
+		if !s.Matches(p) {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// cnfProblem is a formula converted to CNF, which can be solved multiple times
+// assuming different literals true without converting it again.
+type cnfProblem struct {
+	clauses [][]int
+	// vars maps the formula variables to their CNF index
+	vars map[string]int
+	// assumptions maps the fingerprints of the targets to their CNF index
+	assumptions map[string]int
+}
+
+func newCNFProblem(f bf.Formula) (*cnfProblem, error) {
+	var buf bytes.Buffer
+	if err := bf.Dimacs(f, &buf); err != nil {
+		return nil, err
+	}
+
+	problem := &cnfProblem{vars: map[string]int{}}
+	scanner := bufio.NewScanner(&buf)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "" || strings.HasPrefix(line, "p "):
+		case strings.HasPrefix(line, "c "):
+			// Variable names are in the form "c name=index"
+			idx := strings.LastIndex(line, "=")
+			if idx < 0 {
+				return nil, errors.New("Invalid DIMACS variable line: " + line)
+			}
+			v, err := strconv.Atoi(line[idx+1:])
+			if err != nil {
+				return nil, errors.Wrap(err, "Invalid DIMACS variable line")
+			}
+			problem.vars[line[2:idx]] = v
+		default:
+			fields := strings.Fields(line)
+			clause := make([]int, 0, len(fields))
+			for _, field := range fields {
+				lit, err := strconv.Atoi(field)
+				if err != nil {
+					return nil, errors.Wrap(err, "Invalid DIMACS clause")
+				}
+				if lit != 0 {
+					clause = append(clause, lit)
+				}
+			}
+			problem.clauses = append(problem.clauses, clause)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return problem, nil
+}
+
+// Solve returns true if the problem is satisfiable with all the given variables assumed true
+func (problem *cnfProblem) Solve(assumptions []int) bool {
+	clauses := problem.clauses[:len(problem.clauses):len(problem.clauses)]
+	for _, a := range assumptions {
+		clauses = append(clauses, []int{a})
+	}
+	return gsolver.New(gsolver.ParseSlice(clauses)).Solve() == gsolver.Sat
+}
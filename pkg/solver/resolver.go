@@ -48,7 +48,8 @@ const (
 	DefaultDiscount        = 1.0
 	DefaultInitialObserved = 999999
 
-	QLearningResolverType = "qlearning"
+	QLearningResolverType         = "qlearning"
+	MinimalRelaxationResolverType = "minimal"
 )
 
 //. "github.com/mudler/luet/pkg/logger"
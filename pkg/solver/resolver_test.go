@@ -16,6 +16,8 @@
 package solver_test
 
 import (
+	"fmt"
+
 	pkg "github.com/mudler/luet/pkg/package"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -177,6 +179,118 @@ var _ = Describe("Resolver", func() {
 			})
 
 		})
+
+		Context("MinimalRelaxationResolver", func() {
+			It("drops the packages conflicting with the installed ones", func() {
+				resolver := NewMinimalRelaxationResolver(DefaultMaxAttempts)
+				s.SetResolver(resolver)
+				C := pkg.NewPackage("C", "", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+				B := pkg.NewPackage("B", "", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{C})
+				A := pkg.NewPackage("A", "", []*pkg.DefaultPackage{B}, []*pkg.DefaultPackage{})
+				D := pkg.NewPackage("D", "", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+				E := pkg.NewPackage("E", "", []*pkg.DefaultPackage{B}, []*pkg.DefaultPackage{})
+				F := pkg.NewPackage("F", "", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+
+				for _, p := range []pkg.Package{A, B, C, D, E, F} {
+					_, err := dbDefinitions.CreatePackage(p)
+					Expect(err).ToNot(HaveOccurred())
+				}
+
+				for _, p := range []pkg.Package{C} {
+					_, err := dbInstalled.CreatePackage(p)
+					Expect(err).ToNot(HaveOccurred())
+				}
+
+				solution, err := s.Install([]pkg.Package{A, D, E, F})
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(solution).To(ContainElement(PackageAssert{Package: A, Value: false}))
+				Expect(solution).To(ContainElement(PackageAssert{Package: C, Value: true}))
+				Expect(solution).To(ContainElement(PackageAssert{Package: D, Value: true}))
+				Expect(solution).To(ContainElement(PackageAssert{Package: E, Value: false}))
+				Expect(solution).To(ContainElement(PackageAssert{Package: F, Value: true}))
+
+				dropped := resolver.(*MinimalRelaxationResolver).Dropped()
+				Expect(len(dropped)).To(Equal(2))
+				Expect(dropped[0].Package.GetName()).To(Equal("A"))
+				Expect(dropped[0].Installed).To(BeTrue())
+				Expect(dropped[0].ConflictsWith).To(Equal([]pkg.Package{C}))
+				Expect(dropped[1].Package.GetName()).To(Equal("E"))
+			})
+
+			It("keeps the largest set of wanted packages, deterministically", func() {
+				resolver := NewMinimalRelaxationResolver(DefaultMaxAttempts)
+				s.SetResolver(resolver)
+				A := pkg.NewPackage("A", "", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+				B := pkg.NewPackage("B", "", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{A})
+				C := pkg.NewPackage("C", "", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{A})
+				D := pkg.NewPackage("D", "", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+
+				for _, p := range []pkg.Package{A, B, C, D} {
+					_, err := dbDefinitions.CreatePackage(p)
+					Expect(err).ToNot(HaveOccurred())
+				}
+
+				for i := 0; i < 5; i++ {
+					solution, err := s.Install([]pkg.Package{D, C, B, A})
+					Expect(err).ToNot(HaveOccurred())
+
+					Expect(solution).To(ContainElement(PackageAssert{Package: A, Value: false}))
+					Expect(solution).To(ContainElement(PackageAssert{Package: B, Value: true}))
+					Expect(solution).To(ContainElement(PackageAssert{Package: C, Value: true}))
+					Expect(solution).To(ContainElement(PackageAssert{Package: D, Value: true}))
+
+					dropped := resolver.(*MinimalRelaxationResolver).Dropped()
+					Expect(len(dropped)).To(Equal(1))
+					Expect(dropped[0].Package.GetName()).To(Equal("A"))
+					Expect(dropped[0].ConflictsWith).To(Equal([]pkg.Package{C}))
+				}
+			})
+
+			It("fails with the reasons when nothing can be installed", func() {
+				s.SetResolver(NewMinimalRelaxationResolver(DefaultMaxAttempts))
+				C := pkg.NewPackage("C", "", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+				B := pkg.NewPackage("B", "", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{C})
+				A := pkg.NewPackage("A", "", []*pkg.DefaultPackage{B}, []*pkg.DefaultPackage{})
+
+				for _, p := range []pkg.Package{A, B, C} {
+					_, err := dbDefinitions.CreatePackage(p)
+					Expect(err).ToNot(HaveOccurred())
+				}
+				_, err := dbInstalled.CreatePackage(C)
+				Expect(err).ToNot(HaveOccurred())
+
+				_, err = s.Install([]pkg.Package{A})
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("conflicts with the installed"))
+			})
+
+			It("drops one package for each conflicting pair in big sets", func() {
+				resolver := NewMinimalRelaxationResolver(DefaultMaxAttempts)
+				s.SetResolver(resolver)
+
+				var wanted []pkg.Package
+				for i := 0; i < 20; i++ {
+					X := pkg.NewPackage(fmt.Sprintf("X%02d", i), "", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+					Y := pkg.NewPackage(fmt.Sprintf("Y%02d", i), "", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{X})
+					for _, p := range []pkg.Package{X, Y} {
+						_, err := dbDefinitions.CreatePackage(p)
+						Expect(err).ToNot(HaveOccurred())
+					}
+					wanted = append(wanted, X, Y)
+				}
+
+				solution, err := s.Install(wanted)
+				Expect(err).ToNot(HaveOccurred())
+
+				dropped := resolver.(*MinimalRelaxationResolver).Dropped()
+				Expect(len(dropped)).To(Equal(20))
+				for _, d := range dropped {
+					Expect(len(d.ConflictsWith)).To(Equal(1))
+					Expect(solution).ToNot(ContainElement(PackageAssert{Package: d.Package.(*pkg.DefaultPackage), Value: true}))
+				}
+			})
+		})
 	})
 
 })
@@ -31,8 +31,11 @@ type PackageSolver interface {
 	Uninstall(candidate pkg.Package) ([]pkg.Package, error)
 	ConflictsWithInstalled(p pkg.Package) (bool, error)
 	ConflictsWith(p pkg.Package, ls []pkg.Package) (bool, error)
+	ConflictingPackages(p pkg.Package, ls []pkg.Package) ([]pkg.Package, error)
 	World() []pkg.Package
 	Upgrade() ([]pkg.Package, PackagesAssertions, error)
+	UpgradePackages(p []pkg.Package) ([]pkg.Package, PackagesAssertions, error)
+	Replace(old []pkg.Package, new []pkg.Package) (PackagesAssertions, error)
 
 	SetResolver(PackageResolver)
 
@@ -47,6 +50,9 @@ type Solver struct {
 	InstalledDatabase  pkg.PackageDatabase
 
 	Resolver PackageResolver
+
+	// worldVariables caches the packages referenced by the formulas of the whole world
+	worldVariables []pkg.Package
 }
 
 // NewSolver accepts as argument two lists of packages, the first is the initial set,
@@ -65,6 +71,7 @@ func NewResolver(installed pkg.PackageDatabase, definitiondb pkg.PackageDatabase
 
 func (s *Solver) SetDefinitionDatabase(db pkg.PackageDatabase) {
 	s.DefinitionDatabase = db
+	s.worldVariables = nil
 }
 
 // SetResolver is a setter for the unsat resolver backend
@@ -130,6 +137,80 @@ func (s *Solver) BuildWorld(includeInstalled bool) (bf.Formula, error) {
 	return bf.And(formulas...), nil
 }
 
+// BuildPartialWorld builds the formula which olds the requirements only of the package definitions
+// which are reachable from the wanted and the installed packages.
+func (s *Solver) BuildPartialWorld(includeInstalled bool) (bf.Formula, error) {
+	var formulas []bf.Formula
+	if includeInstalled {
+		solvable, err := s.BuildInstalled()
+		if err != nil {
+			return nil, err
+		}
+		formulas = append(formulas, solvable)
+	}
+
+	roots := append([]pkg.Package{}, s.Wanted...)
+	roots = append(roots, s.Installed()...)
+	r, err := s.buildScopedWorld(roots)
+	if err != nil {
+		return nil, err
+	}
+	if len(formulas) == 0 {
+		return r, nil
+	}
+	return bf.And(append(formulas, r)...), nil
+}
+
+// scope returns the transitive closure of the packages reachable from roots
+// following requires and conflicts (expanding selectors and provides).
+func (s *Solver) scope(roots []pkg.Package) []pkg.Package {
+	var res []pkg.Package
+	visited := map[string]interface{}{}
+
+	queue := append([]pkg.Package{}, roots...)
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+
+		if def, err := s.DefinitionDatabase.FindPackage(p); err == nil {
+			p = def
+		}
+		if _, ok := visited[p.GetFingerPrint()]; ok {
+			continue
+		}
+		visited[p.GetFingerPrint()] = nil
+		res = append(res, p)
+
+		for _, r := range append(p.GetRequires(), p.GetConflicts()...) {
+			if def, err := s.DefinitionDatabase.FindPackage(r); err == nil { // Provides: Get a chance of being override here
+				queue = append(queue, def)
+			}
+			if packages, err := s.DefinitionDatabase.FindPackages(r); err == nil {
+				queue = append(queue, packages...)
+			}
+		}
+	}
+
+	return res
+}
+
+func (s *Solver) buildScopedWorld(roots []pkg.Package) (bf.Formula, error) {
+	var formulas []bf.Formula
+
+	for _, p := range s.scope(roots) {
+		solvable, err := p.BuildFormula(s.DefinitionDatabase, s.SolverDatabase)
+		if err != nil {
+			return nil, err
+		}
+		formulas = append(formulas, solvable...)
+	}
+	// An empty conjunction would be unsatisfiable
+	if len(formulas) == 0 {
+		return bf.True, nil
+	}
+	return bf.And(formulas...), nil
+}
+
 func (s *Solver) getList(db pkg.PackageDatabase, lsp []pkg.Package) ([]pkg.Package, error) {
 	var ls []pkg.Package
 
@@ -174,7 +255,7 @@ func (s *Solver) ConflictsWith(pack pkg.Package, lsp []pkg.Package) (bool, error
 	}
 	P := bf.Var(encodedP)
 
-	r, err := s.BuildWorld(false)
+	r, err := s.buildScopedWorld(append([]pkg.Package{p}, ls...))
 	if err != nil {
 		return false, err
 	}
@@ -210,7 +291,114 @@ func (s *Solver) ConflictsWithInstalled(p pkg.Package) (bool, error) {
 	return s.ConflictsWith(p, s.Installed())
 }
 
+// ConflictingPackages returns the minimal subset of ls which prevents p to be installed.
+// An empty list is returned if p can be installed alongside ls.
+// Returns error if p can't be installed at all, regardless of ls.
+func (s *Solver) ConflictingPackages(pack pkg.Package, lsp []pkg.Package) ([]pkg.Package, error) {
+	p, err := s.DefinitionDatabase.FindPackage(pack)
+	if err != nil {
+		p = pack //Relax search, otherwise we cannot compute solutions for packages not in definitions
+	}
+
+	ls, err := s.getList(s.DefinitionDatabase, lsp)
+	if err != nil {
+		return nil, errors.Wrap(err, "Package not found in definition db")
+	}
+
+	r, err := s.buildScopedWorld(append([]pkg.Package{p}, ls...))
+	if err != nil {
+		return nil, err
+	}
+	// Take also into account packages which are not part of the definitions
+	solvable, err := p.BuildFormula(s.DefinitionDatabase, s.SolverDatabase)
+	if err != nil {
+		return nil, err
+	}
+	world := append([]bf.Formula{r}, solvable...)
+
+	encodedP, err := p.Encode(s.SolverDatabase)
+	if err != nil {
+		return nil, err
+	}
+	P := bf.Var(encodedP)
+
+	satisfiable := func(set []pkg.Package) (bool, error) {
+		formulas := append([]bf.Formula{P}, world...)
+		for _, i := range set {
+			encodedI, err := i.Encode(s.SolverDatabase)
+			if err != nil {
+				return false, err
+			}
+			formulas = append(formulas, bf.Var(encodedI))
+		}
+		return bf.Solve(bf.And(formulas...)) != nil, nil
+	}
+
+	sat, err := satisfiable([]pkg.Package{})
+	if err != nil {
+		return nil, err
+	}
+	if !sat {
+		return nil, errors.New("Package " + p.GetFingerPrint() + " requirements can't be satisfied")
+	}
+
+	var set []pkg.Package
+	for _, i := range ls {
+		if !i.Matches(p) {
+			set = append(set, i)
+		}
+	}
+	if sat, err := satisfiable(set); err != nil || sat {
+		return []pkg.Package{}, err
+	}
+
+	// Shrink the set, dropping every package which isn't needed to make the formula unsat.
+	core := set
+	for _, i := range set {
+		var candidate []pkg.Package
+		for _, c := range core {
+			if !c.Matches(i) {
+				candidate = append(candidate, c)
+			}
+		}
+		sat, err := satisfiable(candidate)
+		if err != nil {
+			return nil, err
+		}
+		if !sat {
+			core = candidate
+		}
+	}
+
+	return core, nil
+}
+
 func (s *Solver) Upgrade() ([]pkg.Package, PackagesAssertions, error) {
+	return s.upgrade(nil)
+}
+
+// UpgradePackages computes the upgrade of the installed packages with the same name of the given ones,
+// leaving the other installed packages untouched unless the solution requires them.
+func (s *Solver) UpgradePackages(p []pkg.Package) ([]pkg.Package, PackagesAssertions, error) {
+	if len(p) == 0 {
+		return nil, nil, errors.New("No packages to upgrade")
+	}
+	return s.upgrade(p)
+}
+
+// upgrade computes the upgrade of the installed packages matching selected, or all of them if selected is nil
+func (s *Solver) upgrade(selected []pkg.Package) ([]pkg.Package, PackagesAssertions, error) {
+	isSelected := func(p pkg.Package) bool {
+		if selected == nil {
+			return true
+		}
+		for _, sel := range selected {
+			if sel.GetPackageName() == p.GetPackageName() {
+				return true
+			}
+		}
+		return false
+	}
 
 	// First get candidates that needs to be upgraded..
 
@@ -218,16 +406,35 @@ func (s *Solver) Upgrade() ([]pkg.Package, PackagesAssertions, error) {
 	toInstall := []pkg.Package{}
 
 	availableCache := map[string][]pkg.Package{}
-	for _, p := range s.DefinitionDatabase.World() {
+	world := s.DefinitionDatabase.World()
+	replaces := pkg.NewReplacesIndex(world)
+	for _, p := range world {
 		// Each one, should be expanded
-		availableCache[p.GetName()+p.GetCategory()] = append(availableCache[p.GetName()+p.GetCategory()], p)
+		// Group by slot as well, so each slot is upgraded independently
+		key := p.GetPackageName() + ":" + p.GetSlot()
+		availableCache[key] = append(availableCache[key], p)
 	}
 
 	installedcopy := pkg.NewInMemoryDatabase(false)
 
-	for _, p := range s.InstalledDatabase.World() {
+	installed := s.InstalledDatabase.World()
+	for _, p := range installed {
 		installedcopy.CreatePackage(p)
-		packages, ok := availableCache[p.GetName()+p.GetCategory()]
+		if !isSelected(p) {
+			continue
+		}
+
+		// Packages renamed or moved: swap the old identity with the best replacement
+		if replacements := replaces.ReplacementsOf(p); len(replacements) != 0 {
+			best := pkg.Best(replacements)
+			toUninstall = append(toUninstall, p)
+			if !containsPackage(installed, best) && !containsPackage(toInstall, best) {
+				toInstall = append(toInstall, best)
+			}
+			continue
+		}
+
+		packages, ok := availableCache[p.GetPackageName()+":"+p.GetSlot()]
 		if ok && len(packages) != 0 {
 			best := pkg.Best(packages)
 			if best.GetVersion() != p.GetVersion() {
@@ -237,6 +444,24 @@ func (s *Solver) Upgrade() ([]pkg.Package, PackagesAssertions, error) {
 		}
 	}
 
+	// Remove the packages obsoleted by the ones which are going to be in the system.
+	// On targeted upgrades only the new packages are considered
+	obsoleting := append([]pkg.Package{}, toInstall...)
+	if selected == nil {
+		obsoleting = append(obsoleting, installed...)
+	}
+	for _, p := range installed {
+		if containsPackage(toUninstall, p) {
+			continue
+		}
+		for _, o := range obsoleting {
+			if o.IsObsoleting(p) && !containsPackage(toUninstall, o) {
+				toUninstall = append(toUninstall, p)
+				break
+			}
+		}
+	}
+
 	s2 := NewSolver(installedcopy, s.DefinitionDatabase, pkg.NewInMemoryDatabase(false))
 	s2.SetResolver(s.Resolver)
 	// Then try to uninstall the versions in the system, and store that tree
@@ -260,6 +485,55 @@ func (s *Solver) Upgrade() ([]pkg.Package, PackagesAssertions, error) {
 
 }
 
+func containsPackage(ls []pkg.Package, p pkg.Package) bool {
+	for _, l := range ls {
+		if l.Matches(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Replace computes the solution which replaces the installed old packages with the new ones.
+// The installed packages depending on the old ones are required as well, so the new packages
+// have to satisfy them. Old and new can be the same packages, to install them again.
+func (s *Solver) Replace(old []pkg.Package, new []pkg.Package) (PackagesAssertions, error) {
+	installedcopy := pkg.NewInMemoryDatabase(false)
+	for _, p := range s.InstalledDatabase.World() {
+		installedcopy.CreatePackage(p)
+	}
+
+	wanted := append([]pkg.Package{}, new...)
+	for _, p := range old {
+		r, err := s.Uninstall(p)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not compute replacement of "+p.GetFingerPrint())
+		}
+		for _, z := range r {
+			installedcopy.RemovePackage(z)
+			if !containsPackage(old, z) && !containsPackage(wanted, z) {
+				wanted = append(wanted, z)
+			}
+		}
+	}
+
+	s2 := NewSolver(installedcopy, s.DefinitionDatabase, pkg.NewInMemoryDatabase(false))
+	s2.SetResolver(s.Resolver)
+	solution, err := s2.Install(wanted)
+	if err != nil {
+		return nil, err
+	}
+
+	// Versions don't exclude each other: if an old package is still in the solution,
+	// it's required by the ones which are staying in the system
+	for _, a := range solution {
+		if a.Value && containsPackage(old, a.Package) && !containsPackage(new, a.Package) {
+			return nil, errors.New("Package " + a.Package.GetFingerPrint() + " is still required by the installed packages")
+		}
+	}
+	return solution, nil
+}
+
 // Uninstall takes a candidate package and return a list of packages that would be removed
 // in order to purge the candidate. Returns error if unsat.
 func (s *Solver) Uninstall(c pkg.Package) ([]pkg.Package, error) {
@@ -336,7 +610,7 @@ func (s *Solver) Uninstall(c pkg.Package) ([]pkg.Package, error) {
 // BuildFormula builds the main solving formula that is evaluated by the sat solver.
 func (s *Solver) BuildFormula() (bf.Formula, error) {
 	var formulas []bf.Formula
-	r, err := s.BuildWorld(false)
+	r, err := s.BuildPartialWorld(false)
 	if err != nil {
 		return nil, err
 	}
@@ -397,7 +671,37 @@ func (s *Solver) Solve() (PackagesAssertions, error) {
 		return nil, err
 	}
 
-	return DecodeModel(model, s.SolverDatabase)
+	ass, err := DecodeModel(model, s.SolverDatabase)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.withOutOfScope(ass), nil
+}
+
+// withOutOfScope adds the packages which were left out by the scoped formula as not installed,
+// so the assertions carry the same packages as the ones computed over the whole world.
+func (s *Solver) withOutOfScope(ass PackagesAssertions) PackagesAssertions {
+	if s.worldVariables == nil {
+		s.worldVariables = []pkg.Package{}
+		for _, p := range s.World() {
+			s.worldVariables = append(s.worldVariables, p.FormulaVariables(s.DefinitionDatabase)...)
+		}
+	}
+
+	inModel := map[string]interface{}{}
+	for _, a := range ass {
+		inModel[a.Package.GetFingerPrint()] = nil
+	}
+	for _, v := range s.worldVariables {
+		if _, ok := inModel[v.GetFingerPrint()]; ok {
+			continue
+		}
+		inModel[v.GetFingerPrint()] = nil
+		ass = append(ass, PackageAssert{Package: v.(*pkg.DefaultPackage), Value: false})
+	}
+
+	return ass
 }
 
 // Install given a list of packages, returns package assertions to indicate the packages that must be installed in the system in order
@@ -810,6 +810,34 @@ var _ = Describe("Solver", func() {
 
 	})
 
+	Context("Blocking packages", func() {
+		It("Finds the installed packages which prevent the installation", func() {
+			C := pkg.NewPackage("C", "", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+			D := pkg.NewPackage("D", "", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+			B := pkg.NewPackage("B", "", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{C})
+			A := pkg.NewPackage("A", "", []*pkg.DefaultPackage{B}, []*pkg.DefaultPackage{})
+
+			for _, p := range []pkg.Package{A, B, C, D} {
+				_, err := dbDefinitions.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			for _, p := range []pkg.Package{C, D} {
+				_, err := dbInstalled.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			s = NewSolver(dbInstalled, dbDefinitions, db)
+
+			blockers, err := s.ConflictingPackages(A, []pkg.Package{C, D})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(blockers).To(Equal([]pkg.Package{C}))
+
+			blockers, err = s.ConflictingPackages(D, []pkg.Package{C})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(blockers)).To(Equal(0))
+		})
+	})
+
 	Context("Complex data sets", func() {
 		It("Solves them correctly", func() {
 			C := pkg.NewPackage("C", "", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
@@ -906,5 +934,205 @@ var _ = Describe("Solver", func() {
 			Expect(len(solution)).To(Equal(3))
 
 		})
+
+		It("upgrades only the selected packages", func() {
+			B1 := pkg.NewPackage("b", "1.1", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+			B1.SetCategory("test")
+			for _, p := range []pkg.Package{A, A1, B, B1} {
+				_, err := dbDefinitions.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			for _, p := range []pkg.Package{A, B} {
+				_, err := dbInstalled.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			uninstall, solution, err := s.UpgradePackages([]pkg.Package{&pkg.DefaultPackage{Name: "a", Category: "test"}})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(len(uninstall)).To(Equal(1))
+			Expect(uninstall[0].GetVersion()).To(Equal("1.1"))
+			Expect(solution).To(ContainElement(PackageAssert{Package: A1, Value: true}))
+			Expect(solution).To(ContainElement(PackageAssert{Package: B, Value: true}))
+			Expect(solution).ToNot(ContainElement(PackageAssert{Package: B1, Value: true}))
+		})
+	})
+
+	Context("Replacements", func() {
+		B := pkg.NewPackage("b", "1.0", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+		B.SetCategory("test")
+		B1 := pkg.NewPackage("b", "1.1", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+		B1.SetCategory("test")
+		A := pkg.NewPackage("a", "1.0", []*pkg.DefaultPackage{&pkg.DefaultPackage{Name: "b", Version: ">=1.0", Category: "test"}}, []*pkg.DefaultPackage{})
+		A.SetCategory("test")
+		C := pkg.NewPackage("c", "1.0", []*pkg.DefaultPackage{&pkg.DefaultPackage{Name: "b", Version: ">=1.1", Category: "test"}}, []*pkg.DefaultPackage{})
+		C.SetCategory("test")
+
+		It("replaces packages keeping the reverse dependencies", func() {
+			for _, p := range []pkg.Package{A, B, B1, C} {
+				_, err := dbDefinitions.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			for _, p := range []pkg.Package{A, B1} {
+				_, err := dbInstalled.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			solution, err := s.Replace([]pkg.Package{B1}, []pkg.Package{B})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(solution).To(ContainElement(PackageAssert{Package: A, Value: true}))
+			Expect(solution).To(ContainElement(PackageAssert{Package: B, Value: true}))
+			Expect(solution).To(ContainElement(PackageAssert{Package: B1, Value: false}))
+		})
+
+		It("fails when reverse dependencies can't be satisfied", func() {
+			for _, p := range []pkg.Package{A, B, B1, C} {
+				_, err := dbDefinitions.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			for _, p := range []pkg.Package{C, B1} {
+				_, err := dbInstalled.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			solution, err := s.Replace([]pkg.Package{B1}, []pkg.Package{B})
+			Expect(err).To(HaveOccurred(), "%v", solution)
+		})
+
+		It("installs the same packages again", func() {
+			for _, p := range []pkg.Package{A, B, B1} {
+				_, err := dbDefinitions.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			for _, p := range []pkg.Package{A, B1} {
+				_, err := dbInstalled.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			solution, err := s.Replace([]pkg.Package{B1}, []pkg.Package{B1})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(solution).To(ContainElement(PackageAssert{Package: A, Value: true}))
+			Expect(solution).To(ContainElement(PackageAssert{Package: B1, Value: true}))
+		})
+	})
+
+	Context("Slots", func() {
+		It("installs versions in different slots side by side", func() {
+			D := pkg.NewPackage("D", "3.7", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+			D.SetSlot("3.7")
+			D1 := pkg.NewPackage("D", "3.8", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+			D1.SetSlot("3.8")
+			A := pkg.NewPackage("A", "", []*pkg.DefaultPackage{&pkg.DefaultPackage{Name: "D", Version: ">=3.0", Slot: "3.7"}}, []*pkg.DefaultPackage{})
+			B := pkg.NewPackage("B", "", []*pkg.DefaultPackage{&pkg.DefaultPackage{Name: "D", Version: ">=3.0", Slot: "3.8"}}, []*pkg.DefaultPackage{})
+
+			for _, p := range []pkg.Package{A, B, D, D1} {
+				_, err := dbDefinitions.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			s = NewSolver(dbInstalled, dbDefinitions, db)
+
+			solution, err := s.Install([]pkg.Package{A, B})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(solution).To(ContainElement(PackageAssert{Package: A, Value: true}))
+			Expect(solution).To(ContainElement(PackageAssert{Package: B, Value: true}))
+			Expect(solution).To(ContainElement(PackageAssert{Package: D, Value: true}))
+			Expect(solution).To(ContainElement(PackageAssert{Package: D1, Value: true}))
+		})
+
+		It("installs the same version in two slots", func() {
+			D := pkg.NewPackage("D", "1.0", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+			D.SetSlot("a")
+			D1 := pkg.NewPackage("D", "1.0", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+			D1.SetSlot("b")
+			A := pkg.NewPackage("A", "", []*pkg.DefaultPackage{&pkg.DefaultPackage{Name: "D", Version: ">=1.0", Slot: "a"}}, []*pkg.DefaultPackage{})
+			B := pkg.NewPackage("B", "", []*pkg.DefaultPackage{&pkg.DefaultPackage{Name: "D", Version: ">=1.0", Slot: "b"}}, []*pkg.DefaultPackage{})
+			Expect(D.GetFingerPrint()).ToNot(Equal(D1.GetFingerPrint()))
+
+			for _, p := range []pkg.Package{A, B, D, D1} {
+				_, err := dbDefinitions.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			s = NewSolver(dbInstalled, dbDefinitions, db)
+
+			solution, err := s.Install([]pkg.Package{A, B})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(solution).To(ContainElement(PackageAssert{Package: D, Value: true}))
+			Expect(solution).To(ContainElement(PackageAssert{Package: D1, Value: true}))
+		})
+
+		It("upgrades each slot independently", func() {
+			D := pkg.NewPackage("D", "3.7", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+			D.SetSlot("3.7")
+			D1 := pkg.NewPackage("D", "3.7.1", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+			D1.SetSlot("3.7")
+			D2 := pkg.NewPackage("D", "3.8", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+			D2.SetSlot("3.8")
+
+			for _, p := range []pkg.Package{D1, D2} {
+				_, err := dbDefinitions.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			for _, p := range []pkg.Package{D, D2} {
+				_, err := dbInstalled.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			s = NewSolver(dbInstalled, dbDefinitions, db)
+
+			uninstall, solution, err := s.Upgrade()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(uninstall)).To(Equal(1))
+			Expect(uninstall[0].GetVersion()).To(Equal("3.7"))
+			Expect(solution).To(ContainElement(PackageAssert{Package: D1, Value: true}))
+		})
+	})
+
+	Context("Replaces and obsoletes", func() {
+		It("swaps a replaced package on upgrade", func() {
+			Old := pkg.NewPackage("foo", "1.0", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+			Old.SetCategory("sys")
+			New := pkg.NewPackage("foo", "1.1", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+			New.SetCategory("net")
+			New.Replaces([]*pkg.DefaultPackage{&pkg.DefaultPackage{Name: "foo", Category: "sys", Version: ">=0"}})
+
+			for _, p := range []pkg.Package{Old, New} {
+				_, err := dbDefinitions.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			_, err := dbInstalled.CreatePackage(Old)
+			Expect(err).ToNot(HaveOccurred())
+			s = NewSolver(dbInstalled, dbDefinitions, db)
+
+			uninstall, solution, err := s.Upgrade()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(uninstall)).To(Equal(1))
+			Expect(uninstall[0].GetCategory()).To(Equal("sys"))
+			Expect(solution).To(ContainElement(PackageAssert{Package: New, Value: true}))
+		})
+
+		It("removes obsoleted packages only when the new one is installed", func() {
+			Old := pkg.NewPackage("bar", "1.0", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+			New := pkg.NewPackage("baz", "1.0", []*pkg.DefaultPackage{}, []*pkg.DefaultPackage{})
+			New.Obsoletes([]*pkg.DefaultPackage{&pkg.DefaultPackage{Name: "bar", Version: ">=0"}})
+
+			for _, p := range []pkg.Package{Old, New} {
+				_, err := dbDefinitions.CreatePackage(p)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			_, err := dbInstalled.CreatePackage(Old)
+			Expect(err).ToNot(HaveOccurred())
+			s = NewSolver(dbInstalled, dbDefinitions, db)
+
+			uninstall, _, err := s.Upgrade()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(uninstall)).To(Equal(0))
+
+			_, err = dbInstalled.CreatePackage(New)
+			Expect(err).ToNot(HaveOccurred())
+
+			uninstall, _, err = s.Upgrade()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(uninstall)).To(Equal(1))
+			Expect(uninstall[0].GetName()).To(Equal("bar"))
+		})
 	})
 })
@@ -48,7 +48,7 @@ func (r *InstallerRecipe) Save(path string) error {
 
 	for _, p := range r.Database.World() {
 
-		dir := filepath.Join(path, p.GetCategory(), p.GetName(), p.GetVersion())
+		dir := DefinitionDir(path, p)
 		os.MkdirAll(dir, os.ModePerm)
 		data, err := p.Yaml()
 		if err != nil {
@@ -33,6 +33,16 @@ const (
 	DefinitionFile = "definition.yaml"
 )
 
+// DefinitionDir returns the directory of the definition of the package in the tree at path.
+// Packages in different slots of the same version are stored in different directories.
+func DefinitionDir(path string, p pkg.Package) string {
+	version := p.GetVersion()
+	if p.GetSlot() != "" {
+		version += "__" + p.GetSlot()
+	}
+	return filepath.Join(path, p.GetCategory(), p.GetName(), version)
+}
+
 func NewGeneralRecipe(db pkg.PackageDatabase) Builder { return &Recipe{Database: db} }
 
 // Recipe is the "general" reciper for Trees
@@ -44,7 +54,7 @@ type Recipe struct {
 func (r *Recipe) Save(path string) error {
 
 	for _, p := range r.Database.World() {
-		dir := filepath.Join(path, p.GetCategory(), p.GetName(), p.GetVersion())
+		dir := DefinitionDir(path, p)
 		os.MkdirAll(dir, os.ModePerm)
 		data, err := p.Yaml()
 		if err != nil {